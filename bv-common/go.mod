@@ -0,0 +1,3 @@
+module github.com/whopdan/bv-common
+
+go 1.14
@@ -0,0 +1,172 @@
+// Package hmacauth authenticates requests between the bv-job-* services with HMAC keys issued to each caller.
+// A caller sends its key id (X-BV-Key-ID), the time it signed the request in unix seconds (X-BV-Timestamp), a
+// random nonce unique to the request (X-BV-Nonce), and the hex encoded HMAC-SHA256 of
+// "<timestamp>\n<nonce>\n<method>\n<path>\n<body>" keyed with its secret (X-BV-Signature). A nonce is accepted
+// once per caller within the replay window, so identical requests signed in the same second are both accepted
+// while a captured request can't be replayed.
+package hmacauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// HdrKeyID is the request header carrying the caller's key id
+	HdrKeyID = "X-BV-Key-ID"
+	// HdrTimestamp is the request header carrying the time (unix seconds) the request was signed
+	HdrTimestamp = "X-BV-Timestamp"
+	// HdrNonce is the request header carrying the request's nonce
+	HdrNonce = "X-BV-Nonce"
+	// HdrSignature is the request header carrying the request's signature
+	HdrSignature = "X-BV-Signature"
+
+	cntMaxNonceLen = 64 // longest nonce accepted
+)
+
+// Signature computes the signature of a request
+func Signature(secret, ts, nonce, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%v\n%v\n%v\n%v\n", ts, nonce, method, path)))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign signs a request with a caller's key id and secret; the request is left unsigned if no key is given
+func Sign(req *http.Request, body []byte, keyID, secret string) error {
+	var nonce = make([]byte, 16)
+
+	if len(keyID) == 0 || len(secret) == 0 {
+		return nil
+	}
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		// error reading the random source
+		return fmt.Errorf("error generating a request nonce; see: %v", err)
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HdrKeyID, keyID)
+	req.Header.Set(HdrTimestamp, ts)
+	req.Header.Set(HdrNonce, hex.EncodeToString(nonce))
+	req.Header.Set(HdrSignature, Signature(secret, ts, hex.EncodeToString(nonce), req.Method, req.URL.Path, body))
+
+	return nil
+}
+
+// Verifier verifies the signed requests of the callers holding one of its keys
+type Verifier struct {
+	keys     map[string]string // caller key id -> secret
+	window   time.Duration     // time a signed request remains valid (replay window)
+	required bool              // reject every request if no keys are configured
+
+	sync.Mutex
+	seen map[string]time.Time // key id and nonce -> time it expires from the replay window
+}
+
+// NewVerifier returns a verifier of requests signed with one of the caller keys (key id -> secret) within the
+// replay window; with no keys configured every request is rejected unless authentication is not required
+func NewVerifier(keys map[string]string, window time.Duration, required bool) *Verifier {
+	return &Verifier{keys: keys, window: window, required: required, seen: make(map[string]time.Time)}
+}
+
+// replayed records a caller's nonce; returns true if the nonce was already accepted within the replay window
+func (v *Verifier) replayed(key string, expires time.Time) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	// Forget nonces that have left the replay window
+	for k, exp := range v.seen {
+		if time.Now().After(exp) {
+			delete(v.seen, k)
+		}
+	}
+
+	if _, fnd := v.seen[key]; fnd {
+		return true
+	}
+	v.seen[key] = expires
+
+	return false
+}
+
+// Verify verifies the signature of a request (its body is restored for the handler); returns the key id of the
+// authenticated caller (empty if authentication isn't required and no keys are configured) or an error giving
+// the reason the request is rejected
+func (v *Verifier) Verify(req *http.Request) (string, error) {
+	var (
+		err    error
+		secs   int64
+		body   []byte
+		secret string
+		fnd    bool
+		keyID  = req.Header.Get(HdrKeyID)
+		ts     = req.Header.Get(HdrTimestamp)
+		nonce  = req.Header.Get(HdrNonce)
+		sig    = req.Header.Get(HdrSignature)
+	)
+
+	switch {
+
+	// CASE: authentication not required and no keys configured
+	case !v.required && len(v.keys) == 0:
+		return "", nil
+
+	// CASE: no caller keys configured
+	case len(v.keys) == 0:
+		return "", fmt.Errorf("no caller keys are configured")
+
+	// CASE: unsigned request
+	case len(keyID) == 0 || len(ts) == 0 || len(nonce) == 0 || len(sig) == 0:
+		return "", fmt.Errorf("missing signature headers")
+
+	// CASE: oversized nonce
+	case len(nonce) > cntMaxNonceLen:
+		return "", fmt.Errorf("invalid nonce")
+	}
+
+	secret, fnd = v.keys[keyID]
+	if !fnd {
+		return "", fmt.Errorf("unknown key id: %v", keyID)
+	}
+
+	// Signed within the replay window?
+	secs, err = strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp: %v", ts)
+	}
+	if math.Abs(time.Since(time.Unix(secs, 0)).Seconds()) > v.window.Seconds() {
+		return "", fmt.Errorf("timestamp: %v is outside the %v replay window", ts, v.window)
+	}
+
+	// Read the body (and restore it for the handler)
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return "", fmt.Errorf("error reading the request body: %v", err)
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(Signature(secret, ts, nonce, req.Method, req.URL.Path, body))) {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	if v.replayed(keyID+"\n"+nonce, time.Unix(secs, 0).Add(v.window)) {
+		return "", fmt.Errorf("replayed request")
+	}
+
+	return keyID, nil
+}
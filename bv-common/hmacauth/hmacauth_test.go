@@ -0,0 +1,99 @@
+package hmacauth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signedRequest returns a request signed by a caller
+func signedRequest(t *testing.T, keyID, secret string, body []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/checkhalt", bytes.NewReader(body))
+	err := Sign(req, body, keyID, secret)
+	if err != nil {
+		t.Fatalf("error signing a request: %v", err)
+	}
+
+	return req
+}
+
+func TestVerifyIdenticalRequestsSameSecond(t *testing.T) {
+	var (
+		v    = NewVerifier(map[string]string{"smsmsgs": "secret1"}, 5*time.Minute, true)
+		body = []byte(`["production","5f0a1b2c3d4e5f6a7b8c9d0e","job1"]`)
+	)
+
+	// Identical halt checks signed within the same second (e.g. concurrent chunk jobs of a broadcast) are accepted
+	for i := 0; i < 3; i++ {
+		req := signedRequest(t, "smsmsgs", "secret1", body)
+		caller, err := v.Verify(req)
+		if err != nil || caller != "smsmsgs" {
+			t.Fatalf("request #%v: Verify = %q, %v; want \"smsmsgs\", <nil>", i, caller, err)
+		}
+
+		// The body is restored for the handler
+		var buf bytes.Buffer
+		buf.ReadFrom(req.Body)
+		if !bytes.Equal(buf.Bytes(), body) {
+			t.Errorf("request #%v: body after Verify = %q; want %q", i, buf.Bytes(), body)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	var (
+		v    = NewVerifier(map[string]string{"smsmsgs": "secret1"}, 5*time.Minute, true)
+		body = []byte(`["production"]`)
+	)
+
+	// replayed request (same nonce and signature)
+	req := signedRequest(t, "smsmsgs", "secret1", body)
+	rply := httptest.NewRequest(http.MethodPost, "/checkhalt", bytes.NewReader(body))
+	rply.Header = req.Header.Clone()
+	if _, err := v.Verify(req); err != nil {
+		t.Fatalf("original request: unexpected error: %v", err)
+	}
+
+	// tampered body
+	tmpr := signedRequest(t, "smsmsgs", "secret1", body)
+	tmpr.Body = httptest.NewRequest(http.MethodPost, "/checkhalt", bytes.NewReader([]byte(`["staging"]`))).Body
+
+	// missing nonce
+	nononce := signedRequest(t, "smsmsgs", "secret1", body)
+	nononce.Header.Del(HdrNonce)
+
+	// signed outside the replay window
+	stale := signedRequest(t, "smsmsgs", "secret1", body)
+	ts := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	stale.Header.Set(HdrTimestamp, ts)
+	stale.Header.Set(HdrSignature, Signature("secret1", ts, stale.Header.Get(HdrNonce), http.MethodPost, "/checkhalt", body))
+
+	var tests = []struct {
+		name string
+		v    *Verifier
+		req  *http.Request
+	}{
+		{"replayed", v, rply},
+		{"wrong secret", v, signedRequest(t, "smsmsgs", "secret2", body)},
+		{"unknown key id", v, signedRequest(t, "webapp", "secret1", body)},
+		{"unsigned", v, httptest.NewRequest(http.MethodPost, "/checkhalt", bytes.NewReader(body))},
+		{"tampered body", v, tmpr},
+		{"missing nonce", v, nononce},
+		{"stale", v, stale},
+		{"no keys configured", NewVerifier(nil, 5*time.Minute, true), signedRequest(t, "smsmsgs", "secret1", body)},
+	}
+	for _, tt := range tests {
+		if _, err := tt.v.Verify(tt.req); err == nil {
+			t.Errorf("%v: expected an error", tt.name)
+		}
+	}
+
+	// Authentication not required and no keys configured: any request is accepted
+	caller, err := NewVerifier(nil, 5*time.Minute, false).Verify(httptest.NewRequest(http.MethodPost, "/checkhalt", nil))
+	if err != nil || len(caller) != 0 {
+		t.Errorf("no keys, not required: Verify = %q, %v; want \"\", <nil>", caller, err)
+	}
+}
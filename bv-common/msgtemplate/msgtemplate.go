@@ -0,0 +1,120 @@
+// Package msgtemplate renders the text message of a promotion broadcast for a recipient (bv-job-worker-smsmsgs
+// renders the texts it sends, bv-job-queue validates and previews them). A message containing '{{' is a template
+// (Go text/template) referencing the recipient's data fields (e.g. {{.firstname}}), the text's {{.shortlink}} and
+// {{.shortcode}}, and the broadcast's {{.ownername}} and {{.promoexpiry}}, with fallbacks
+// ({{.firstname | default "friend"}}) and conditionals ({{if .firstname}}...{{end}}); any other message gets the
+// legacy "Hey <firstname>!" salutation. The '|||' placeholder is replaced by the shortlink either way.
+package msgtemplate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Placeholder is the placeholder in a message that is replaced by the text's unique shortlink
+const Placeholder = "|||"
+
+// cntTemplateFuncs are the functions a message template can call
+var cntTemplateFuncs = template.FuncMap{
+	"default": tmplDefault,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"title":   strings.Title,
+	"date":    tmplDate,
+}
+
+// cntTemplateDateLayouts are the layouts a date (e.g. the promotion expiry) is read with
+var cntTemplateDateLayouts = []string{time.RFC3339, "2006-01-02", "01/02/2006"}
+
+// tmplDefault returns a fallback for a missing (or blank) value: {{.firstname | default "friend"}}
+func tmplDefault(def, val string) string {
+	if len(strings.TrimSpace(val)) == 0 {
+		return def
+	}
+
+	return val
+}
+
+// tmplDate formats a date value with a layout: {{.promoexpiry | date "Jan 2"}} (an unreadable date is left as is)
+func tmplDate(layout, val string) string {
+	for _, l := range cntTemplateDateLayouts {
+		t, err := time.Parse(l, val)
+		if err == nil {
+			return t.Format(layout)
+		}
+	}
+
+	return val
+}
+
+// Template models a parsed text message
+type Template struct {
+	tmpl   *template.Template // parsed template (nil: legacy message)
+	msg    string             // message text
+	salute bool               // add the legacy salutation (legacy messages of a non generic promotion)
+}
+
+// Parse parses the text message of a broadcast; the legacy message of a generic promotion gets no salutation
+func Parse(msg string, generic bool) (*Template, error) {
+	var mt = Template{msg: msg}
+
+	if !strings.Contains(msg, "{{") {
+		// legacy message
+		mt.salute = !generic
+		return &mt, nil
+	}
+
+	tmpl, err := template.New("message").Funcs(cntTemplateFuncs).Option("missingkey=zero").Parse(msg)
+	if err != nil {
+		// invalid template
+		return nil, fmt.Errorf("invalid message template; see: %v", err)
+	}
+	mt.tmpl = tmpl
+
+	return &mt, nil
+}
+
+// Vars returns the values a message template can reference for a recipient: the recipient's data fields, the
+// text's shortlink and shortcode, and the broadcast's owner name and promotion expiry
+func Vars(rcp map[string]string, link, code, owner, expiry string) map[string]string {
+	var vars = make(map[string]string)
+
+	for k, v := range rcp {
+		vars[k] = v
+	}
+	vars["shortlink"] = link
+	vars["shortcode"] = code
+	vars["ownername"] = owner
+	vars["promoexpiry"] = expiry
+
+	return vars
+}
+
+// Render renders the text message for a recipient
+func (mt *Template) Render(vars map[string]string) (string, error) {
+	var (
+		msg = mt.msg
+		buf bytes.Buffer
+	)
+
+	switch {
+
+	// CASE: template
+	case mt.tmpl != nil:
+		err := mt.tmpl.Execute(&buf, vars)
+		if err != nil {
+			// error rendering the template
+			return "", fmt.Errorf("error rendering the message template; see: %v", err)
+		}
+		msg = buf.String()
+
+	// CASE: legacy message addressed to a named recipient
+	case mt.salute && len(vars["firstname"]) != 0:
+		msg = fmt.Sprintf("Hey %v! %v", vars["firstname"], msg)
+	}
+
+	return strings.Replace(msg, Placeholder, vars["shortlink"], -1), nil
+}
@@ -0,0 +1,65 @@
+package msgtemplate
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	var tests = []struct {
+		name    string
+		msg     string
+		generic bool
+		rcp     map[string]string
+		want    string
+	}{
+		{"legacy salutation", "20% off today: |||", false, map[string]string{"firstname": "Ann"}, "Hey Ann! 20% off today: http://x.co/AbC2345"},
+		{"legacy without a first name", "20% off today: |||", false, map[string]string{}, "20% off today: http://x.co/AbC2345"},
+		{"legacy generic promotion", "20% off today: |||", true, map[string]string{"firstname": "Ann"}, "20% off today: http://x.co/AbC2345"},
+		{"field", "Hi {{.firstname}}", false, map[string]string{"firstname": "Ann"}, "Hi Ann"},
+		{"missing field", "Hi {{.firstname}}!", false, map[string]string{}, "Hi !"},
+		{"fallback", `Hi {{.firstname | default "friend"}}`, false, map[string]string{}, "Hi friend"},
+		{"fallback for a blank value", `Hi {{.firstname | default "friend"}}`, false, map[string]string{"firstname": "  "}, "Hi friend"},
+		{"fallback not needed", `Hi {{.firstname | default "friend"}}`, false, map[string]string{"firstname": "Ann"}, "Hi Ann"},
+		{"conditional taken", "{{if .firstname}}Hi {{.firstname}}, {{end}}20% off", false, map[string]string{"firstname": "Ann"}, "Hi Ann, 20% off"},
+		{"conditional skipped", "{{if .firstname}}Hi {{.firstname}}, {{end}}20% off", false, map[string]string{}, "20% off"},
+		{"conditional else", "{{if .firstname}}Hi {{.firstname}}{{else}}Hi there{{end}}", false, map[string]string{}, "Hi there"},
+		{"shortlink and shortcode", "{{.shortlink}} (code {{.shortcode}})", false, map[string]string{}, "http://x.co/AbC2345 (code AbC2345)"},
+		{"placeholder in a template", "{{.firstname}}: |||", false, map[string]string{"firstname": "Ann"}, "Ann: http://x.co/AbC2345"},
+		{"owner and expiry", `{{.ownername}} - ends {{.promoexpiry | date "Jan 2"}}`, false, map[string]string{}, "Green Leaf - ends Oct 31"},
+		{"unreadable date left as is", `ends {{.firstname | date "Jan 2"}}`, false, map[string]string{"firstname": "soon"}, "ends soon"},
+		{"case functions", "{{.firstname | upper}} {{.lastname | lower}} {{.city | title}}", false,
+			map[string]string{"firstname": "ann", "lastname": "LEE", "city": "san diego"}, "ANN lee San Diego"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mt, err := Parse(tt.msg, tt.generic)
+			if err != nil {
+				t.Fatalf("unexpected error parsing %q: %v", tt.msg, err)
+			}
+			got, err := mt.Render(Vars(tt.rcp, "http://x.co/AbC2345", "AbC2345", "Green Leaf", "2026-10-31"))
+			if err != nil {
+				t.Fatalf("unexpected error rendering %q: %v", tt.msg, err)
+			}
+			if got != tt.want {
+				t.Errorf("Render(%q) = %q; want %q", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	// Unparseable templates
+	for _, msg := range []string{"Hi {{.firstname", "{{if .firstname}}Hi", "{{.firstname | nosuchfunc}}"} {
+		if _, err := Parse(msg, false); err == nil {
+			t.Errorf("Parse(%q): expected an error", msg)
+		}
+	}
+
+	// A template that parses but can't be rendered
+	mt, err := Parse(`{{date "Jan 2"}}`, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = mt.Render(map[string]string{}); err == nil {
+		t.Errorf("Render of a call with a missing argument: expected an error")
+	}
+}
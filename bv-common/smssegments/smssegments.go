@@ -0,0 +1,83 @@
+// Package smssegments works out how a text message is encoded (GSM-7 or UCS-2) and the number of segments it is
+// billed as (bv-job-worker-smsmsgs records it for each text, bv-job-queue estimates a broadcast with it); a single
+// character outside the GSM-7 alphabet (e.g. an emoji) flips the whole message to UCS-2, cutting a segment from
+// 160 to 70 characters
+package smssegments
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// Encodings of a text message
+const (
+	EncodingGSM7 = "GSM-7" // GSM 03.38 7 bit alphabet
+	EncodingUCS2 = "UCS-2" // 16 bit unicode (a message with any character outside the GSM-7 alphabet)
+)
+
+// cntGSM7Basic is the GSM-7 basic character set (one septet each)
+const cntGSM7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// cntGSM7Extended is the GSM-7 extension table (two septets each: an escape and the character)
+const cntGSM7Extended = "\f^{}\\[~]|€"
+
+// Segments models the encoding and segments of a text message
+type Segments struct {
+	Encoding string `json:"encoding"` // 'GSM-7' or 'UCS-2'
+	Units    int    `json:"units"`    // septets (GSM-7) or 16 bit code units (UCS-2) the message takes
+	Segments int    `json:"segments"` // segments the message is sent (and billed) as
+}
+
+// Analyze works out the encoding and segments of a text message; a multi segment message loses 7 septets (or
+// 3 code units) of each segment to its header, and an escaped character (or surrogate pair) isn't split
+func Analyze(msg string) Segments {
+	var (
+		seg   = Segments{Encoding: EncodingGSM7}
+		units []int
+	)
+
+	for _, r := range msg {
+		switch {
+		case strings.ContainsRune(cntGSM7Basic, r):
+			units = append(units, 1)
+		case strings.ContainsRune(cntGSM7Extended, r):
+			units = append(units, 2)
+		default:
+			seg.Encoding = EncodingUCS2
+		}
+	}
+
+	single, multi := 160, 153
+	if seg.Encoding == EncodingUCS2 {
+		single, multi = 70, 67
+		units = units[:0]
+		for _, r := range msg {
+			units = append(units, len(utf16.Encode([]rune{r})))
+		}
+	}
+
+	for _, u := range units {
+		seg.Units = seg.Units + u
+	}
+	if seg.Units == 0 {
+		return seg
+	}
+	if seg.Units <= single {
+		seg.Segments = 1
+		return seg
+	}
+
+	// Fill the segments of a multi segment message
+	fill := 0
+	seg.Segments = 1
+	for _, u := range units {
+		if fill+u > multi {
+			seg.Segments++
+			fill = 0
+		}
+		fill = fill + u
+	}
+
+	return seg
+}
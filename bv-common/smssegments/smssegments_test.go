@@ -0,0 +1,42 @@
+package smssegments
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	var tests = []struct {
+		name     string
+		msg      string
+		encoding string
+		units    int
+		segments int
+	}{
+		{"empty", "", EncodingGSM7, 0, 0},
+		{"gsm-7 single", strings.Repeat("a", 160), EncodingGSM7, 160, 1},
+		{"gsm-7 one over", strings.Repeat("a", 161), EncodingGSM7, 161, 2},
+		{"gsm-7 two full segments", strings.Repeat("a", 306), EncodingGSM7, 306, 2},
+		{"gsm-7 third segment", strings.Repeat("a", 307), EncodingGSM7, 307, 3},
+		{"gsm-7 basic accents", strings.Repeat("é", 160), EncodingGSM7, 160, 1},
+		{"escaped characters fill a segment", strings.Repeat("€", 80), EncodingGSM7, 160, 1},
+		{"escaped character over a segment", strings.Repeat("a", 159) + "€", EncodingGSM7, 161, 2},
+		{"escaped character not split", strings.Repeat("a", 152) + "€" + strings.Repeat("a", 152), EncodingGSM7, 306, 3},
+		{"ucs-2 single", strings.Repeat("ж", 70), EncodingUCS2, 70, 1},
+		{"ucs-2 one over", strings.Repeat("ж", 71), EncodingUCS2, 71, 2},
+		{"ucs-2 two full segments", strings.Repeat("ж", 134), EncodingUCS2, 134, 2},
+		{"ucs-2 third segment", strings.Repeat("ж", 135), EncodingUCS2, 135, 3},
+		{"emoji flips the message", strings.Repeat("a", 69) + "😀", EncodingUCS2, 71, 2},
+		{"curly quote flips the message", "Don’t miss out", EncodingUCS2, 14, 1},
+		{"surrogate pair not split", strings.Repeat("ж", 66) + "😀" + strings.Repeat("ж", 66), EncodingUCS2, 134, 3},
+		{"surrogate pair ends a segment", strings.Repeat("ж", 65) + "😀" + strings.Repeat("ж", 67), EncodingUCS2, 134, 2},
+	}
+
+	for _, tt := range tests {
+		seg := Analyze(tt.msg)
+		if seg.Encoding != tt.encoding || seg.Units != tt.units || seg.Segments != tt.segments {
+			t.Errorf("%v: Analyze = %v, %v units, %v segments; want %v, %v units, %v segments",
+				tt.name, seg.Encoding, seg.Units, seg.Segments, tt.encoding, tt.units, tt.segments)
+		}
+	}
+}
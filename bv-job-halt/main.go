@@ -13,11 +13,15 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gomodule/redigo/redis"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/whopdan/bv-common/hmacauth"
 )
 
 // Specification defines an object to house environment variable values
 type Specification struct {
-	RedisURL string `required:"false"`
+	RedisURL      string            `required:"false"`
+	AuthKeys      map[string]string // caller key ids and HMAC secrets signing requests (e.g. 'smsmsgs:secret1')
+	AuthWindowSec int               `default:"300"`   // seconds a signed request remains valid (replay window)
+	AuthRequired  bool              `default:"false"` // reject every request if no caller keys are configured
 }
 
 const cntDirectiveAll = "all" // 'all' jobs should stop
@@ -109,6 +113,30 @@ func getStatus() (string, error) {
 	return val, err
 }
 
+// mdlAuth returns gin middleware rejecting requests not signed with one of the caller keys (see
+// github.com/whopdan/bv-common/hmacauth); with no keys configured every request is rejected unless authentication
+// is not required
+func mdlAuth(keys map[string]string, window time.Duration, required bool) gin.HandlerFunc {
+	var vrfr = hmacauth.NewVerifier(keys, window, required)
+
+	return func(c *gin.Context) {
+		_, err := vrfr.Verify(c.Request)
+		if err != nil {
+			log.Printf("WARN: %v - rejected request: %v %v from: %v (key id: '%v') - %v\n",
+				utils.FileLine(),
+				c.Request.Method,
+				c.Request.URL.Path,
+				c.ClientIP(),
+				c.GetHeader(hmacauth.HdrKeyID),
+				err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "unauthorized", "msg": "request not authenticated"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // hndlGetHaltDirective determines if the referring job should be halted given a set of inbound directives
 //   return object...
 //     "halt": <boolean> indicate if the referring job sould be halted
@@ -208,7 +236,7 @@ func main() {
 	rtr := gin.Default()
 
 	// rtr.GET("/", hndlServiceUI)                    // Serve the web page
-	rtr.POST("/checkhalt", hndlGetHaltDirective) // Set a halt directive associated with an id
+	rtr.POST("/checkhalt", mdlAuth(cfg.AuthKeys, time.Duration(cfg.AuthWindowSec)*time.Second, cfg.AuthRequired), hndlGetHaltDirective) // Set a halt directive associated with an id
 	rtr.GET("/status", func(c *gin.Context) {
 		rslt, err := getStatus()
 		if err != nil {
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whopdan/bv-common/hmacauth"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	var (
+		keys = map[string]string{"webapp": "secret1", "ops": "secret2"}
+		body = []byte(`{"promosenddocid":"5f0a1b2c3d4e5f6a7b8c9d0e"}`)
+	)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/", mdlAuth(keys, 5*time.Minute, true))
+	api.POST("/cancelbroadcast", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(cntCtxCaller)) })
+	api.POST("/ownerquota", mdlRequireCaller([]string{"ops"}), func(c *gin.Context) { c.String(http.StatusOK, c.GetString(cntCtxCaller)) })
+
+	// signed returns a request signed by a caller (unsigned with no key id)
+	signed := func(path, keyID string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		err := hmacauth.Sign(req, body, keyID, keys[keyID])
+		if err != nil {
+			t.Fatalf("error signing a request: %v", err)
+		}
+		return req
+	}
+
+	var tests = []struct {
+		name   string
+		req    *http.Request
+		status int
+		caller string
+	}{
+		{"signed", signed("/cancelbroadcast", "webapp"), http.StatusOK, "webapp"},
+		{"unsigned", signed("/cancelbroadcast", ""), http.StatusUnauthorized, ""},
+		{"admin caller", signed("/ownerquota", "ops"), http.StatusOK, "ops"},
+		{"caller not allowed on an admin route", signed("/ownerquota", "webapp"), http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, tt.req)
+		if w.Code != tt.status {
+			t.Errorf("%v: got status %v; want %v", tt.name, w.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && w.Body.String() != tt.caller {
+			t.Errorf("%v: got caller %q; want %q", tt.name, w.Body.String(), tt.caller)
+		}
+	}
+}
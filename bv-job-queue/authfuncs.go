@@ -0,0 +1,66 @@
+// authfuncs.go contains the gin middleware authenticating requests signed by the callers of the service (see
+// github.com/whopdan/bv-common/hmacauth)
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/whopdan/bv-common/hmacauth"
+)
+
+const cntCtxCaller = "authcaller" // gin context key holding the authenticated caller's key id
+
+// mdlAuth returns gin middleware rejecting requests not signed with one of the caller keys (key id -> secret),
+// signed outside the replay window or replayed; with no keys configured every request is rejected unless
+// authentication is not required
+func mdlAuth(keys map[string]string, window time.Duration, required bool) gin.HandlerFunc {
+	var vrfr = hmacauth.NewVerifier(keys, window, required)
+
+	return func(c *gin.Context) {
+		caller, err := vrfr.Verify(c.Request)
+		if err != nil {
+			appLog("WARN: %v - rejected request: %v %v from: %v (key id: '%v') - %v\n",
+				utils.FileLine(),
+				c.Request.Method,
+				c.Request.URL.Path,
+				c.ClientIP(),
+				c.GetHeader(hmacauth.HdrKeyID),
+				err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "unauthorized", "msg": "request not authenticated"})
+			return
+		}
+
+		c.Set(cntCtxCaller, caller)
+		c.Next()
+	}
+}
+
+// mdlRequireCaller returns gin middleware (used after mdlAuth) rejecting requests not signed by one of the listed
+// caller key ids; with no key ids listed any authenticated caller is accepted
+func mdlRequireCaller(keyIDs []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(keyIDs) == 0 {
+			c.Next()
+			return
+		}
+
+		caller := c.GetString(cntCtxCaller)
+		for _, id := range keyIDs {
+			if caller == id {
+				c.Next()
+				return
+			}
+		}
+
+		appLog("WARN: %v - rejected request: %v %v from: %v (key id: '%v') - caller not allowed on this route\n",
+			utils.FileLine(),
+			c.Request.Method,
+			c.Request.URL.Path,
+			c.ClientIP(),
+			caller)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "forbidden", "msg": "caller not allowed on this route"})
+	}
+}
@@ -0,0 +1,227 @@
+// cancelfuncs.go contains code to cancel a promotion broadcast's chunk jobs that have not started yet
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	bson "github.com/globalsign/mgo/bson"
+
+	fak "github.com/contribsys/faktory/client"
+
+	cmn "github.com/whopdan/wrkrcom"
+)
+
+// cancelResponse models the response returned to a request to cancel a promotion broadcast
+type cancelResponse struct {
+	Msg          string        `json:"msg"`            // message describing the outcome
+	PromoSendID  string        `json:"promosenddocid"` // document id of the broadcast being cancelled
+	NumKilled    int           `json:"numkilled"`      // number of scheduled jobs removed from Faktory
+	NumCancelled int           `json:"numcancelled"`   // number of job directives flagged as cancelled
+	Chunks       []cancelChunk `json:"chunks"`         // per chunk outcome
+}
+
+// cancelChunk models the outcome of cancelling a single chunk job
+type cancelChunk struct {
+	ChunkNumber   int    `json:"chunknumber"`     // sequence number of the chunk within the broadcast
+	JobID         string `json:"jobid"`           // Faktory job id
+	IDString      string `json:"idstring"`        // workerjobdata document id
+	ScheduledTime string `json:"scheduledtime"`   // time the job was scheduled to run
+	Killed        bool   `json:"killed"`          // job removed from Faktory's scheduled set
+	Error         string `json:"error,omitempty"` // error cancelling the chunk (if any)
+}
+
+// killScheduledJob moves a scheduled bv-job-worker-smsmsgs job (due at: at) to Faktory's Dead set so it never runs;
+// returns true if the job was removed from the scheduled set.  Faktory doesn't report the jobs a kill matched (nor
+// look a job up by its id) so a job due within cntKillMarginSec of the kill isn't counted as removed - Faktory may
+// have moved it to its queue already (the job halts when it sees its cancelled directive)
+func killScheduledJob(jid string, at time.Time) (bool, error) {
+	var margin = cntKillMarginSec * time.Second
+
+	// Job due (or about to be)?  It may have left the scheduled set
+	if time.Until(at) < margin {
+		return false, nil
+	}
+
+	fakMutex.Lock()
+	defer fakMutex.Unlock()
+
+	// Filter on a single job id - matched within Redis (fast)
+	err := cmnWrkr.FakClient.Kill(fak.Scheduled, fak.OfType(cmn.CntWorkerNameSendSMSMessages).WithJids(jid))
+	if err != nil {
+		return false, err
+	}
+
+	// Job still scheduled when the kill finished? (a slow kill may have raced the job's time)
+	return time.Until(at) >= margin, nil
+}
+
+// cancelDirective flags a workerjobdata job directive as cancelled so its job halts if it does start
+func cancelDirective(id bson.ObjectId, reason string) error {
+	return mgoCollWorkerJobData.UpdateId(id, bson.M{"$set": bson.M{
+		"cancelled":       true,
+		"cancelledtime":   time.Now().UTC(),
+		"cancelledreason": reason,
+	}})
+}
+
+// hdlCancelBroadcast handles an inbound request to cancel the chunk jobs of a promotion broadcast
+// that have not started yet
+func hdlCancelBroadcast(c *gin.Context) {
+	var (
+		err      error
+		rbytes   []byte
+		reason   string
+		tMap     = make(map[string]string)
+		drts     []ProcessDirective
+		logLines []string
+		rsp      cancelResponse
+	)
+
+	// Read the request body
+	rbytes, err = c.GetRawData()
+	if err != nil {
+		// error reading the request data
+		appLog("ERROR: %v - error reading the request data. See: %v\n",
+			utils.FileLine(),
+			err)
+		rsp.Msg = fmt.Sprintf("error reading the request data; see: %v", err)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	// Parse the inbound request data
+	err = utils.FromJSONBytes(rbytes, &tMap)
+	if err != nil {
+		// error parsing the request data
+		appLog("ERROR: %v - error parsing the request data. See: %v\n",
+			utils.FileLine(),
+			err)
+		rsp.Msg = fmt.Sprintf("error parsing the request data; see: %v", err)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	// Validate the request data
+	rsp.PromoSendID = tMap["promosenddocid"]
+	if !bson.IsObjectIdHex(rsp.PromoSendID) {
+		// missing or invalid promotion send document id
+		appLog("ERROR: %v - missing or invalid promosenddocid parameter: %v\n", utils.FileLine(), rsp.PromoSendID)
+		rsp.Msg = "missing or invalid promosenddocid parameter"
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	reason = tMap["reason"]
+	if len(reason) == 0 {
+		reason = "broadcast cancelled by request"
+	}
+
+	appLog("INFO: %v - inbound request to cancel psend: %v in environment: %v (reason: %v)\n",
+		utils.FileLine(),
+		rsp.PromoSendID,
+		cfg.WorkerEnvironment,
+		reason)
+
+	// Fetch the job directives of the broadcast that haven't been cancelled
+	err = mgoCollWorkerJobData.Find(bson.M{"promosendid": rsp.PromoSendID, "cancelled": bson.M{"$ne": true}}).
+		Sort("chunknumber").
+		All(&drts)
+	if err != nil {
+		// error fetching the broadcast's job directives
+		appLog("ERROR: %v - error fetching the job directives of psend: %v. See: %v\n",
+			utils.FileLine(),
+			rsp.PromoSendID,
+			err)
+		rsp.Msg = fmt.Sprintf("error fetching the broadcast's job directives; see: %v", err)
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+
+	// Iterate through the job directives
+	for _, d := range drts {
+		var (
+			chk = cancelChunk{ChunkNumber: d.ChunkNumber, JobID: d.JobID, IDString: d.IDString, ScheduledTime: d.ScheduledTime}
+			at  time.Time
+		)
+
+		// Remove pushed jobs still waiting in Faktory's scheduled set
+		at, err = time.Parse(time.RFC3339Nano, d.ScheduledTime)
+		if err == nil && d.PushStatus == cntPushStatusPushed && at.After(time.Now()) {
+			chk.Killed, err = killScheduledJob(d.JobID, at)
+			if err != nil {
+				// error killing a scheduled job
+				appLog("ERROR: %v - error killing scheduled job: %v of psend: %v. See: %v\n",
+					utils.FileLine(),
+					d.JobID,
+					rsp.PromoSendID,
+					err)
+				chk.Error = fmt.Sprintf("error killing the scheduled job; see: %v", err)
+			}
+			if chk.Killed {
+				rsp.NumKilled = rsp.NumKilled + 1
+			}
+		}
+
+		// Flag the directive as cancelled (a queued or running job halts when it sees the flag)
+		err = cancelDirective(d.ID, reason)
+		if err != nil {
+			// error flagging a job directive as cancelled
+			appLog("ERROR: %v - error flagging job directive: %v as cancelled. See: %v\n",
+				utils.FileLine(),
+				d.IDString,
+				err)
+			chk.Error = fmt.Sprintf("error flagging the job directive as cancelled; see: %v", err)
+		} else {
+			rsp.NumCancelled = rsp.NumCancelled + 1
+		}
+
+		// Job will never run (removed from the schedule or never pushed)? Record its outcome for the completion tracker
+		// (any other job records its own outcome when it starts and sees the cancelled directive)
+		if err == nil && (chk.Killed || d.PushStatus == cntPushStatusPending) {
+			err = finishUnrunDirective(d.ID)
+			if err != nil {
+				// error recording the outcome of a cancelled job directive
+				appLog("ERROR: %v - error recording the outcome of cancelled job directive: %v. See: %v\n",
+					utils.FileLine(),
+					d.IDString,
+					err)
+			}
+		}
+
+		logLines = jobLog(logLines, "CANCEL: chunk #%v job: %v scheduled at: %v cancelled (removed from schedule?: %v)",
+			chk.ChunkNumber,
+			chk.JobID,
+			chk.ScheduledTime,
+			chk.Killed)
+
+		rsp.Chunks = append(rsp.Chunks, chk)
+	}
+
+	// Flag the promotion broadcast as halted
+	err = setPSendHalted(rsp.PromoSendID, fmt.Sprintf("broadcast cancelled: %v", reason))
+	if err != nil {
+		// error flagging the promotion broadcast as halted
+		appLog("ERROR: %v - error flagging psend: %v as halted. See: %v\n",
+			utils.FileLine(),
+			rsp.PromoSendID,
+			err)
+		rsp.Msg = fmt.Sprintf("error flagging the broadcast as halted; see: %v", err)
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+
+	// Write the "log lines" to the database
+	logLines = jobLog(logLines, "CANCEL: broadcast cancelled - %v", reason)
+	wrtJobLog(cfg.WorkerEnvironment, logLines, rsp.PromoSendID, "")
+
+	rsp.Msg = fmt.Sprintf("cancelled %v job(s) of broadcast %v; %v removed from the schedule",
+		rsp.NumCancelled,
+		rsp.PromoSendID,
+		rsp.NumKilled)
+	appLog("INFO: %v - %v\n", utils.FileLine(), rsp.Msg)
+	c.JSON(http.StatusOK, rsp)
+}
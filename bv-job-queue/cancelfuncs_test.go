@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKillScheduledJobDueSoon(t *testing.T) {
+	// Jobs due (or about to be) may have left Faktory's scheduled set - they're never counted as killed
+	for _, at := range []time.Time{time.Now().Add(-time.Minute), time.Now(), time.Now().Add(cntKillMarginSec * time.Second / 2)} {
+		killed, err := killScheduledJob("0123456789abcdef", at)
+		if killed || err != nil {
+			t.Errorf("killScheduledJob(due: %v) = %v, %v; want false, <nil>", at, killed, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+const (
+	// Enqueue states recorded on a jobqueueenqueues document
+	cntEnqueueStatusEnqueuing = "enqueuing" // jobs are being pushed for the enqueue request
+	cntEnqueueStatusEnqueued  = "enqueued"  // jobs have been pushed for the enqueue request
+
+	// Outcomes of an enqueue request returned to the caller
+	cntEnqueueResultEnqueued = "enqueued" // all jobs pushed
+	cntEnqueueResultPartial  = "partial"  // some jobs failed to push
+	cntEnqueueResultFailed   = "failed"   // no jobs pushed
+	cntEnqueueResultExisting = "existing" // broadcast already queued - existing jobs returned
+	cntEnqueueResultRejected = "rejected" // invalid request - nothing queued
+	cntEnqueueResultPreview  = "preview"  // dry run - nothing queued
+
+	// Reasons an enqueue data row is removed from a broadcast
+	cntSkipReasonMissingData    = "missing the end customer document id or phone number"
+	cntSkipReasonInvalidPhone   = "invalid phone number"
+	cntSkipReasonDuplicatePhone = "duplicate phone number"
+	cntSkipReasonTagDuplicate   = "recipient of another promotion send in the tag group"
+	cntSkipReasonTagMerged      = "merged into a combined message"
+
+	// Rules for recipients of several promotion sends in a tag group
+	cntDupRuleAll   = "all"   // send every promotion to the recipient
+	cntDupRuleFirst = "first" // send only the first (earliest created) promotion
+	cntDupRuleMerge = "merge" // send one message combining the promotions
+
+	// Outbox states of a job directive's job
+	cntPushStatusPending  = "pending"  // job not pushed yet (or failed to push) - pushed by the outbox dispatcher
+	cntPushStatusPushing  = "pushing"  // job is being pushed
+	cntPushStatusPushed   = "pushed"   // job pushed to Faktory
+	cntPushStatusOrphaned = "orphaned" // job never pushed (or its push outcome is unknown) - needs an operator
+
+	// Outcomes of a chunk job recorded on its job directive
+	cntChunkStatusDone      = "done"      // every recipient processed
+	cntChunkStatusHalted    = "halted"    // job halted (or cancelled) before processing every recipient
+	cntChunkStatusFailed    = "failed"    // job ended due to an error
+	cntChunkStatusCancelled = "cancelled" // job cancelled before it ran (it never runs)
+
+	cntPromoSendNotSent = "not sent" // status of a promotion broadcast with chunk jobs left to run
+	cntPromoSendSent    = "sent"     // status of a promotion broadcast whose chunk jobs have all finished
+
+	cntKillMarginSec = 30 // a scheduled job due within this many seconds may have left Faktory's scheduled set already
+
+	cntOutboxBatchSize = 100 // maximum number of pending job directives pushed per outbox dispatcher run
+
+	cntQRGenAdHocBatch = "adhocbatch" // first argument of a bv-job-worker-qrcode-gen job encoding a batch of shortcodes
+
+	// Policies for a broadcast exceeding its owner's sending quota
+	cntQuotaPolicyReject  = "reject"  // reject the broadcast
+	cntQuotaPolicyPartial = "partial" // queue the recipients that fit
+
+	cntQuotaReserveMaxTries = 5 // attempts to reserve recipients against an owner's allowances contended by concurrent enqueues
+
+	// Policies for a broadcast with messages over the segment limit (see WorkerMaxSegments)
+	cntSegmentPolicyWarn   = "warn"   // enqueue the broadcast with a warning
+	cntSegmentPolicyReject = "reject" // reject the broadcast
+
+	// Outcomes of a request to view or adjust a sending quota
+	cntQuotaResultOK       = "ok"       // quota returned (or adjusted)
+	cntQuotaResultRejected = "rejected" // invalid request
+	cntQuotaResultFailed   = "failed"   // error reading or saving the quota
+
+	// States of a chunk's job reported by the progress route
+	cntJobStatePending   = "pending"   // job not pushed to Faktory yet
+	cntJobStateOrphaned  = "orphaned"  // job never pushed (or its push outcome is unknown)
+	cntJobStateScheduled = "scheduled" // job waiting in Faktory's scheduled set
+	cntJobStateQueued    = "queued"    // job waiting on its queue for a worker
+	cntJobStateBusy      = "busy"      // job running
+	cntJobStateDead      = "dead"      // job ended with an error (halted, failed, or cancelled) - in Faktory's Dead set
+	cntJobStateDone      = "done"      // job finished
+
+	// Outcomes of a request to resume a broadcast
+	cntResumeResultOK       = "ok"       // chunk jobs resumed
+	cntResumeResultRejected = "rejected" // invalid request (or nothing to resume)
+	cntResumeResultFailed   = "failed"   // error resuming the chunk jobs
+
+	// Outcomes of a request for the progress of a broadcast
+	cntProgressResultOK       = "ok"       // progress returned
+	cntProgressResultRejected = "rejected" // invalid request
+	cntProgressResultFailed   = "failed"   // error reading the progress
+
+	cntProgressLogPageSize    = 200  // default number of job log lines per page returned by the progress route
+	cntProgressLogMaxPageSize = 1000 // maximum number of job log lines per page returned by the progress route
+
+	cntParamTrue = "true" // string value of a 'true' request parameter (e.g. "force": "true")
+)
@@ -24,6 +24,8 @@ func schedJobs() {
 			utils.FileLine(),
 			appName)
 	})
+	gocron.Every(5).Minutes().Do(reconcileOutbox) // Re-push pending job directives and flag orphaned ones
+	gocron.Every(1).Minute().Do(trackCompletions) // Flag promotion broadcasts whose chunk jobs have all finished as sent
 
 	// Start the default gocron scheduler
 	appLog("INFO: %v - start this web service's 'cron' scheduler\n", utils.FileLine())
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var rgxNonDigit = regexp.MustCompile(`\D`)
+
+// Layouts accepted for a broadcast send-at time without a UTC offset (interpreted in the send-at timezone)
+var cntSendAtLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// hlprParseSendAt parses a broadcast send-at time; the time either carries a UTC offset (RFC3339)
+// or is a local time interpreted in the named timezone (e.g. 'America/New_York')
+func hlprParseSendAt(at, tz string) (time.Time, error) {
+	var (
+		err error
+		tm  time.Time
+		loc *time.Location
+	)
+
+	// Load the send-at timezone (if specified)
+	if len(tz) != 0 {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			// invalid timezone name
+			return tm, fmt.Errorf("invalid send-at timezone: %v; see: %v", tz, err)
+		}
+	}
+
+	// Does the time carry its own UTC offset?
+	tm, err = time.Parse(time.RFC3339, at)
+	if err == nil {
+		if loc != nil {
+			tm = tm.In(loc)
+		}
+		return tm, nil
+	}
+
+	// Local time - a timezone is required to interpret it
+	if loc == nil {
+		return tm, fmt.Errorf("send-at time: %v has no UTC offset and no timezone was specified", at)
+	}
+
+	for _, lyt := range cntSendAtLayouts {
+		tm, err = time.ParseInLocation(lyt, at, loc)
+		if err == nil {
+			return tm, nil
+		}
+	}
+
+	return tm, fmt.Errorf("unrecognized send-at time format: %v", at)
+}
+
+// hlprDispDateTime translates a time object to a date/time display string in its own timezone
+func hlprDispDateTime(t time.Time) string {
+	return t.Format("01-02-06 15:04 MST")
+}
+
+// hlprNormalizePhone normalizes a US phone number to its 10 digits (dropping formatting and a leading
+// country code of 1); returns false if the number is not a valid North American number
+func hlprNormalizePhone(s string) (string, bool) {
+	dgts := rgxNonDigit.ReplaceAllString(s, "")
+
+	// Drop a leading country code
+	if len(dgts) == 11 && dgts[0] == '1' {
+		dgts = dgts[1:]
+	}
+
+	// Ten digits with an area code and exchange that don't start with 0 or 1?
+	if len(dgts) != 10 || dgts[0] < '2' || dgts[3] < '2' {
+		return "", false
+	}
+
+	return dgts, true
+}
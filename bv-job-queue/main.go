@@ -24,17 +24,44 @@ import (
 
 // Specification defines an object to house environment variable values
 type Specification struct {
-	WorkerEnvironment      string `required:"true"` // environment this worker is associated with (e.g. 'production')
-	WorkerEnqueuePort      int    `default:"8080"`  // not referenced if running on Heroku
-	WorkerDBURL            string `required:"true"`
-	WorkerDBName           string `required:"true"`
-	WorkerDBUser           string `required:"true"`
-	WorkerDBPassword       string `required:"true"` // staging environment database configuration
-	WorkerMsgThreshold     int    `default:"500"`
-	WorkerJobTimeoutSec    int    `default:"7200"`
-	WorkerJobDelaySec      int    `default:"1800"`
-	WorkerPapertrailDomain string `default:"logs.papertrailapp.com:27834"`
-	WorkerPapertrailApp    string `default:"bvenqueue"`
+	WorkerEnvironment      string  `required:"true"` // environment this worker is associated with (e.g. 'production')
+	WorkerEnqueuePort      int     `default:"8080"`  // not referenced if running on Heroku
+	WorkerDBURL            string  `required:"true"`
+	WorkerDBName           string  `required:"true"`
+	WorkerDBUser           string  `required:"true"`
+	WorkerDBPassword       string  `required:"true"` // staging environment database configuration
+	WorkerMsgThreshold     int     `default:"500"`
+	WorkerJobTimeoutSec    int     `default:"7200"`
+	WorkerJobDelaySec      int     `default:"1800"`
+	WorkerTargetMsgsPerSec float64 `default:"0"` // default target send rate for a broadcast (0: use WorkerMsgThreshold/WorkerJobDelaySec)
+	WorkerJobMsgsPerSec    float64 `default:"1"` // send rate a single bv-job-worker-smsmsgs job sustains
+	WorkerSMSConcurrency   int     `default:"5"` // number of jobs a bv-job-worker-smsmsgs instance runs at the same time
+	WorkerPapertrailDomain string  `default:"logs.papertrailapp.com:27834"`
+	WorkerPapertrailApp    string  `default:"bvenqueue"`
+	WorkerShortLinkPreview string  `default:"http://bdvi.be/x/AbC1234"` // sample shortlink used to render a previewed message
+	WorkerOutboxPollSec    int     `default:"5"`                        // seconds between outbox dispatcher runs
+	WorkerOutboxStaleSec   int     `default:"300"`                      // seconds a directive may stay claimed for pushing before it is orphaned
+	WorkerOutboxMaxTries   int     `default:"5"`                        // attempts to push a directive's job before it is orphaned
+	WorkerQRBatchSize      int     `default:"50"`                       // maximum number of shortcodes encoded by a single ad hoc QR code job
+
+	// Request authentication (see authfuncs.go)
+	WorkerAuthKeys      map[string]string // caller key ids and HMAC secrets signing requests (e.g. 'webapp:secret1,ops:secret2')
+	WorkerAuthWindowSec int               `default:"300"`  // seconds a signed request remains valid (replay window)
+	WorkerAuthRequired  bool              `default:"true"` // reject every request if no caller keys are configured
+	WorkerAuthAdminKeys []string          // caller key ids allowed to use the admin routes (empty: any authenticated caller)
+
+	// Sending quotas (see modelOwnerQuota.go)
+	WorkerQuotaPolicy       string            `default:"reject"` // policy for a broadcast exceeding its owner's quota: 'reject' or 'partial'
+	WorkerQuotaDefaultPlan  string            `default:"basic"`  // plan of an owner without a plan in the ownerquotas collection
+	WorkerPlanDailyLimits   map[string]int    // messages allowed per day by plan (e.g. 'basic:1000,pro:10000'; missing: unlimited)
+	WorkerPlanMonthlyLimits map[string]int    // messages allowed per month by plan (e.g. 'basic:10000,pro:100000'; missing: unlimited)
+	WorkerPlanQuotaPolicies map[string]string // quota policy by plan (e.g. 'basic:reject,pro:partial'; missing: WorkerQuotaPolicy)
+
+	// Message segments and cost estimates (see smssegments.go)
+	WorkerMaxSegments    int     `default:"0"`      // segments a message may take (0: no limit)
+	WorkerSegmentPolicy  string  `default:"warn"`   // policy for a broadcast with messages over the limit: 'warn' or 'reject'
+	WorkerCostPerSegment float64 `default:"0.0079"` // estimated cost (USD) of an SMS segment
+	WorkerCostPerMMS     float64 `default:"0.02"`   // estimated cost (USD) of an MMS message (sent with media)
 }
 
 // ProcessDirective houses the job instructions read and parsed from a gridfile
@@ -49,6 +76,31 @@ type ProcessDirective struct {
 	Data                   []map[string]string `json:"data"`                                                 // data being processed
 	EnqueueTime            string              `json:"enqueuetime"`                                          // Time the directive was associated with a job and queued (not necessarily run)
 	IsXPathwayGenericPromo bool                `bson:"isxpathwaygenericpromo" json:"isxpathwaygenericpromo"` // indicates if the underlying promotion is an xPathway generic promotion
+	EnqueueDocID           string              `bson:"enqueuedocid" json:"enqueuedocid"`                     // jobqueueenqueues document id (idstring) that produced this directive
+	ChunkNumber            int                 `bson:"chunknumber" json:"chunknumber"`                       // sequence number of this directive (chunk) within the broadcast
+	ScheduledTime          string              `bson:"scheduledtime" json:"scheduledtime"`                   // Time the directive's job is scheduled to run
+	SendAt                 string              `bson:"sendat" json:"sendat"`                                 // Time the broadcast should start sending (RFC3339 or local time in SendAtTZ)
+	SendAtTZ               string              `bson:"sendattz" json:"sendattz"`                             // Timezone of the send-at time (e.g. 'America/New_York')
+	MsgsPerSec             float64             `bson:"msgspersec" json:"msgspersec"`                         // Target send rate for the account or sender number (0: service default)
+	MsgIntervalMs          int                 `bson:"msgintervalms" json:"msgintervalms"`                   // Time in milliseconds between messages sent by the directive's job (0: worker default)
+	Cancelled              bool                `bson:"cancelled" json:"cancelled"`                           // Indicates the directive's job was cancelled before it finished
+	MergedPromoSendIDs     []string            `bson:"mergedpromosendids" json:"mergedpromosendids"`         // Broadcasts (of a tag group) whose messages are merged into this directive's message
+	PushStatus             string              `bson:"pushstatus" json:"pushstatus"`                         // Outbox state of the directive's job: 'pending', 'pushing', 'pushed', 'orphaned'
+	PushAt                 time.Time           `bson:"pushat" json:"pushat"`                                 // Time the directive's job is scheduled to run (job.At)
+	PushClaimTime          time.Time           `bson:"pushclaimtime" json:"pushclaimtime"`                   // Time the directive was last claimed for pushing
+	PushAttempts           int                 `bson:"pushattempts" json:"pushattempts"`                     // Number of attempts to push the directive's job
+	PushedTime             time.Time           `bson:"pushedtime" json:"pushedtime"`                         // Time the directive's job was pushed
+	PushError              string              `bson:"pusherror" json:"pusherror"`                           // Last error pushing the directive's job (or the reason it was orphaned)
+	ChunkResult            chunkResult         `bson:"chunkresult" json:"chunkresult"`                       // Outcome of the directive's job (recorded by the worker when the job ends)
+	ChunkQRDone            bool                `bson:"chunkqrdone" json:"chunkqrdone"`                       // Indicates the QR code job spawned by the directive's job finished
+	ChunkStartTime         time.Time           `bson:"chunkstarttime" json:"chunkstarttime"`                 // Time the directive's job started (recorded by the worker)
+	ChunkSnapDone          bool                `bson:"chunksnapdone" json:"chunksnapdone"`                   // Indicates the snapshot job spawned by the directive's job finished
+	ChunkProgress          chunkProgress       `bson:"chunkprogress" json:"chunkprogress"`                   // Per recipient progress checkpointed by the directive's job (recorded by the worker)
+	PriorJobIDs            []string            `bson:"priorjobids" json:"priorjobids"`                       // Job ids of earlier (halted or crashed) runs of a resumed directive
+	NumReschedules         int                 `bson:"numreschedules" json:"numreschedules"`                 // Times the worker rescheduled the directive's job (run outside the call hours)
+	DeferredFrom           string              `bson:"deferredfrom" json:"deferredfrom"`                     // Job directive (idstring) whose recipients outside their call hours this directive texts (empty: a planned chunk)
+	OwnerName              string              `bson:"ownername" json:"ownername"`                           // Name of the broadcast's owner (e.g. dispensary) - {{.ownername}} in a message template
+	PromoExpiry            string              `bson:"promoexpiry" json:"promoexpiry"`                       // Expiry date of the broadcast's promotion - {{.promoexpiry}} in a message template
 }
 
 // WorkerJobLogLine models a line to be logged capturing detail of a job run
@@ -75,6 +127,11 @@ var (
 	mgoCollJobQueueEnqueues *mgo.Collection
 	mgoCollWorkerJobData    *mgo.Collection
 	mgoCollWorkerJobLog     *mgo.Collection
+	mgoCollPromoBroadcasts  *mgo.Collection
+	mgoCollStopPhoneList    *mgo.Collection
+	mgoCollSMSMessages      *mgo.Collection
+	mgoCollOwnerQuotas      *mgo.Collection
+	mgoCollOwnerUsage       *mgo.Collection
 
 	// Access common worker functions
 	cmnWrkr cmn.Domain
@@ -143,37 +200,31 @@ func wrtJobLog(env string, slc []string, pid string, jbid string) {
 	return
 }
 
-// postJob enqueues a job to fire out a Promo Send's SMS messages
-func postJob(drt ProcessDirective, delayJb bool, delaySecs int) (string, error) {
+// postJob enqueues a job to fire out a Promo Send's SMS messages (at a scheduled time if in the future); the job
+// directive is stored first so a job that fails to push is left pending for the outbox dispatcher; returns the job id,
+// the directive's push state and the error pushing its job (if left pending)
+func postJob(drt ProcessDirective, at time.Time) (string, string, string, error) {
 	var (
 		err error
-		dur time.Duration
 	)
 
-	dur = time.Duration(delaySecs) * time.Second
-
-	appLog("DEBUG: %v - attempting to submit job for psend: %v using delay?: %v\n",
+	appLog("DEBUG: %v - attempting to submit job for psend: %v scheduled at: %v\n",
 		utils.FileLine(),
 		drt.PromoSendID,
-		delayJb)
+		at)
 
 	// Light validation of the inbound directive - do we have data?
 	if len(drt.Data) == 0 || !bson.IsObjectIdHex(drt.IDString) {
 		// missing job data or invalid document id
-		return "", fmt.Errorf("missing job data or invalid document id - nothing to do")
+		return "", "", "", fmt.Errorf("missing job data or invalid document id - nothing to do")
 	}
 
-	job := fak.NewJob(cmn.CntWorkerNameSendSMSMessages, drt.PromoSendID, drt.IDString, drt.Environment) // Create a new job
-	if delayJb {
-		// Delay the execution of the job on the queue
-		job.At = time.Now().UTC().Add(dur).Format(time.RFC3339Nano)
-	}
-	job.ReserveFor = cfg.WorkerJobTimeoutSec     // Assign a timeout
-	job.Queue = cmn.CntWorkerNameSendSMSMessages // Post to the "bv-job-worker-smsmsgs" queue
-	job.Retry = -1                               // Failed jobs should are not retried and moved to the 'Dead' Faktory tab
-
-	// Assign the job id to the job directive
-	drt.JobID = job.Jid
+	// Assign the job id and push state to the job directive (claimed for pushing by this request)
+	drt.JobID = newSMSJob(drt).Jid
+	drt.PushAt = at.UTC()
+	drt.PushStatus = cntPushStatusPushing
+	drt.PushClaimTime = time.Now().UTC()
+	drt.PushAttempts = 1
 
 	// Store the job directive to the database
 	err = mgoCollWorkerJobData.Insert(drt)
@@ -182,30 +233,27 @@ func postJob(drt ProcessDirective, delayJb bool, delaySecs int) (string, error)
 		appLog("ERROR: %v - error inserting job directive data to the database. See: %v\n",
 			utils.FileLine(),
 			err)
-		return "", fmt.Errorf("error inserting job directive data to the database; see: %v", err)
+		return "", "", "", fmt.Errorf("error inserting job directive data to the database; see: %v", err)
 	}
 
-	err = cmnWrkr.FakClient.Push(job)
+	err = pushDirective(drt)
 	if err != nil {
-		// error pushing a faktory job
-		appLog("ERROR: %v - error pushing a faktory job: %v (%v). See: %v\n",
+		// error pushing a faktory job - the outbox dispatcher pushes the job later
+		appLog("ERROR: %v - job: %v (%v) left pending for the outbox dispatcher. See: %v\n",
 			utils.FileLine(),
 			cmn.CntWorkerNameSendSMSMessages,
-			job.Jid,
-			err)
-		return "", fmt.Errorf("error pushing a faktory job: %v (%v); see: %v",
-			job.Jid,
-			cmn.CntWorkerNameSendSMSMessages,
+			drt.JobID,
 			err)
+		return drt.JobID, cntPushStatusPending, err.Error(), nil
 	}
 
 	appLog("INFO: %v - submitted job: %v (%v) for promotion send id: %v\n",
 		utils.FileLine(),
 		cmn.CntWorkerNameSendSMSMessages,
-		job.Jid,
+		drt.JobID,
 		drt.PromoSendID)
 
-	return job.Jid, nil
+	return drt.JobID, cntPushStatusPushed, "", nil
 }
 
 // postAdHocQRGenJob enqueues a job to generate and save a QR code image
@@ -237,7 +285,7 @@ func postAdHocQRGenJob(shrtcde string, delayJb bool, delaySecs int) (string, err
 	job.Queue = cmn.CntWorkerNameQRCodeGen   // Post to the "bv-job-worker-qrcode-gen" queue
 	job.Retry = -1                           // Failed jobs should are not retried and moved to the 'Dead' Faktory tab
 
-	err = cmnWrkr.FakClient.Push(job)
+	err = fakPush(job)
 	if err != nil {
 		// error pushing a faktory job
 		appLog("ERROR: %v - error pushing a faktory job: %v (%v). See: %v\n",
@@ -260,192 +308,324 @@ func postAdHocQRGenJob(shrtcde string, delayJb bool, delaySecs int) (string, err
 	return job.Jid, nil
 }
 
-// hdlQueueSMSJob handles inbound requests to enqueue SMS message jobs to a Faktory worker
-func hdlQueueSMSJob(c *gin.Context) {
+// pushChunkPlan posts (enqueues) a job for each chunk of a plan, recording each chunk's outcome on
+// the enqueue response; returns the ids of the jobs queued (pushed or left pending for the outbox dispatcher)
+func pushChunkPlan(docid string, drtv ProcessDirective, plan []chunkPlan, sched sendSchedule, rsp *enqueueResponse, logLines []string) ([]string, []string) {
 	var (
-		err             error
-		fname           string
-		rbytes          []byte
-		tMap            = make(map[string]string)
-		logLines        []string
-		drtive, tmpDir  ProcessDirective
-		ctr, runngDelay int
-		jobCtr, numCtr  = 1, 1
-		delayJob        bool
+		err    error
+		jbID   string
+		pshSts string
+		pshErr string
+		jbIDs  []string
+		tmpDir ProcessDirective
 	)
 
-	// Read the request body
-	rbytes, err = c.GetRawData()
-	if err != nil {
-		// error reading the request data
-		appLog("ERROR: %v - error reading the request data. See: %v\n",
-			utils.FileLine(),
-			err)
-		c.JSON(http.StatusBadRequest, fmt.Sprintf("error reading the request data; see: %v", err))
-		return
+	tmpDir.EnqueueDocID = docid
+	tmpDir.PromoSendID = drtv.PromoSendID
+	tmpDir.Message = drtv.Message
+	tmpDir.MediaURL = drtv.MediaURL
+	tmpDir.IsXPathwayGenericPromo = drtv.IsXPathwayGenericPromo
+	tmpDir.MsgIntervalMs = int(sched.MsgInterval / time.Millisecond)
+	tmpDir.MergedPromoSendIDs = drtv.MergedPromoSendIDs
+	tmpDir.OwnerName = drtv.OwnerName
+	tmpDir.PromoExpiry = drtv.PromoExpiry
+	for _, chk := range plan {
+		var rchk enqueueChunk
+
+		tmpDir.ID = bson.NewObjectId()    // Assign a unique document id for this object (to be stored in the database)
+		tmpDir.IDString = tmpDir.ID.Hex() // string instance of the document id
+		tmpDir.ChunkNumber = chk.Number
+		tmpDir.Data = chk.Data
+		tmpDir.EnqueueTime = time.Now().In(timePT).Format(time.RFC3339Nano)
+		tmpDir.ScheduledTime = chk.At.In(timePT).Format(time.RFC3339Nano)
+		jbID, pshSts, pshErr, err = postJob(tmpDir, chk.At)
+
+		rchk.ChunkNumber = chk.Number
+		rchk.JobID = jbID
+		rchk.IDString = tmpDir.IDString
+		rchk.NumRecipients = len(chk.Data)
+		rchk.ScheduledTime = tmpDir.ScheduledTime
+		rchk.EnqueueTime = tmpDir.EnqueueTime
+		rchk.MergedPromoSendIDs = drtv.MergedPromoSendIDs
+		rchk.PushStatus = pshSts
+		rchk.PushError = pshErr
+
+		// log activity
+		if err != nil {
+			// error occurred submitting a job
+			appLog("ERROR: %v - error submitting job #%v\n", utils.FileLine(), chk.Number)
+			logLines = jobLog(logLines, "ERROR: error submitting job #%v: %v", chk.Number, err)
+			rchk.IDString = ""
+			rchk.Error = err.Error()
+			rsp.NumFailedJobs = rsp.NumFailedJobs + 1
+		}
+		if err == nil && len(pshErr) != 0 {
+			// job stored but not pushed - the outbox dispatcher retries the push
+			appLog("WARN: %v - job #%v left pending for the outbox dispatcher\n", utils.FileLine(), chk.Number)
+			logLines = jobLog(logLines, "WARN: job #%v with job id: %v failed to push - left pending for a retried push: %v", chk.Number, jbID, pshErr)
+			rsp.NumPendingJobs = rsp.NumPendingJobs + 1
+		}
+		if err == nil {
+			// successfully submitted a job
+			appLog("INFO: %v - submitted job #%v\n", utils.FileLine(), chk.Number)
+			logLines = jobLog(logLines, "INFO: submitted job #%v with job id: %v scheduled at: %v (push state: %v)",
+				chk.Number,
+				jbID,
+				tmpDir.ScheduledTime,
+				pshSts)
+			jbIDs = append(jbIDs, jbID)
+			rsp.NumJobs = rsp.NumJobs + 1
+			rsp.NumMessages = rsp.NumMessages + len(chk.Data)
+		}
+
+		rsp.Chunks = append(rsp.Chunks, rchk)
 	}
 
-	// Parse the inbound request data
-	err = utils.FromJSONBytes(rbytes, &tMap)
+	return jbIDs, logLines
+}
+
+// recordEnqueue records the outcome of pushing a broadcast's jobs on its enqueue request and
+// (if scheduled) its promotion broadcast document
+func recordEnqueue(req enqueueRequest, psid string, jbIDs []string, logLines []string) []string {
+	var err error
+
+	// Record the enqueue outcome on the enqueue request
+	if len(jbIDs) == 0 {
+		// no jobs pushed - release the claim so the request can be retried
+		err = releaseEnqueue(req.DocID)
+	} else {
+		err = markEnqueued(req.DocID, jbIDs)
+	}
 	if err != nil {
-		// error parsing the request data
-		appLog("ERROR: %v - error parsing the request data. See: %v\n",
+		// error recording the enqueue outcome
+		appLog("ERROR: %v - error recording the enqueue outcome for request: %v. See: %v\n",
 			utils.FileLine(),
+			req.DocID,
 			err)
-		c.JSON(http.StatusBadRequest, fmt.Sprintf("error parsing the request data; see: %v", err))
-		return
 	}
 
-	appLog("INFO: %v - handling a new inbound request with request body:\n%v\n", utils.FileLine(), string(rbytes))
-
-	// Validate the request data
-	if len(tMap["docid"]) == 0 {
-		// missing parameter data
-		appLog("ERROR: %v - missing docid parameter data\n", utils.FileLine())
-		c.JSON(http.StatusBadRequest, fmt.Sprintf("missing docid parameter"))
-		return
+	// Track the broadcast's chunk jobs so it is flagged as sent once they have all finished
+	if len(jbIDs) != 0 {
+		err = trackPSendChunks(psid, len(jbIDs))
+		if err != nil {
+			// error recording the number of chunk jobs
+			appLog("ERROR: %v - error recording the number of chunk jobs queued for psend: %v. See: %v\n",
+				utils.FileLine(),
+				psid,
+				err)
+		}
 	}
 
-	if !bson.IsObjectIdHex(tMap["docid"]) {
-		// invalid document id
-		appLog("ERROR: %v - invalid document id: %v\n",
-			utils.FileLine(),
-			tMap["docid"])
-		c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid document id"))
-		return
+	// Scheduled broadcast? Record the scheduled send time on the promotion broadcast
+	if len(req.SendAt) != 0 && len(jbIDs) != 0 {
+		err = setPSendScheduled(psid, req.Start)
+		if err != nil {
+			// error recording the scheduled send time
+			appLog("ERROR: %v - error recording the scheduled send time for psend: %v. See: %v\n",
+				utils.FileLine(),
+				psid,
+				err)
+		}
+		logLines = jobLog(logLines, "INFO: broadcast scheduled to start sending at: %v", hlprDispDateTime(req.Start))
 	}
 
-	appLog("INFO: %v - inbound request for psend: %v in environment: %v\n",
-		utils.FileLine(),
-		tMap["docid"],
-		cfg.WorkerEnvironment)
+	return logLines
+}
 
-	// Fetch the enqueue data from the database
-	err = mgoCollJobQueueEnqueues.Find(bson.M{"idstring": tMap["docid"]}).One(&drtive)
+// hdlQueueSMSJob handles inbound requests to enqueue SMS message jobs to a Faktory worker
+func hdlQueueSMSJob(c *gin.Context) {
+	var (
+		err            error
+		logLines       []string
+		drtive, tmpDir ProcessDirective
+		jbID           string
+		claimed        bool
+		enqStatus      string
+		sched          sendSchedule
+		jbIDs          []string
+		plan           []chunkPlan
+		req            enqueueRequest
+		rsp            enqueueResponse
+		qchk           quotaCheck
+		res            quotaReservation
+		est            segmentEstimate
+	)
+
+	// Read and validate the request
+	req, err = readEnqueueRequest(c)
+	rsp.EnqueueDocID = req.DocID
+	rsp.PromoSendID = req.Drtv.PromoSendID
 	if err != nil {
-		// error fetching job enqueuing data
-		appLog("ERROR: %v - error fetching job enqueuing data: %v. See: %v\n",
-			utils.FileLine(),
-			tMap["docid"],
-			err)
-		c.JSON(http.StatusBadRequest, fmt.Sprintf("error fetching job enqueuing data; see: %v", err))
+		// invalid enqueue request
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusBadRequest, rsp)
 		return
 	}
+	drtive = req.Drtv
+	if len(req.SendAt) != 0 {
+		rsp.SendAt = req.Start.Format(time.RFC3339)
+	}
 
-	appLog("DEBUG: %v - enqueuing jobs with this information: psend: %v message: %v\n",
-		utils.FileLine(),
-		drtive.PromoSendID,
-		drtive.Message)
+	// Has this promotion send already been queued? If so, return the existing jobs rather than texting customers twice
+	if !req.Force {
+		rsp.Chunks, err = fetchEnqueuedChunks(drtive.PromoSendID)
+		if err != nil {
+			// error checking for existing job directives
+			appLog("ERROR: %v - error checking for existing job directives. See: %v\n", utils.FileLine(), err)
+			rsp.Status = cntEnqueueResultFailed
+			rsp.Msg = fmt.Sprintf("error checking for existing job directives; see: %v", err)
+			c.JSON(http.StatusInternalServerError, rsp)
+			return
+		}
 
-	//* Validate the unmarshaled data
+		if len(rsp.Chunks) != 0 {
+			// broadcast already queued - don't push the jobs again
+			appLog("INFO: %v - psend: %v has already been enqueued with %v jobs - not enqueuing again\n",
+				utils.FileLine(),
+				drtive.PromoSendID,
+				len(rsp.Chunks))
 
-	// Missing data?
-	if len(drtive.PromoSendID) == 0 ||
-		len(drtive.Data) == 0 ||
-		len(drtive.Message) == 0 {
-		// missing broadcast document id, end customer data, or message text
-		appLog("ERROR: %v - missing broadcast document id, end customer data, or message text\n", utils.FileLine())
-		c.JSON(http.StatusBadRequest, "missing broadcast document id, end customer data, or message text")
-		return
+			for _, chk := range rsp.Chunks {
+				rsp.NumJobs = rsp.NumJobs + 1
+				rsp.NumMessages = rsp.NumMessages + chk.NumRecipients
+			}
+			rsp.Status = cntEnqueueResultExisting
+			rsp.Msg = fmt.Sprintf("promotion send %v has already been enqueued - no new jobs enqueued", drtive.PromoSendID)
+			c.JSON(http.StatusOK, rsp)
+			return
+		}
 	}
 
-	// Invalid document ids?
-	if !bson.IsObjectIdHex(drtive.PromoSendID) {
-		// invalid document id
-		appLog("ERROR: %v - invalid document id - %v\n", utils.FileLine(), drtive.PromoSendID)
-		c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid document id - %v", drtive.PromoSendID))
+	// Validate the data rows and break them up into chunks
+	plan, rsp.SkippedRows, sched = buildChunkPlan(drtive.Data, req.Start, req.Rate)
+	rsp.MsgsPerSec = sched.MsgsPerSec
+	rsp.EstFinishTime = sched.finishTime(plan).In(timePT).Format(time.RFC3339)
+	for _, sr := range rsp.SkippedRows {
+		appLog("WARN: %v - data line %v is not queued: %v\n", utils.FileLine(), sr.Row, sr.Reason)
+	}
+	logLines = append(logLines, rsp.recipientReport(len(drtive.Data))...)
+	if len(plan) == 0 {
+		// no valid data rows - nothing to queue
+		appLog("ERROR: %v - psend: %v has no valid end customer data rows\n", utils.FileLine(), drtive.PromoSendID)
+		wrtJobLog(tmpDir.Environment, logLines, drtive.PromoSendID, "")
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = "no valid end customer data rows - nothing to enqueue"
+		c.JSON(http.StatusBadRequest, rsp)
 		return
 	}
 
-	// Iterate through the data and break up into chunks if necessary
-	tmpDir = ProcessDirective{}
-	tmpDir.PromoSendID = drtive.PromoSendID
-	tmpDir.Message = drtive.Message
-	tmpDir.MediaURL = drtive.MediaURL
-	tmpDir.IsXPathwayGenericPromo = drtive.IsXPathwayGenericPromo
-	ctr = 1        // phone number counter - determine if we're at our threshold of Twilio calls
-	runngDelay = 0 // running job delay for multiple jobs in seconds
-	for k, v := range drtive.Data {
-		var jbID string
-
-		// Exceed the processing threshold?
-		if ctr > cfg.WorkerMsgThreshold {
-			// Post (enqueue) a job to fire out a Promo Send's SMS messages
-			if jobCtr != 1 {
-				delayJob = true
-				runngDelay = runngDelay + cfg.WorkerJobDelaySec
-			}
-			tmpDir.ID = bson.NewObjectId()    // Assign a unique document id for this object (to be stored in the database)
-			tmpDir.IDString = tmpDir.ID.Hex() // string instance of the document id
-			tmpDir.EnqueueTime = time.Now().In(timePT).Format(time.RFC3339Nano)
-			jbID, err = postJob(tmpDir, delayJob, runngDelay)
-
-			// log activity
-			if err != nil {
-				// error occurred submitting a job
-				appLog("ERROR: %v - error submitting job #%v\n", utils.FileLine(), jobCtr)
-				jobLog(logLines, "ERROR: error submitting job #%v", jobCtr)
-			}
-			if err == nil {
-				// successfully submitted a job
-				appLog("INFO: %v - submitted job #%v\n", utils.FileLine(), jobCtr)
-				jobLog(logLines, "INFO: submitted job #%v with job id: %v", jobCtr, jbID)
-			}
-
-			// Reset the data for next chunk
-			tmpDir.Data = []map[string]string{}
-			jobCtr = jobCtr + 1
-			ctr = 1 // phone number counter - determine if we're at our threshold of Twilio requests
-		}
+	// Estimate the segments and cost of the messages (messages over the segment limit may be rejected)
+	est = estimateSegments(drtive, planRecipients(plan))
+	rsp.Segments = &est
+	if est.Rejected {
+		// messages exceed the segment limit
+		appLog("WARN: %v - psend: %v not enqueued - %v\n", utils.FileLine(), drtive.PromoSendID, est.Warning)
+		logLines = jobLog(logLines, "SEGMENTS: %v", est.Warning)
+		wrtJobLog(tmpDir.Environment, logLines, drtive.PromoSendID, "")
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = est.Warning
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
 
-		// Validate the data
-		if len(v["documentid"]) == 0 || len(v["phonenumber"]) == 0 {
-			// missing end customer document id or phone number
-			appLog("ERROR: %v - data line %v is missing the end customer document id or phone number\n", utils.FileLine(), k)
-			jobLog(logLines, "ERROR: data line %v is missing the end customer document id or phone number", k)
+	// Claim the enqueue request so concurrent (retried) requests don't push the same jobs
+	claimed, enqStatus, err = claimEnqueue(req.DocID, req.Force)
+	if err != nil {
+		// error claiming the enqueue request
+		appLog("ERROR: %v - error claiming the enqueue request. See: %v\n", utils.FileLine(), err)
+		rsp.Status = cntEnqueueResultFailed
+		rsp.Msg = fmt.Sprintf("error claiming the enqueue request; see: %v", err)
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+	if !claimed {
+		// another request has claimed (and is queuing) this broadcast
+		appLog("WARN: %v - enqueue request: %v has already been claimed with status: %v\n",
+			utils.FileLine(),
+			req.DocID,
+			enqStatus)
+		rsp.Status = cntEnqueueResultExisting
+		rsp.Msg = fmt.Sprintf("promotion send %v is already being enqueued (status: %v)", drtive.PromoSendID, enqStatus)
+		c.JSON(http.StatusConflict, rsp)
+		return
+	}
 
-			continue
+	// Reserve the recipients against the owner's sending quota (concurrent enqueues can't take the same allowance)
+	qchk, res, err = reserveQuota(drtive.PromoSendID, len(planRecipients(plan)), req.Start)
+	if err != nil || qchk.Rejected {
+		// nothing pushed - release the claim so the request can be retried
+		if rerr := releaseEnqueue(req.DocID); rerr != nil {
+			appLog("ERROR: %v - error releasing the claim on enqueue request: %v. See: %v\n", utils.FileLine(), req.DocID, rerr)
 		}
+	}
+	if err != nil {
+		// error reserving against the sending quota
+		appLog("ERROR: %v - error reserving against the sending quota of psend: %v. See: %v\n", utils.FileLine(), drtive.PromoSendID, err)
+		rsp.Status = cntEnqueueResultFailed
+		rsp.Msg = fmt.Sprintf("error checking the sending quota; see: %v", err)
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+	rsp.Quota = &qchk
+	if qchk.Rejected {
+		// broadcast exceeds the quota (or has no owner to check it against)
+		appLog("WARN: %v - psend: %v not enqueued - %v\n", utils.FileLine(), drtive.PromoSendID, qchk.Msg)
+		logLines = jobLog(logLines, "QUOTA: %v", qchk.Msg)
+		wrtJobLog(tmpDir.Environment, logLines, drtive.PromoSendID, "")
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = qchk.Msg
+		c.JSON(http.StatusForbidden, rsp)
+		return
+	}
+	if qchk.Dropped != 0 {
+		// queue only the recipients that fit
+		plan = sched.chunk(planRecipients(plan)[:qchk.Fits], req.Start)
+		rsp.EstFinishTime = sched.finishTime(plan).In(timePT).Format(time.RFC3339)
+		est = estimateSegments(drtive, planRecipients(plan))
+		logLines = jobLog(logLines, "QUOTA: %v", qchk.Msg)
+	}
+	logLines = jobLog(logLines, "SEGMENTS: %v messages - %v segments (%v UCS-2 encoded messages), estimated cost: $%.2f",
+		est.Messages,
+		est.Segments,
+		est.NumUCS2,
+		est.EstCost)
+	if len(est.Warning) != 0 {
+		logLines = jobLog(logLines, "SEGMENTS: WARNING - %v", est.Warning)
+	}
 
-		// Add data to the object for the next job invocation
-		tmpDir.Data = append(tmpDir.Data, v)
-		numCtr = numCtr + 1 // total number of phones texts (total # of texts)
-		ctr = ctr + 1       // phone number counter - determine if we're at our threshold of Twilio requests
+	// Post (enqueue) a job for each chunk of the plan and record the outcome
+	jbIDs, logLines = pushChunkPlan(req.DocID, drtive, plan, sched, &rsp, logLines)
+	logLines = recordEnqueue(req, drtive.PromoSendID, jbIDs, logLines)
+	if len(jbIDs) != 0 {
+		jbID = jbIDs[len(jbIDs)-1]
 	}
 
-	// Post (enqueue) a job to fire out the Promo Send's remaining SMS messages
-	var jbID string
-	if jobCtr != 1 {
-		delayJob = true
-		runngDelay = runngDelay + cfg.WorkerJobDelaySec
+	// Hand the reserved recipients of the pushed jobs to the broadcast and release the rest
+	err = res.take(drtive.PromoSendID, rsp.NumMessages)
+	if err == nil {
+		err = res.release()
 	}
-	tmpDir.ID = bson.NewObjectId()    // Assign a unique document id for this object (to be stored in the database)
-	tmpDir.IDString = tmpDir.ID.Hex() // string instance of the document id
-	tmpDir.EnqueueTime = time.Now().In(timePT).Format(time.RFC3339Nano)
-	jbID, err = postJob(tmpDir, delayJob, runngDelay)
-	// log activity
 	if err != nil {
-		// error occurred submitting a job
-		appLog("ERROR: %v - error submitting job #%v\n", utils.FileLine(), jobCtr)
-		jobLog(logLines, "ERROR: error submitting job #%v", jobCtr)
-	}
-	if err == nil {
-		// successfully submitted a job
-		appLog("INFO: %v - submitted job #%v\n", utils.FileLine(), jobCtr)
-		jobLog(logLines, "INFO: submitted job #%v with job id: %v", jobCtr, jbID)
+		// error settling the quota reservation
+		appLog("ERROR: %v - error settling the quota reservation of psend: %v. See: %v\n", utils.FileLine(), drtive.PromoSendID, err)
 	}
 
 	// Write the "log lines" to the database
 	wrtJobLog(tmpDir.Environment, logLines, drtive.PromoSendID, jbID) // Write to the Promotion Send's log
 
 	// return to the caller
-	appLog("INFO: %v - returning to caller with psend: %v after enqueuing %v jobs with %v messages and wrote the job log to: %v\n",
+	rsp.summarize()
+	appLog("INFO: %v - returning to caller with psend: %v - %v\n",
 		utils.FileLine(),
 		drtive.PromoSendID,
-		jobCtr,
-		numCtr,
-		fname)
-	c.JSON(http.StatusOK, fmt.Sprintf("successfully enqueued %v jobs sending %v messages", jobCtr, numCtr))
+		rsp.Msg)
+
+	if rsp.Status == cntEnqueueResultFailed {
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+	c.JSON(http.StatusOK, rsp)
 }
 
 // hdlQueueAdHocQRGenJob handles an inbound request to enqueue a job to generate and store a QR code for a shortcode
@@ -502,6 +682,7 @@ func hdlQueueAdHocQRGenJob(c *gin.Context) {
 		// error occurred submitting a job
 		appLog("ERROR: %v - error submitting job #%v\n", utils.FileLine(), jbID)
 		c.JSON(http.StatusInternalServerError, fmt.Sprintf("error submitting job #%v", jbID))
+		return
 	}
 
 	// return to the caller
@@ -554,6 +735,18 @@ func main() {
 	mgoCollJobQueueEnqueues = mgoDB.C("jobqueueenqueues") // collection holding the data used to submit individual worker jobs
 	mgoCollWorkerJobData = mgoDB.C("workerjobdata")       // collection holding the data to be processed by individual worker jobs
 	mgoCollWorkerJobLog = mgoDB.C("workerjoblog")         // collection holding log data/lines for a worker job run
+	mgoCollPromoBroadcasts = mgoDB.C("promobroadcasts")   // collection holding promotion broadcasts (sends)
+	mgoCollStopPhoneList = mgoDB.C("stopphonelist")       // collection holding phone numbers that have opted out of text messages
+	mgoCollSMSMessages = mgoDB.C("smsmessages")           // collection holding the text messages sent to end customers
+	mgoCollOwnerQuotas = mgoDB.C("ownerquotas")           // collection holding the sending quota adjustments of broadcast owners
+	mgoCollOwnerUsage = mgoDB.C("ownerusage")             // collection holding the recipients reserved against the sending quotas of broadcast owners
+
+	err = ensureOwnerUsageIndexes()
+	if err != nil {
+		// error creating the indexes reservations rely on
+		appLog("FATAL: %v - fatal error creating the ownerusage indexes. See: %v\n", utils.FileLine(), err)
+		os.Exit(1)
+	}
 
 	// Close the MongoDB session at the end of processing
 	defer mgoSession.Close()
@@ -582,13 +775,30 @@ func main() {
 
 	// Set up the web service routes
 	r := gin.Default()
-	r.POST("/enqueuejob", hdlQueueSMSJob)
-	r.POST("/queueqrgen", hdlQueueAdHocQRGenJob) // route that handles worker jobs generating QR code shortcodes on an ad hoc basis (shared promos)
+	if len(cfg.WorkerAuthKeys) == 0 {
+		appLog("WARN: %v - no caller keys configured - request authentication required?: %v\n", utils.FileLine(), cfg.WorkerAuthRequired)
+	}
+	api := r.Group("/", mdlAuth(cfg.WorkerAuthKeys, time.Duration(cfg.WorkerAuthWindowSec)*time.Second, cfg.WorkerAuthRequired)) // routes requiring signed requests
+	api.POST("/enqueuejob", hdlQueueSMSJob)
+	api.POST("/enqueuetag", hdlQueueTagJob)               // route that enqueues the promotion broadcasts of a tag group as one job plan
+	api.POST("/previewjob", hdlPreviewSMSJob)             // route that previews (dry runs) an enqueue request without queuing any jobs
+	api.POST("/queueqrgen", hdlQueueAdHocQRGenJob)        // route that handles worker jobs generating QR code shortcodes on an ad hoc basis (shared promos)
+	api.POST("/queueqrgenbatch", hdlQueueAdHocQRGenBatch) // route that handles worker jobs generating a list of QR code shortcodes in batches
+	api.POST("/cancelbroadcast", hdlCancelBroadcast)      // route that cancels the not yet started jobs of a promotion broadcast
+	api.GET("/progress/:psid", hdlBroadcastProgress)      // route that reports the progress of a promotion broadcast
+	api.POST("/resumebroadcast", hdlResumeBroadcast)      // route that resumes the halted or crashed chunk jobs of a promotion broadcast
+
+	admin := api.Group("/", mdlRequireCaller(cfg.WorkerAuthAdminKeys)) // routes restricted to admin callers
+	admin.GET("/ownerquota/:ownerid", hdlGetOwnerQuota)                // route that returns an owner's sending quota and usage
+	admin.POST("/ownerquota", hdlAdjustOwnerQuota)                     // route that adjusts an owner's plan and allowances
 	r.GET("/status", cmnWrkr.HndlrStatus)
 
 	// Fire goroutine used to execute cron jobs
 	go schedJobs()
 
+	// Fire goroutine used to push the jobs of pending job directives
+	go runOutboxDispatcher()
+
 	appLog("INFO: %v - starting the enqueue web service: %v\n", utils.FileLine(), appName)
 	// Start the web server
 	err = r.Run(fmt.Sprintf(":%v", port))
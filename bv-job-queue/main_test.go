@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mgo "github.com/globalsign/mgo"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// testDB points the service's collections at a scratch database (BVAPP_TESTDBURL: a MongoDB url); the test is
+// skipped if no database is configured; returns a func dropping the scratch database
+func testDB(t *testing.T) func() {
+	url := os.Getenv("BVAPP_TESTDBURL")
+	if len(url) == 0 {
+		t.Skip("BVAPP_TESTDBURL not set - skipping a test needing a MongoDB database")
+	}
+
+	ssn, err := mgo.DialWithTimeout(url, 10*time.Second)
+	if err != nil {
+		t.Fatalf("error dialing the test database: %v", err)
+	}
+	db := ssn.DB("bvtest_" + bson.NewObjectId().Hex())
+
+	mgoDB = db
+	mgoCollJobQueueEnqueues = db.C("jobqueueenqueues")
+	mgoCollWorkerJobData = db.C("workerjobdata")
+	mgoCollWorkerJobLog = db.C("workerjoblog")
+	mgoCollPromoBroadcasts = db.C("promobroadcasts")
+	mgoCollStopPhoneList = db.C("stopphonelist")
+	mgoCollSMSMessages = db.C("smsmessages")
+	mgoCollOwnerQuotas = db.C("ownerquotas")
+	mgoCollOwnerUsage = db.C("ownerusage")
+
+	timePT, err = time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatalf("error loading the PT timezone: %v", err)
+	}
+
+	return func() {
+		db.DropDatabase()
+		ssn.Close()
+	}
+}
+
+func TestEnqueueRepostReturnsExistingPlan(t *testing.T) {
+	var (
+		psid  = bson.NewObjectId().Hex()
+		docid = bson.NewObjectId().Hex()
+		data  = []map[string]string{{"phonenumber": "3125551212"}, {"phonenumber": "3125551213"}}
+		rsp   enqueueResponse
+	)
+
+	defer testDB(t)()
+	defer func(spec Specification) { cfg = spec }(cfg)
+	cfg.WorkerShortLinkPreview = "http://bdvi.be/x/AbC1234"
+
+	err := mgoCollJobQueueEnqueues.Insert(bson.M{
+		"_id":         bson.ObjectIdHex(docid),
+		"idstring":    docid,
+		"promosendid": psid,
+		"message":     "20% off today: |||",
+		"data":        data,
+	})
+	if err != nil {
+		t.Fatalf("error inserting the enqueue document: %v", err)
+	}
+
+	// The directives of the earlier enqueue (stored whole, as postJob stores them) and a deferred follow-up
+	for i, from := range []string{"", "planned"} {
+		drt := ProcessDirective{
+			ID:           bson.NewObjectId(),
+			PromoSendID:  psid,
+			JobID:        bson.NewObjectId().Hex(),
+			Data:         data,
+			EnqueueDocID: docid,
+			ChunkNumber:  i + 1,
+			PushStatus:   cntPushStatusPushed,
+			DeferredFrom: from,
+		}
+		drt.IDString = drt.ID.Hex()
+		err = mgoCollWorkerJobData.Insert(drt)
+		if err != nil {
+			t.Fatalf("error inserting a job directive: %v", err)
+		}
+	}
+
+	// Re-post the enqueue request
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/enqueuejob", hdlQueueSMSJob)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/enqueuejob", bytes.NewReader([]byte(`{"docid":"`+docid+`"}`))))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %v; want %v (%v)", w.Code, http.StatusOK, w.Body.String())
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &rsp)
+	if err != nil {
+		t.Fatalf("error parsing the response: %v", err)
+	}
+	if rsp.Status != cntEnqueueResultExisting {
+		t.Errorf("got status %q; want %q", rsp.Status, cntEnqueueResultExisting)
+	}
+	if len(rsp.Chunks) != 1 || rsp.Chunks[0].ChunkNumber != 1 || rsp.NumJobs != 1 || rsp.NumMessages != len(data) {
+		t.Errorf("got %v jobs (%v messages) and chunks: %+v; want the planned chunk #1 only", rsp.NumJobs, rsp.NumMessages, rsp.Chunks)
+	}
+
+	n, err := mgoCollWorkerJobData.Find(bson.M{"promosendid": psid}).Count()
+	if err != nil {
+		t.Fatalf("error counting job directives: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("got %v job directives; want 2 (nothing enqueued again)", n)
+	}
+}
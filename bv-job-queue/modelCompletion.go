@@ -0,0 +1,255 @@
+// modelCompletion.go tracks a promotion broadcast's chunk jobs and flags the broadcast as sent (with its final
+// counts) once every chunk job, and the QR code and snapshot jobs it spawned, have finished
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/danoand/utils"
+	mgo "github.com/globalsign/mgo"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// chunkResult models the outcome of a chunk job recorded on its job directive by bv-job-worker-smsmsgs
+type chunkResult struct {
+	Status     string    `bson:"status" json:"status"`         // 'done', 'halted', 'failed' or 'cancelled' (never ran)
+	Sent       int       `bson:"sent" json:"sent"`             // messages accepted by Twilio
+	Failed     int       `bson:"failed" json:"failed"`         // messages rejected by (or not posted to) Twilio
+	Skipped    int       `bson:"skipped" json:"skipped"`       // recipients skipped (e.g. invalid phone number)
+	StopListed int       `bson:"stoplisted" json:"stoplisted"` // recipients on the stop list
+	Deferred   int       `bson:"deferred" json:"deferred"`     // recipients outside their call hours (texted by follow-up jobs)
+	Segments   int       `bson:"segments" json:"segments"`     // segments of the messages accepted by Twilio (by the last run of a resumed job)
+	QRJobID    string    `bson:"qrjobid" json:"qrjobid"`       // job generating the QR codes of the chunk's messages
+	SnapJobID  string    `bson:"snapjobid" json:"snapjobid"`   // job taking snapshots of the chunk's messages
+	FinishTime time.Time `bson:"finishtime" json:"finishtime"` // time the chunk job finished
+}
+
+// psendResults models the final counts of a promotion broadcast
+type psendResults struct {
+	NumChunks    int       `bson:"numchunks" json:"numchunks"`       // number of chunk jobs queued for the broadcast
+	Sent         int       `bson:"sent" json:"sent"`                 // messages accepted by Twilio
+	Failed       int       `bson:"failed" json:"failed"`             // messages that failed to send
+	Skipped      int       `bson:"skipped" json:"skipped"`           // recipients skipped (e.g. invalid phone number)
+	StopListed   int       `bson:"stoplisted" json:"stoplisted"`     // recipients on the stop list
+	Halted       int       `bson:"halted" json:"halted"`             // recipients not texted because a job was halted or cancelled
+	Segments     int       `bson:"segments" json:"segments"`         // segments of the messages accepted by Twilio
+	EstCost      float64   `bson:"estcost" json:"estcost"`           // estimated cost (USD) of the messages accepted by Twilio
+	CompleteTime time.Time `bson:"completetime" json:"completetime"` // time the broadcast was flagged as sent
+}
+
+// trackPSendChunks adds chunk jobs queued for a promotion broadcast to the number its completion tracker waits for
+func trackPSendChunks(id string, num int) error {
+	var err error
+
+	if !bson.IsObjectIdHex(id) {
+		// id is not a valid document id
+		return fmt.Errorf("%v is not a valid document id", id)
+	}
+
+	err = mgoCollPromoBroadcasts.UpdateId(
+		bson.ObjectIdHex(id),
+		bson.M{
+			"$inc": bson.M{"psendnumchunks": num},
+			"$set": bson.M{"psendtracking": true},
+		},
+	)
+	if err != nil {
+		// error recording the number of chunk jobs
+		return fmt.Errorf("error recording the number of chunk jobs: %v", err)
+	}
+
+	return nil
+}
+
+// finishUnrunDirective records the outcome of a cancelled job directive whose job will never run; a directive
+// whose job has started (or recorded an outcome) is left to its job
+func finishUnrunDirective(id bson.ObjectId) error {
+	err := mgoCollWorkerJobData.Update(
+		bson.M{
+			"_id":                id,
+			"chunkstarttime":     bson.M{"$in": []interface{}{time.Time{}, nil}},
+			"chunkresult.status": bson.M{"$in": []interface{}{"", nil}},
+		},
+		bson.M{"$set": bson.M{
+			"chunkresult":   chunkResult{Status: cntChunkStatusCancelled, FinishTime: time.Now().UTC()},
+			"chunkqrdone":   true,
+			"chunksnapdone": true,
+		}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// priorSegments sums the segments of the messages accepted by the earlier (halted or crashed) runs of a resumed
+// chunk job - the resumed run's outcome only holds the segments of the messages it sent
+func priorSegments(jobIDs []string) (int, error) {
+	var sums []struct {
+		Num int `bson:"num"`
+	}
+
+	if len(jobIDs) == 0 {
+		return 0, nil
+	}
+
+	err := mgoCollSMSMessages.Pipe([]bson.M{
+		{"$match": bson.M{"jobid": bson.M{"$in": jobIDs}, "twiliostatus": 201}},
+		{"$group": bson.M{"_id": nil, "num": bson.M{"$sum": "$segments"}}},
+	}).All(&sums)
+	if err != nil {
+		// error summing the segments
+		return 0, fmt.Errorf("error summing the segments sent by jobs: %v; see: %v", jobIDs, err)
+	}
+	if len(sums) == 0 {
+		return 0, nil
+	}
+
+	return sums[0].Num, nil
+}
+
+// completePSend flags a tracked promotion broadcast as sent with its final counts if all of its chunk jobs (and
+// their QR code and snapshot jobs) have finished; returns true if the broadcast was flagged
+func completePSend(id bson.ObjectId) (bool, error) {
+	var (
+		err      error
+		rslts    psendResults
+		logLines []string
+		drts     []ProcessDirective
+		psend    struct {
+			NumChunks int `bson:"psendnumchunks"`
+		}
+	)
+
+	err = mgoCollPromoBroadcasts.FindId(id).Select(bson.M{"psendnumchunks": 1}).One(&psend)
+	if err != nil {
+		// error fetching the promotion broadcast
+		return false, fmt.Errorf("error fetching promotion broadcast: %v; see: %v", id.Hex(), err)
+	}
+
+	err = mgoCollWorkerJobData.Find(bson.M{
+		"promosendid":        id.Hex(),
+		"chunkresult.status": bson.M{"$nin": []interface{}{"", nil}},
+		"chunkqrdone":        true,
+		"chunksnapdone":      true,
+	}).All(&drts)
+	if err != nil {
+		// error fetching the finished job directives
+		return false, fmt.Errorf("error fetching the finished job directives of promotion broadcast: %v; see: %v", id.Hex(), err)
+	}
+
+	// Chunk jobs still running (or waiting to run)?
+	if len(drts) < psend.NumChunks {
+		return false, nil
+	}
+
+	// Tally the chunk outcomes
+	rslts.NumChunks = len(drts)
+	for _, d := range drts {
+		var (
+			rmn   = len(d.Data) - d.ChunkResult.Sent - d.ChunkResult.Failed - d.ChunkResult.Skipped - d.ChunkResult.StopListed - d.ChunkResult.Deferred
+			prior int
+		)
+
+		// Resumed chunk? Add the segments sent by its earlier runs
+		prior, err = priorSegments(d.PriorJobIDs)
+		if err != nil {
+			return false, fmt.Errorf("error tallying chunk #%v of promotion broadcast: %v; see: %v", d.ChunkNumber, id.Hex(), err)
+		}
+		d.ChunkResult.Segments = d.ChunkResult.Segments + prior
+
+		rslts.Sent = rslts.Sent + d.ChunkResult.Sent
+		rslts.Failed = rslts.Failed + d.ChunkResult.Failed
+		rslts.Skipped = rslts.Skipped + d.ChunkResult.Skipped
+		rslts.StopListed = rslts.StopListed + d.ChunkResult.StopListed
+		rslts.Segments = rslts.Segments + d.ChunkResult.Segments
+		if len(d.MediaURL) != 0 {
+			rslts.EstCost = rslts.EstCost + float64(d.ChunkResult.Sent)*cfg.WorkerCostPerMMS
+		} else {
+			rslts.EstCost = rslts.EstCost + float64(d.ChunkResult.Segments)*cfg.WorkerCostPerSegment
+		}
+
+		// Recipients a chunk job never got to
+		if rmn <= 0 {
+			continue
+		}
+		if d.ChunkResult.Status == cntChunkStatusFailed {
+			rslts.Failed = rslts.Failed + rmn
+			continue
+		}
+		rslts.Halted = rslts.Halted + rmn
+	}
+	rslts.CompleteTime = time.Now().UTC()
+
+	// Flag the broadcast as sent (unless another run of the tracker beat us to it)
+	err = mgoCollPromoBroadcasts.Update(
+		bson.M{"_id": id, "psendtracking": true},
+		bson.M{"$set": bson.M{
+			"status":        cntPromoSendSent,
+			"psendresults":  rslts,
+			"psendtracking": false,
+		}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		// error flagging the promotion broadcast as sent
+		return false, fmt.Errorf("error flagging promotion broadcast: %v as sent; see: %v", id.Hex(), err)
+	}
+
+	// Release the recipients reserved against the owner's sending quota that weren't texted
+	err = releaseUnsent(id, rslts.Sent)
+	if err != nil {
+		// error releasing the quota reservation
+		appLog("ERROR: %v - error releasing the quota reservation of psend: %v. See: %v\n", utils.FileLine(), id.Hex(), err)
+	}
+
+	logLines = jobLog(logLines, "COMPLETE: all %v chunk job(s) finished - sent: %v, failed: %v, skipped: %v, stop listed: %v, halted: %v, segments: %v (estimated cost: $%.2f)",
+		rslts.NumChunks,
+		rslts.Sent,
+		rslts.Failed,
+		rslts.Skipped,
+		rslts.StopListed,
+		rslts.Halted,
+		rslts.Segments,
+		rslts.EstCost)
+	wrtJobLog(cfg.WorkerEnvironment, logLines, id.Hex(), "")
+
+	return true, nil
+}
+
+// trackCompletions flags tracked promotion broadcasts whose chunk jobs have all finished as sent (run as a cron job)
+func trackCompletions() {
+	var (
+		err  error
+		fin  bool
+		cnt  int
+		docs []struct {
+			ID bson.ObjectId `bson:"_id"`
+		}
+	)
+
+	err = mgoCollPromoBroadcasts.Find(bson.M{"psendtracking": true}).Select(bson.M{"_id": 1}).All(&docs)
+	if err != nil {
+		// error fetching the tracked promotion broadcasts
+		appLog("ERROR: %v - error fetching the tracked promotion broadcasts. See: %v\n", utils.FileLine(), err)
+		return
+	}
+
+	for _, d := range docs {
+		fin, err = completePSend(d.ID)
+		if err != nil {
+			appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+			continue
+		}
+		if fin {
+			appLog("INFO: %v - promotion broadcast: %v flagged as sent\n", utils.FileLine(), d.ID.Hex())
+			cnt = cnt + 1
+		}
+	}
+
+	if cnt != 0 {
+		appLog("INFO: %v - %v of %v tracked promotion broadcasts completed\n", utils.FileLine(), cnt, len(docs))
+	}
+}
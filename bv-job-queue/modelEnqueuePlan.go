@@ -0,0 +1,199 @@
+// modelEnqueuePlan.go models the chunk plan of a promotion broadcast and the response returned to an enqueue request
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// enqueueResponse models the response returned to a request to enqueue a promotion broadcast
+type enqueueResponse struct {
+	Status         string              `json:"status"`         // outcome of the request: 'enqueued', 'partial', 'failed', 'existing', 'rejected'
+	Msg            string              `json:"msg"`            // message describing the outcome
+	PromoSendID    string              `json:"promosenddocid"` // document id of the broadcast being enqueued
+	EnqueueDocID   string              `json:"enqueuedocid"`   // jobqueueenqueues document id (idstring)
+	SendAt         string              `json:"sendat"`         // time the broadcast is scheduled to start sending (empty: immediately)
+	MsgsPerSec     float64             `json:"msgspersec"`     // target send rate used to schedule the chunks (0: fixed schedule)
+	EstFinishTime  string              `json:"estfinishtime"`  // estimated time the last chunk finishes sending
+	NumJobs        int                 `json:"numjobs"`        // number of jobs queued (pushed or pending)
+	NumMessages    int                 `json:"nummessages"`    // number of recipients in queued jobs
+	NumFailedJobs  int                 `json:"numfailedjobs"`  // number of jobs that failed to queue (nothing stored)
+	NumPendingJobs int                 `json:"numpendingjobs"` // number of queued jobs that failed to push (left pending for the outbox dispatcher)
+	Chunks         []enqueueChunk      `json:"chunks"`         // per chunk job plan
+	SkippedRows    []enqueueSkippedRow `json:"skippedrows"`    // data rows that were not queued
+	Quota          *quotaCheck         `json:"quota"`          // outcome of checking the broadcast against its owner's sending quota
+	Segments       *segmentEstimate    `json:"segments"`       // estimated segments and cost of the broadcast's messages
+}
+
+// enqueueChunk summarizes a job directive (chunk of recipients) queued for a promotion send
+type enqueueChunk struct {
+	ChunkNumber   int    `json:"chunknumber"`         // sequence number of the chunk within the broadcast
+	JobID         string `json:"jobid"`               // Faktory job id
+	IDString      string `json:"idstring"`            // workerjobdata document id
+	NumRecipients int    `json:"numrecipients"`       // number of recipients in the chunk
+	ScheduledTime string `json:"scheduledtime"`       // time the job is scheduled to run (job.At)
+	EnqueueTime   string `json:"enqueuetime"`         // time the chunk was queued
+	PushStatus    string `json:"pushstatus"`          // outbox state of the chunk's job: 'pending', 'pushing', 'pushed', 'orphaned'
+	PushError     string `json:"pusherror,omitempty"` // error pushing the chunk's job (left pending for the outbox dispatcher)
+	Error         string `json:"error,omitempty"`     // error queuing the chunk's job (if any)
+
+	MergedPromoSendIDs []string `json:"mergedpromosenddocids,omitempty"` // broadcasts whose messages were merged into the chunk's message
+}
+
+// enqueueSkippedRow models an enqueue data row that was not queued
+type enqueueSkippedRow struct {
+	Row         int    `json:"row"`         // index of the row in the enqueue data
+	DocumentID  string `json:"documentid"`  // end customer document id
+	PhoneNumber string `json:"phonenumber"` // end customer phone number
+	Reason      string `json:"reason"`      // reason the row was skipped
+}
+
+// chunkPlan models a chunk of recipients to be sent by a single job
+type chunkPlan struct {
+	Number int                 // sequence number of the chunk within the broadcast (starting at 1)
+	At     time.Time           // time the chunk's job should run
+	Data   []map[string]string // recipients in the chunk
+}
+
+// buildChunkPlan validates the enqueue data rows and groups the remaining recipients into chunks
+// scheduled from a start time at a target send rate
+func buildChunkPlan(data []map[string]string, start time.Time, rate float64) ([]chunkPlan, []enqueueSkippedRow, sendSchedule) {
+	var (
+		rcpts   []map[string]string
+		skipped []enqueueSkippedRow
+		sched   sendSchedule
+	)
+
+	rcpts, skipped = filterRecipients(data)
+	sched = newSendSchedule(rate, len(rcpts))
+
+	return sched.chunk(rcpts, start), skipped, sched
+}
+
+// planRecipients returns the recipients of a chunk plan in the order they are sent
+func planRecipients(plan []chunkPlan) []map[string]string {
+	var rcpts []map[string]string
+
+	for _, chk := range plan {
+		rcpts = append(rcpts, chk.Data...)
+	}
+
+	return rcpts
+}
+
+// filterRecipients validates, normalizes, and de-duplicates the enqueue data rows
+func filterRecipients(data []map[string]string) ([]map[string]string, []enqueueSkippedRow) {
+	var (
+		rcpts   []map[string]string
+		skipped []enqueueSkippedRow
+		phn     string
+		ok      bool
+		seen    = make(map[string]int) // normalized phone number -> first data row
+	)
+
+	for k, v := range data {
+		var row = enqueueSkippedRow{Row: k, DocumentID: v["documentid"], PhoneNumber: v["phonenumber"]}
+
+		// Validate the data
+		if len(v["documentid"]) == 0 || len(v["phonenumber"]) == 0 {
+			// missing end customer document id or phone number
+			row.Reason = cntSkipReasonMissingData
+			skipped = append(skipped, row)
+			continue
+		}
+
+		// Normalize the phone number
+		phn, ok = hlprNormalizePhone(v["phonenumber"])
+		if !ok {
+			// invalid phone number
+			row.Reason = cntSkipReasonInvalidPhone
+			skipped = append(skipped, row)
+			continue
+		}
+
+		// Already queued this phone number?
+		if frst, dup := seen[phn]; dup {
+			// duplicate phone number
+			row.Reason = fmt.Sprintf("%v (first seen on data line %v)", cntSkipReasonDuplicatePhone, frst)
+			skipped = append(skipped, row)
+			continue
+		}
+		seen[phn] = k
+
+		// Keep the recipient (with the normalized phone number)
+		rcp := make(map[string]string, len(v))
+		for key, val := range v {
+			rcp[key] = val
+		}
+		rcp["phonenumber"] = phn
+		rcpts = append(rcpts, rcp)
+	}
+
+	return rcpts, skipped
+}
+
+// recipientReport summarizes the data rows removed from a broadcast as job log lines
+func (rsp *enqueueResponse) recipientReport(numRows int) []string {
+	var (
+		lines   []string
+		tally   []string
+		order   []string
+		reasons = make(map[string]int)
+	)
+
+	for _, sr := range rsp.SkippedRows {
+		lines = jobLog(lines, "RECIPIENT: data line %v (document id: %v phone: %v) removed - %v",
+			sr.Row,
+			sr.DocumentID,
+			sr.PhoneNumber,
+			sr.Reason)
+
+		// Tally removals by reason (ignoring the detail in parentheses)
+		rsn := sr.Reason
+		if i := strings.Index(rsn, " ("); i != -1 {
+			rsn = rsn[:i]
+		}
+		if reasons[rsn] == 0 {
+			order = append(order, rsn)
+		}
+		reasons[rsn]++
+	}
+
+	for _, rsn := range order {
+		tally = append(tally, fmt.Sprintf("%v: %v", rsn, reasons[rsn]))
+	}
+
+	lines = jobLog(lines, "RECIPIENT REPORT: %v data lines received, %v removed (%v), %v remaining",
+		numRows,
+		len(rsp.SkippedRows),
+		strings.Join(tally, ", "),
+		numRows-len(rsp.SkippedRows))
+
+	return lines
+}
+
+// summarize sets the outcome status and message of an enqueue response
+func (rsp *enqueueResponse) summarize() {
+	switch {
+
+	// CASE: no jobs pushed
+	case rsp.NumJobs == 0:
+		rsp.Status = cntEnqueueResultFailed
+
+	// CASE: some jobs failed to queue or to push
+	case rsp.NumFailedJobs != 0 || rsp.NumPendingJobs != 0:
+		rsp.Status = cntEnqueueResultPartial
+
+	// DEFAULT: all jobs pushed
+	default:
+		rsp.Status = cntEnqueueResultEnqueued
+	}
+
+	rsp.Msg = fmt.Sprintf("enqueued %v of %v jobs sending %v messages (%v jobs failed to push and are pending a retry, %v data rows skipped)",
+		rsp.NumJobs,
+		rsp.NumJobs+rsp.NumFailedJobs,
+		rsp.NumMessages,
+		rsp.NumPendingJobs,
+		len(rsp.SkippedRows))
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestEnqueueResponseSummarize(t *testing.T) {
+	var tests = []struct {
+		name    string
+		jobs    int
+		failed  int
+		pending int
+		status  string
+	}{
+		{"all pushed", 3, 0, 0, cntEnqueueResultEnqueued},
+		{"push left pending", 3, 0, 1, cntEnqueueResultPartial},
+		{"every push left pending", 3, 0, 3, cntEnqueueResultPartial},
+		{"job not stored", 2, 1, 0, cntEnqueueResultPartial},
+		{"nothing queued", 0, 3, 0, cntEnqueueResultFailed},
+	}
+
+	for _, tt := range tests {
+		rsp := enqueueResponse{NumJobs: tt.jobs, NumFailedJobs: tt.failed, NumPendingJobs: tt.pending}
+		rsp.summarize()
+		if rsp.Status != tt.status {
+			t.Errorf("%v: summarize() status = %q; want %q (%v)", tt.name, rsp.Status, tt.status, rsp.Msg)
+		}
+	}
+}
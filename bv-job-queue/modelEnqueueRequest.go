@@ -0,0 +1,197 @@
+// modelEnqueueRequest.go models a validated request to enqueue (or preview) a promotion broadcast
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// enqueueRequest models a validated request to enqueue (or preview) a promotion broadcast
+type enqueueRequest struct {
+	DocID  string            // jobqueueenqueues document id (idstring)
+	Force  bool              // re-enqueue a broadcast that has already been queued
+	Drtv   ProcessDirective  // enqueue data fetched from the jobqueueenqueues document
+	SendAt string            // send-at time requested for the broadcast (empty: send immediately)
+	Start  time.Time         // time the first chunk is scheduled to run
+	Rate   float64           // target send rate in messages per second (0: fixed schedule)
+	Params map[string]string // inbound request parameters
+}
+
+// readRequestParams reads and parses the JSON body of an inbound request
+func readRequestParams(c *gin.Context) (map[string]string, error) {
+	var (
+		err    error
+		rbytes []byte
+		tMap   = make(map[string]string)
+	)
+
+	// Read the request body
+	rbytes, err = c.GetRawData()
+	if err != nil {
+		// error reading the request data
+		appLog("ERROR: %v - error reading the request data. See: %v\n",
+			utils.FileLine(),
+			err)
+		return tMap, fmt.Errorf("error reading the request data; see: %v", err)
+	}
+
+	// Parse the inbound request data
+	err = utils.FromJSONBytes(rbytes, &tMap)
+	if err != nil {
+		// error parsing the request data
+		appLog("ERROR: %v - error parsing the request data. See: %v\n",
+			utils.FileLine(),
+			err)
+		return tMap, fmt.Errorf("error parsing the request data; see: %v", err)
+	}
+
+	appLog("INFO: %v - handling a new inbound request with request body:\n%v\n", utils.FileLine(), string(rbytes))
+
+	return tMap, nil
+}
+
+// readEnqueueRequest reads, parses, and validates an inbound enqueue (or preview) request
+func readEnqueueRequest(c *gin.Context) (enqueueRequest, error) {
+	var (
+		err error
+		req enqueueRequest
+	)
+
+	req.Params, err = readRequestParams(c)
+	if err != nil {
+		return req, err
+	}
+
+	// Validate the request data
+	req.DocID = req.Params["docid"]
+	if len(req.DocID) == 0 {
+		// missing parameter data
+		appLog("ERROR: %v - missing docid parameter data\n", utils.FileLine())
+		return req, fmt.Errorf("missing docid parameter")
+	}
+
+	if !bson.IsObjectIdHex(req.DocID) {
+		// invalid document id
+		appLog("ERROR: %v - invalid document id: %v\n",
+			utils.FileLine(),
+			req.DocID)
+		return req, fmt.Errorf("invalid document id")
+	}
+
+	// Is the caller (an operator) forcing a re-enqueue of an already queued broadcast?
+	req.Force = req.Params["force"] == cntParamTrue
+
+	appLog("INFO: %v - inbound request for psend: %v in environment: %v (force re-enqueue?: %v)\n",
+		utils.FileLine(),
+		req.DocID,
+		cfg.WorkerEnvironment,
+		req.Force)
+
+	// Fetch the enqueue data from the database
+	err = mgoCollJobQueueEnqueues.Find(bson.M{"idstring": req.DocID}).One(&req.Drtv)
+	if err != nil {
+		// error fetching job enqueuing data
+		appLog("ERROR: %v - error fetching job enqueuing data: %v. See: %v\n",
+			utils.FileLine(),
+			req.DocID,
+			err)
+		return req, fmt.Errorf("error fetching job enqueuing data; see: %v", err)
+	}
+
+	appLog("DEBUG: %v - enqueuing jobs with this information: psend: %v message: %v\n",
+		utils.FileLine(),
+		req.Drtv.PromoSendID,
+		req.Drtv.Message)
+
+	err = validateDirective(req.Drtv)
+	if err != nil {
+		return req, err
+	}
+
+	err = req.resolveSchedule()
+	if err != nil {
+		return req, err
+	}
+
+	return req, nil
+}
+
+// validateDirective validates the enqueue data read from a jobqueueenqueues document
+func validateDirective(drtv ProcessDirective) error {
+	// Missing data?
+	if len(drtv.PromoSendID) == 0 ||
+		len(drtv.Data) == 0 ||
+		len(drtv.Message) == 0 {
+		// missing broadcast document id, end customer data, or message text
+		appLog("ERROR: %v - missing broadcast document id, end customer data, or message text\n", utils.FileLine())
+		return fmt.Errorf("missing broadcast document id, end customer data, or message text")
+	}
+
+	// Invalid document ids?
+	if !bson.IsObjectIdHex(drtv.PromoSendID) {
+		// invalid document id
+		appLog("ERROR: %v - invalid document id - %v\n", utils.FileLine(), drtv.PromoSendID)
+		return fmt.Errorf("invalid document id - %v", drtv.PromoSendID)
+	}
+
+	// Broken message template?
+	err := validateMsgTemplate(drtv)
+	if err != nil {
+		// message template can't be rendered
+		appLog("ERROR: %v - message of psend: %v - %v\n", utils.FileLine(), drtv.PromoSendID, err)
+		return err
+	}
+
+	return nil
+}
+
+// resolveSchedule determines when the broadcast starts sending and at what rate; request parameters
+// override the enqueue document, which overrides the service defaults
+func (req *enqueueRequest) resolveSchedule() error {
+	var (
+		err    error
+		sendTZ string
+	)
+
+	// Scheduled broadcast? The request's send-at time overrides the enqueue document's
+	req.SendAt, sendTZ = req.Drtv.SendAt, req.Drtv.SendAtTZ
+	if len(req.Params["sendat"]) != 0 {
+		req.SendAt, sendTZ = req.Params["sendat"], req.Params["sendattz"]
+	}
+	req.Start = time.Now()
+	if len(req.SendAt) != 0 {
+		req.Start, err = hlprParseSendAt(req.SendAt, sendTZ)
+		if err != nil {
+			// invalid send-at time
+			appLog("ERROR: %v - invalid send-at time: %v (%v). See: %v\n", utils.FileLine(), req.SendAt, sendTZ, err)
+			return fmt.Errorf("invalid send-at time; see: %v", err)
+		}
+
+		if req.Start.Before(time.Now().Add(-time.Minute)) {
+			// send-at time has already passed
+			appLog("ERROR: %v - send-at time: %v has already passed\n", utils.FileLine(), req.Start)
+			return fmt.Errorf("send-at time: %v has already passed", req.Start.Format(time.RFC3339))
+		}
+	}
+
+	// Determine the target send rate: request parameter, then the enqueue document, then the service default
+	req.Rate = cfg.WorkerTargetMsgsPerSec
+	if req.Drtv.MsgsPerSec > 0 {
+		req.Rate = req.Drtv.MsgsPerSec
+	}
+	if len(req.Params["msgspersec"]) != 0 {
+		req.Rate, err = strconv.ParseFloat(req.Params["msgspersec"], 64)
+		if err != nil || req.Rate < 0 {
+			// invalid send rate
+			appLog("ERROR: %v - invalid msgspersec parameter: %v\n", utils.FileLine(), req.Params["msgspersec"])
+			return fmt.Errorf("invalid msgspersec parameter: %v", req.Params["msgspersec"])
+		}
+	}
+
+	return nil
+}
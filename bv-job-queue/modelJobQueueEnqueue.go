@@ -0,0 +1,109 @@
+// modelJobQueueEnqueue.go models the enqueue state of a jobqueueenqueues document so a broadcast is only queued once
+package main
+
+import (
+	"fmt"
+	"time"
+
+	mgo "github.com/globalsign/mgo"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// jobQueueEnqueue models the enqueue bookkeeping fields of a jobqueueenqueues document
+type jobQueueEnqueue struct {
+	ID               bson.ObjectId `bson:"_id" json:"docid"`                         // document object id
+	IDString         string        `bson:"idstring" json:"idstring"`                 // document object id as a string
+	EnqueueStatus    string        `bson:"enqueuestatus" json:"enqueuestatus"`       // enqueue state: 'enqueuing', 'enqueued'
+	EnqueueClaimTime time.Time     `bson:"enqueueclaimtime" json:"enqueueclaimtime"` // time the enqueue request was claimed
+	EnqueueJobIDs    []string      `bson:"enqueuejobids" json:"enqueuejobids"`       // job ids pushed for the enqueue request
+}
+
+// claimEnqueue atomically flags a jobqueueenqueues document as being enqueued; returns false
+// and the current enqueue state if an earlier request already claimed the document
+func claimEnqueue(docid string, force bool) (bool, string, error) {
+	var (
+		err error
+		qry bson.M
+		doc jobQueueEnqueue
+	)
+
+	// Only claim documents that have not been claimed before (unless forcing a re-enqueue)
+	qry = bson.M{"idstring": docid, "enqueuestatus": bson.M{"$exists": false}}
+	if force {
+		qry = bson.M{"idstring": docid}
+	}
+
+	_, err = mgoCollJobQueueEnqueues.Find(qry).Apply(
+		mgo.Change{
+			Update: bson.M{"$set": bson.M{
+				"enqueuestatus":    cntEnqueueStatusEnqueuing,
+				"enqueueclaimtime": time.Now().UTC(),
+				"enqueuejobids":    []string{},
+			}},
+		},
+		&doc)
+	if err == mgo.ErrNotFound {
+		// document has been claimed by an earlier request - fetch its current state
+		err = mgoCollJobQueueEnqueues.Find(bson.M{"idstring": docid}).One(&doc)
+		if err != nil {
+			return false, "", fmt.Errorf("error fetching the enqueue state of document: %v; see: %v", docid, err)
+		}
+
+		return false, doc.EnqueueStatus, nil
+	}
+	if err != nil {
+		// error claiming the enqueue document
+		return false, "", fmt.Errorf("error claiming enqueue document: %v; see: %v", docid, err)
+	}
+
+	return true, cntEnqueueStatusEnqueuing, nil
+}
+
+// releaseEnqueue clears the enqueue claim on a jobqueueenqueues document (nothing was pushed)
+func releaseEnqueue(docid string) error {
+	return mgoCollJobQueueEnqueues.Update(
+		bson.M{"idstring": docid},
+		bson.M{"$unset": bson.M{"enqueuestatus": "", "enqueueclaimtime": "", "enqueuejobids": ""}})
+}
+
+// markEnqueued records the job ids pushed for a jobqueueenqueues document
+func markEnqueued(docid string, jbIDs []string) error {
+	return mgoCollJobQueueEnqueues.Update(
+		bson.M{"idstring": docid},
+		bson.M{"$set": bson.M{"enqueuestatus": cntEnqueueStatusEnqueued, "enqueuejobids": jbIDs}})
+}
+
+// fetchEnqueuedChunks fetches the job directives already stored for a promotion send (follow-up directives
+// texting recipients deferred to their call hours are left out - their recipients belong to an enqueued chunk;
+// a planned directive is stored with an empty deferredfrom)
+func fetchEnqueuedChunks(psid string) ([]enqueueChunk, error) {
+	var (
+		err   error
+		drts  []ProcessDirective
+		chnks []enqueueChunk
+	)
+
+	err = mgoCollWorkerJobData.Find(bson.M{
+		"promosendid":  psid,
+		"deferredfrom": bson.M{"$in": []interface{}{"", nil}},
+	}).Sort("chunknumber", "enqueuetime").All(&drts)
+	if err != nil {
+		// error fetching job directives
+		return nil, fmt.Errorf("error fetching job directives for promotion send: %v; see: %v", psid, err)
+	}
+
+	for _, d := range drts {
+		chnks = append(chnks, enqueueChunk{
+			ChunkNumber:   d.ChunkNumber,
+			JobID:         d.JobID,
+			IDString:      d.IDString,
+			NumRecipients: len(d.Data),
+			ScheduledTime: d.ScheduledTime,
+			EnqueueTime:   d.EnqueueTime,
+			PushStatus:    d.PushStatus,
+			PushError:     d.PushError,
+		})
+	}
+
+	return chnks, nil
+}
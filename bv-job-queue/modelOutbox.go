@@ -0,0 +1,265 @@
+// modelOutbox.go implements an outbox between the job directives stored in workerjobdata and the jobs pushed to Faktory:
+// directives are stored as pending before their job is pushed, so a job that fails to push is pushed again (or flagged) later
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/danoand/utils"
+	mgo "github.com/globalsign/mgo"
+	bson "github.com/globalsign/mgo/bson"
+
+	fak "github.com/contribsys/faktory/client"
+
+	cmn "github.com/whopdan/wrkrcom"
+)
+
+// fakMutex serializes use of the (single connection) Faktory client by the web handlers and the outbox dispatcher
+var fakMutex sync.Mutex
+
+// fakPush pushes a job using the shared Faktory client
+func fakPush(job *fak.Job) error {
+	fakMutex.Lock()
+	defer fakMutex.Unlock()
+
+	return cmnWrkr.FakClient.Push(job)
+}
+
+// newSMSJob builds the Faktory job that processes a job directive
+func newSMSJob(drt ProcessDirective) *fak.Job {
+	job := fak.NewJob(cmn.CntWorkerNameSendSMSMessages, drt.PromoSendID, drt.IDString, drt.Environment) // Create a new job
+	if len(drt.JobID) != 0 {
+		// Re-use the job id recorded on the directive
+		job.Jid = drt.JobID
+	}
+	if drt.PushAt.After(time.Now()) {
+		// Delay the execution of the job on the queue
+		job.At = drt.PushAt.UTC().Format(time.RFC3339Nano)
+	}
+	job.ReserveFor = cfg.WorkerJobTimeoutSec     // Assign a timeout
+	job.Queue = cmn.CntWorkerNameSendSMSMessages // Post to the "bv-job-worker-smsmsgs" queue
+	job.Retry = -1                               // Failed jobs should are not retried and moved to the 'Dead' Faktory tab
+
+	return job
+}
+
+// claimPush atomically claims a pending job directive so only one process pushes its job
+func claimPush(id bson.ObjectId) (ProcessDirective, bool, error) {
+	var (
+		err error
+		drt ProcessDirective
+	)
+
+	_, err = mgoCollWorkerJobData.Find(bson.M{
+		"_id":        id,
+		"pushstatus": cntPushStatusPending,
+		"cancelled":  bson.M{"$ne": true},
+	}).Apply(
+		mgo.Change{
+			Update: bson.M{
+				"$set": bson.M{"pushstatus": cntPushStatusPushing, "pushclaimtime": time.Now().UTC()},
+				"$inc": bson.M{"pushattempts": 1},
+			},
+			ReturnNew: true,
+		},
+		&drt)
+	if err == mgo.ErrNotFound {
+		// directive has been claimed (or cancelled) since it was read
+		return drt, false, nil
+	}
+	if err != nil {
+		// error claiming the job directive
+		return drt, false, fmt.Errorf("error claiming job directive: %v for pushing; see: %v", id.Hex(), err)
+	}
+
+	return drt, true, nil
+}
+
+// pushDirective pushes the job of a claimed job directive and records the outcome; a directive whose
+// job fails to push is returned to the pending state
+func pushDirective(drt ProcessDirective) error {
+	var err, uerr error
+
+	err = fakPush(newSMSJob(drt))
+	if err != nil {
+		// error pushing a faktory job - leave the directive pending
+		uerr = mgoCollWorkerJobData.UpdateId(drt.ID, bson.M{"$set": bson.M{
+			"pushstatus": cntPushStatusPending,
+			"pusherror":  err.Error(),
+		}})
+		if uerr != nil {
+			appLog("ERROR: %v - error returning job directive: %v to the pending state. See: %v\n",
+				utils.FileLine(),
+				drt.IDString,
+				uerr)
+		}
+
+		return fmt.Errorf("error pushing a faktory job: %v (%v); see: %v",
+			drt.JobID,
+			cmn.CntWorkerNameSendSMSMessages,
+			err)
+	}
+
+	err = mgoCollWorkerJobData.UpdateId(drt.ID, bson.M{"$set": bson.M{
+		"pushstatus": cntPushStatusPushed,
+		"pushedtime": time.Now().UTC(),
+		"pusherror":  "",
+	}})
+	if err != nil {
+		// job pushed but not recorded - the reconciler flags the directive if it stays claimed
+		appLog("ERROR: %v - error recording job directive: %v as pushed. See: %v\n",
+			utils.FileLine(),
+			drt.IDString,
+			err)
+	}
+
+	return nil
+}
+
+// dispatchPending pushes the jobs of pending job directives; returns the number of jobs pushed
+func dispatchPending() (int, error) {
+	var (
+		err    error
+		cnt    int
+		claimd bool
+		drt    ProcessDirective
+		docs   []struct {
+			ID bson.ObjectId `bson:"_id"`
+		}
+	)
+
+	err = mgoCollWorkerJobData.Find(bson.M{
+		"pushstatus":   cntPushStatusPending,
+		"cancelled":    bson.M{"$ne": true},
+		"pushattempts": bson.M{"$lt": cfg.WorkerOutboxMaxTries},
+	}).Select(bson.M{"_id": 1}).Sort("pushat").Limit(cntOutboxBatchSize).All(&docs)
+	if err != nil {
+		// error fetching pending job directives
+		return 0, fmt.Errorf("error fetching pending job directives; see: %v", err)
+	}
+
+	for _, d := range docs {
+		drt, claimd, err = claimPush(d.ID)
+		if err != nil {
+			appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+			continue
+		}
+		if !claimd {
+			continue
+		}
+
+		err = pushDirective(drt)
+		if err != nil {
+			appLog("ERROR: %v - job directive: %v (attempt %v). See: %v\n",
+				utils.FileLine(),
+				drt.IDString,
+				drt.PushAttempts,
+				err)
+			continue
+		}
+
+		appLog("INFO: %v - outbox pushed job: %v for promotion send id: %v (attempt %v)\n",
+			utils.FileLine(),
+			drt.JobID,
+			drt.PromoSendID,
+			drt.PushAttempts)
+		cnt = cnt + 1
+	}
+
+	return cnt, nil
+}
+
+// runOutboxDispatcher pushes the jobs of pending job directives every few seconds (run as a goroutine)
+func runOutboxDispatcher() {
+	appLog("INFO: %v - start the outbox dispatcher\n", utils.FileLine())
+
+	for range time.Tick(time.Duration(cfg.WorkerOutboxPollSec) * time.Second) {
+		_, err := dispatchPending()
+		if err != nil {
+			appLog("ERROR: %v - error dispatching pending job directives. See: %v\n", utils.FileLine(), err)
+		}
+	}
+}
+
+// orphanDirectives flags job directives matching a query as orphaned and logs them to their promotion send's log
+func orphanDirectives(qry bson.M, reason string) int {
+	var (
+		err  error
+		cnt  int
+		drts []ProcessDirective
+	)
+
+	err = mgoCollWorkerJobData.Find(qry).All(&drts)
+	if err != nil {
+		// error fetching job directives
+		appLog("ERROR: %v - error fetching job directives to orphan. See: %v\n", utils.FileLine(), err)
+		return 0
+	}
+
+	for _, drt := range drts {
+		var logLines []string
+
+		// Flag the directive (unless its state changed since it was read)
+		err = mgoCollWorkerJobData.Update(
+			bson.M{"_id": drt.ID, "pushstatus": drt.PushStatus},
+			bson.M{"$set": bson.M{"pushstatus": cntPushStatusOrphaned, "pusherror": reason}})
+		if err != nil {
+			continue
+		}
+		cnt = cnt + 1
+
+		appLog("ERROR: %v - orphaned job directive: %v (job: %v) for promotion send id: %v - %v (last error: %v)\n",
+			utils.FileLine(),
+			drt.IDString,
+			drt.JobID,
+			drt.PromoSendID,
+			reason,
+			drt.PushError)
+		logLines = jobLog(logLines, "ERROR: job #%v (job id: %v) is orphaned - %v (last error: %v)",
+			drt.ChunkNumber,
+			drt.JobID,
+			reason,
+			drt.PushError)
+		wrtJobLog(drt.Environment, logLines, drt.PromoSendID, drt.JobID)
+	}
+
+	return cnt
+}
+
+// reconcileOutbox flags job directives whose job was never (or may not have been) pushed and re-pushes
+// pending directives the dispatcher has not pushed (run as a cron job)
+func reconcileOutbox() {
+	var (
+		err                 error
+		numStuck, numFailed int
+		numPushed           int
+	)
+
+	// Claimed but never recorded as pushed (e.g. the service stopped mid push) - the job may or may not exist
+	numStuck = orphanDirectives(bson.M{
+		"pushstatus":    cntPushStatusPushing,
+		"pushclaimtime": bson.M{"$lt": time.Now().UTC().Add(-time.Duration(cfg.WorkerOutboxStaleSec) * time.Second)},
+	}, "push outcome unknown - check Faktory before re-queuing")
+
+	// Failed to push too many times
+	numFailed = orphanDirectives(bson.M{
+		"pushstatus":   cntPushStatusPending,
+		"cancelled":    bson.M{"$ne": true},
+		"pushattempts": bson.M{"$gte": cfg.WorkerOutboxMaxTries},
+	}, fmt.Sprintf("job failed to push %v times", cfg.WorkerOutboxMaxTries))
+
+	// Re-push pending directives
+	numPushed, err = dispatchPending()
+	if err != nil {
+		appLog("ERROR: %v - error dispatching pending job directives. See: %v\n", utils.FileLine(), err)
+	}
+
+	appLog("INFO: %v - outbox reconciled: %v jobs re-pushed, %v directives orphaned (%v stuck pushing, %v failed pushing)\n",
+		utils.FileLine(),
+		numPushed,
+		numStuck+numFailed,
+		numStuck,
+		numFailed)
+}
@@ -0,0 +1,681 @@
+// modelOwnerQuota.go models the daily and monthly text message allowances of a promotion broadcast owner (a
+// billed business); allowances and the policy for a broadcast exceeding them come from the owner's plan (see
+// WorkerPlanDailyLimits/WorkerPlanMonthlyLimits/WorkerPlanQuotaPolicies) unless adjusted for the owner in the
+// ownerquotas collection. Enqueued recipients are reserved against the allowances with a guarded $inc on the
+// owner's ownerusage documents (one per day and month) so concurrent enqueues can't both pass the quota, and
+// recipients that are never texted are released once their broadcast completes
+package main
+
+import (
+	"fmt"
+	"time"
+
+	mgo "github.com/globalsign/mgo"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// ownerQuota models the allowance adjustments stored for an owner (ownerquotas document)
+type ownerQuota struct {
+	OwnerID      string    `bson:"ownerid" json:"ownerid"`           // owner (psendownerid) document id
+	Plan         string    `bson:"plan" json:"plan"`                 // billing plan (empty: WorkerQuotaDefaultPlan)
+	DailyLimit   int       `bson:"dailylimit" json:"dailylimit"`     // messages allowed per day (0: plan allowance, -1: unlimited)
+	MonthlyLimit int       `bson:"monthlylimit" json:"monthlylimit"` // messages allowed per month (0: plan allowance, -1: unlimited)
+	Policy       string    `bson:"quotapolicy" json:"quotapolicy"`   // policy for a broadcast exceeding the allowances (empty: plan policy)
+	Note         string    `bson:"note" json:"note"`                 // reason for the last adjustment
+	UpdatedBy    string    `bson:"updatedby" json:"updatedby"`       // caller (key id) that last adjusted the allowances
+	UpdatedTime  time.Time `bson:"updatedtime" json:"updatedtime"`   // time the allowances were last adjusted
+}
+
+// quotaUsage models an owner's allowances and the messages counted against them on a day and in its month
+// (today, or the day a scheduled broadcast starts sending)
+type quotaUsage struct {
+	OwnerID       string `json:"ownerid"`       // owner (psendownerid) document id
+	Plan          string `json:"plan"`          // billing plan
+	Day           string `json:"day"`           // day the usage is counted for (Pacific time): '2006-01-02'
+	Month         string `json:"month"`         // month the usage is counted for (Pacific time): '2006-01'
+	DailyLimit    int    `json:"dailylimit"`    // messages allowed per day (-1: unlimited)
+	MonthlyLimit  int    `json:"monthlylimit"`  // messages allowed per month (-1: unlimited)
+	SentToday     int    `json:"senttoday"`     // messages sent on the day
+	SentMonth     int    `json:"sentmonth"`     // messages sent in the month
+	QueuedToday   int    `json:"queuedtoday"`   // recipients of queued chunk jobs scheduled on the day that haven't finished
+	QueuedMonth   int    `json:"queuedmonth"`   // recipients of queued chunk jobs scheduled in the month that haven't finished
+	ReservedToday int    `json:"reservedtoday"` // recipients reserved against the day's allowance (-1: none reserved yet)
+	ReservedMonth int    `json:"reservedmonth"` // recipients reserved against the month's allowance (-1: none reserved yet)
+	DailyLeft     int    `json:"dailyleft"`     // messages left on the day (-1: unlimited)
+	MonthlyLeft   int    `json:"monthlyleft"`   // messages left in the month (-1: unlimited)
+}
+
+// quotaCheck models the outcome of checking a broadcast against its owner's allowances
+type quotaCheck struct {
+	Policy    string     `json:"policy"`    // 'reject' or 'partial'
+	Requested int        `json:"requested"` // recipients in the broadcast
+	Fits      int        `json:"fits"`      // recipients that fit within the allowances (-1: unlimited)
+	Dropped   int        `json:"dropped"`   // recipients removed to fit the allowances (partial policy)
+	Rejected  bool       `json:"rejected"`  // broadcast rejected for exceeding the allowances
+	Msg       string     `json:"msg"`       // message describing the outcome
+	Usage     quotaUsage `json:"usage"`     // owner's allowances and usage
+}
+
+// ownerUsage models the recipients reserved against an owner's allowance for a day or a month (ownerusage document)
+type ownerUsage struct {
+	OwnerID  string `bson:"ownerid" json:"ownerid"`   // owner (psendownerid) document id
+	Period   string `bson:"period" json:"period"`     // 'day:2006-01-02' or 'month:2006-01' (Pacific time)
+	Reserved int    `bson:"reserved" json:"reserved"` // recipients reserved by enqueued broadcasts (less those released)
+}
+
+// quotaReservation models recipients reserved against an owner's allowances (kept on the promotion broadcast
+// in psendquotares so the recipients it never texts can be released)
+type quotaReservation struct {
+	OwnerID string   `bson:"ownerid" json:"ownerid"` // owner (psendownerid) document id
+	Periods []string `bson:"periods" json:"periods"` // ownerusage periods the recipients are reserved against
+	Num     int      `bson:"num" json:"num"`         // recipients reserved
+}
+
+// ensureOwnerUsageIndexes creates the unique index on the ownerusage collection (one document per owner and period)
+func ensureOwnerUsageIndexes() error {
+	err := mgoCollOwnerUsage.EnsureIndex(mgo.Index{Key: []string{"ownerid", "period"}, Unique: true})
+	if err != nil {
+		// error creating the index
+		return fmt.Errorf("error creating the ownerusage index; see: %v", err)
+	}
+
+	return nil
+}
+
+// fetchOwnerQuota fetches the allowance adjustments of an owner (an owner without adjustments is on the default plan)
+func fetchOwnerQuota(ownerid string) (ownerQuota, error) {
+	var (
+		err error
+		qta ownerQuota
+	)
+
+	err = mgoCollOwnerQuotas.Find(bson.M{"ownerid": ownerid}).One(&qta)
+	if err != nil && err != mgo.ErrNotFound {
+		// error fetching the owner's quota
+		return qta, fmt.Errorf("error fetching the quota of owner: %v; see: %v", ownerid, err)
+	}
+	qta.OwnerID = ownerid
+	if len(qta.Plan) == 0 {
+		qta.Plan = cfg.WorkerQuotaDefaultPlan
+	}
+
+	return qta, nil
+}
+
+// limits returns the owner's daily and monthly allowances (-1: unlimited); an adjusted allowance overrides
+// the plan's, and a plan without an allowance is unlimited
+func (qta ownerQuota) limits() (int, int) {
+	var dly, mly = cfg.WorkerPlanDailyLimits[qta.Plan], cfg.WorkerPlanMonthlyLimits[qta.Plan]
+
+	if qta.DailyLimit != 0 {
+		dly = qta.DailyLimit
+	}
+	if qta.MonthlyLimit != 0 {
+		mly = qta.MonthlyLimit
+	}
+	if dly <= 0 {
+		dly = -1
+	}
+	if mly <= 0 {
+		mly = -1
+	}
+
+	return dly, mly
+}
+
+// policy returns the owner's policy for a broadcast exceeding the allowances; an adjusted policy overrides
+// the plan's, and a plan without a policy uses WorkerQuotaPolicy
+func (qta ownerQuota) policy() string {
+	switch {
+
+	// CASE: policy adjusted for the owner
+	case len(qta.Policy) != 0:
+		return qta.Policy
+
+	// CASE: plan has a policy
+	case len(cfg.WorkerPlanQuotaPolicies[qta.Plan]) != 0:
+		return cfg.WorkerPlanQuotaPolicies[qta.Plan]
+
+	// DEFAULT: default policy
+	default:
+		return cfg.WorkerQuotaPolicy
+	}
+}
+
+// saveOwnerQuota stores the allowance adjustments of an owner
+func saveOwnerQuota(qta ownerQuota) error {
+	_, err := mgoCollOwnerQuotas.Upsert(bson.M{"ownerid": qta.OwnerID}, qta)
+	if err != nil {
+		// error saving the owner's quota
+		return fmt.Errorf("error saving the quota of owner: %v; see: %v", qta.OwnerID, err)
+	}
+
+	return nil
+}
+
+// fetchPSendOwner fetches the owner document id of a promotion broadcast (empty: the broadcast has no owner)
+func fetchPSendOwner(psid string) (string, error) {
+	var (
+		err   error
+		psend struct {
+			OwnerID bson.ObjectId `bson:"psendownerid"`
+		}
+	)
+
+	if !bson.IsObjectIdHex(psid) {
+		// psid is not a valid document id
+		return "", fmt.Errorf("%v is not a valid document id", psid)
+	}
+
+	err = mgoCollPromoBroadcasts.FindId(bson.ObjectIdHex(psid)).Select(bson.M{"psendownerid": 1}).One(&psend)
+	if err != nil {
+		// error fetching the promotion broadcast
+		return "", fmt.Errorf("error fetching the owner of promotion broadcast: %v; see: %v", psid, err)
+	}
+	if !psend.OwnerID.Valid() {
+		// broadcast has no owner
+		return "", nil
+	}
+
+	return psend.OwnerID.Hex(), nil
+}
+
+// usagePeriods returns the ownerusage periods of the day and month (Pacific time) containing a time
+func usagePeriods(at time.Time) (string, string) {
+	at = at.In(timePT)
+
+	return "day:" + at.Format("2006-01-02"), "month:" + at.Format("2006-01")
+}
+
+// fetchReserved fetches the recipients reserved against an owner's allowance for a period (-1: none reserved yet)
+func fetchReserved(ownerid, period string) (int, error) {
+	var use ownerUsage
+
+	err := mgoCollOwnerUsage.Find(bson.M{"ownerid": ownerid, "period": period}).One(&use)
+	if err == mgo.ErrNotFound {
+		return -1, nil
+	}
+	if err != nil {
+		// error fetching the owner's usage
+		return 0, fmt.Errorf("error fetching the %v usage of owner: %v; see: %v", period, ownerid, err)
+	}
+
+	return use.Reserved, nil
+}
+
+// countQueued counts the recipients of the queued (or running) chunk jobs of promotion broadcasts scheduled
+// to run in a period
+func countQueued(hexIDs []string, from, to time.Time) (int, error) {
+	var queued []struct {
+		Num int `bson:"num"`
+	}
+
+	err := mgoCollWorkerJobData.Pipe([]bson.M{
+		{"$match": bson.M{
+			"promosendid":        bson.M{"$in": hexIDs},
+			"chunkresult.status": bson.M{"$in": []interface{}{"", nil}},
+			"cancelled":          bson.M{"$ne": true},
+			"pushstatus":         bson.M{"$ne": cntPushStatusOrphaned},
+			"pushat":             bson.M{"$gte": from.UTC(), "$lt": to.UTC()},
+		}},
+		{"$group": bson.M{"_id": nil, "num": bson.M{"$sum": bson.M{"$size": "$data"}}}},
+	}).All(&queued)
+	if err != nil {
+		// error counting the queued recipients
+		return 0, err
+	}
+	if len(queued) == 0 {
+		return 0, nil
+	}
+
+	return queued[0].Num, nil
+}
+
+// fetchQuotaUsage counts the messages sent (and queued) for an owner's promotion broadcasts on the day and in the
+// month (Pacific time) containing a time against the owner's allowances; the messages left come from the
+// recipients reserved for the day and month, or until the first reservation of a period from the messages sent
+// and queued
+func fetchQuotaUsage(ownerid string, at time.Time) (quotaUsage, error) {
+	var (
+		err      error
+		qta      ownerQuota
+		use      = quotaUsage{OwnerID: ownerid}
+		loc      = at.In(timePT)
+		day      = time.Date(loc.Year(), loc.Month(), loc.Day(), 0, 0, 0, 0, timePT)
+		month    = time.Date(loc.Year(), loc.Month(), 1, 0, 0, 0, 0, timePT)
+		dayP     string
+		monthP   string
+		usedDay  int
+		usedMnth int
+		oids     []bson.ObjectId
+		hexIDs   []string
+		psends   []struct {
+			ID bson.ObjectId `bson:"_id"`
+		}
+	)
+
+	if !bson.IsObjectIdHex(ownerid) {
+		// ownerid is not a valid document id
+		return use, fmt.Errorf("%v is not a valid owner id", ownerid)
+	}
+
+	qta, err = fetchOwnerQuota(ownerid)
+	if err != nil {
+		return use, err
+	}
+	use.Plan = qta.Plan
+	use.Day, use.Month = day.Format("2006-01-02"), month.Format("2006-01")
+	use.DailyLimit, use.MonthlyLimit = qta.limits()
+
+	// Fetch the owner's promotion broadcasts
+	err = mgoCollPromoBroadcasts.Find(bson.M{"psendownerid": bson.ObjectIdHex(ownerid)}).Select(bson.M{"_id": 1}).All(&psends)
+	if err != nil {
+		// error fetching the owner's promotion broadcasts
+		return use, fmt.Errorf("error fetching the promotion broadcasts of owner: %v; see: %v", ownerid, err)
+	}
+	for _, p := range psends {
+		oids = append(oids, p.ID)
+		hexIDs = append(hexIDs, p.ID.Hex())
+	}
+
+	// Count the messages sent in the month and on the day
+	use.SentMonth, err = mgoCollSMSMessages.Find(bson.M{
+		"promotionsendid": bson.M{"$in": oids},
+		"twiliostatus":    201,
+		"time":            bson.M{"$gte": month.UTC(), "$lt": month.AddDate(0, 1, 0).UTC()},
+	}).Count()
+	if err != nil {
+		// error counting the messages sent in the month
+		return use, fmt.Errorf("error counting the messages sent by owner: %v in %v; see: %v", ownerid, use.Month, err)
+	}
+	use.SentToday, err = mgoCollSMSMessages.Find(bson.M{
+		"promotionsendid": bson.M{"$in": oids},
+		"twiliostatus":    201,
+		"time":            bson.M{"$gte": day.UTC(), "$lt": day.AddDate(0, 0, 1).UTC()},
+	}).Count()
+	if err != nil {
+		// error counting the messages sent on the day
+		return use, fmt.Errorf("error counting the messages sent by owner: %v on %v; see: %v", ownerid, use.Day, err)
+	}
+
+	// Count the recipients of chunk jobs that are queued (or running) - they count against the allowances too
+	//   (conservatively: a running job's messages already sent are counted again until the job finishes)
+	use.QueuedMonth, err = countQueued(hexIDs, month, month.AddDate(0, 1, 0))
+	if err == nil {
+		use.QueuedToday, err = countQueued(hexIDs, day, day.AddDate(0, 0, 1))
+	}
+	if err != nil {
+		return use, fmt.Errorf("error counting the queued recipients of owner: %v; see: %v", ownerid, err)
+	}
+
+	// Fetch the recipients reserved on the day and in the month
+	dayP, monthP = usagePeriods(at)
+	use.ReservedToday, err = fetchReserved(ownerid, dayP)
+	if err == nil {
+		use.ReservedMonth, err = fetchReserved(ownerid, monthP)
+	}
+	if err != nil {
+		return use, err
+	}
+
+	usedDay, usedMnth = use.SentToday+use.QueuedToday, use.SentMonth+use.QueuedMonth
+	if use.ReservedToday >= 0 {
+		usedDay = use.ReservedToday
+	}
+	if use.ReservedMonth >= 0 {
+		usedMnth = use.ReservedMonth
+	}
+	use.DailyLeft = quotaLeft(use.DailyLimit, usedDay)
+	use.MonthlyLeft = quotaLeft(use.MonthlyLimit, usedMnth)
+
+	return use, nil
+}
+
+// quotaLeft returns the messages left of an allowance (-1: unlimited)
+func quotaLeft(limit, used int) int {
+	if limit < 0 {
+		return -1
+	}
+	if used >= limit {
+		return 0
+	}
+
+	return limit - used
+}
+
+// fits returns the number of recipients that fit within the owner's allowances (-1: unlimited)
+func (use quotaUsage) fits() int {
+	switch {
+
+	// CASE: no daily allowance
+	case use.DailyLeft < 0:
+		return use.MonthlyLeft
+
+	// CASE: no monthly allowance
+	case use.MonthlyLeft < 0:
+		return use.DailyLeft
+
+	// CASE: daily allowance is the tighter one
+	case use.DailyLeft < use.MonthlyLeft:
+		return use.DailyLeft
+
+	// DEFAULT: monthly allowance is the tighter one
+	default:
+		return use.MonthlyLeft
+	}
+}
+
+// decide sets the outcome of a check of a number of recipients against the recipients that fit the owner's
+// allowances; a broadcast that doesn't fit is rejected, or under the partial policy trimmed to the recipients that fit
+func (chk *quotaCheck) decide(ownerid string) {
+	switch {
+
+	// CASE: every recipient fits
+	case chk.Fits < 0 || chk.Requested <= chk.Fits:
+		chk.Msg = fmt.Sprintf("all %v recipients fit the sending quota", chk.Requested)
+
+	// CASE: no recipient fits or the policy rejects the broadcast
+	case chk.Fits == 0 || chk.Policy == cntQuotaPolicyReject:
+		chk.Rejected = true
+		chk.Msg = fmt.Sprintf("broadcast of %v recipients exceeds the sending quota of owner: %v (plan: %v) - %v recipients fit (%v left on %v, %v left in %v)",
+			chk.Requested,
+			ownerid,
+			chk.Usage.Plan,
+			chk.Fits,
+			chk.Usage.DailyLeft,
+			chk.Usage.Day,
+			chk.Usage.MonthlyLeft,
+			chk.Usage.Month)
+
+	// DEFAULT: partial policy - queue the recipients that fit
+	default:
+		chk.Dropped = chk.Requested - chk.Fits
+		chk.Msg = fmt.Sprintf("only %v of %v recipients fit the sending quota of owner: %v (plan: %v) - %v recipients not queued",
+			chk.Fits,
+			chk.Requested,
+			ownerid,
+			chk.Usage.Plan,
+			chk.Dropped)
+	}
+}
+
+// startQuotaCheck fetches the owner, allowances and policy of a promotion broadcast; returns the owner's quota
+// and true if the check is complete: no allowance applies, or the broadcast has no owner to check (rejected)
+func startQuotaCheck(psid string, chk *quotaCheck) (ownerQuota, bool, error) {
+	var (
+		err     error
+		ownerid string
+		qta     = ownerQuota{Plan: cfg.WorkerQuotaDefaultPlan}
+	)
+
+	ownerid, err = fetchPSendOwner(psid)
+	if err != nil {
+		return qta, true, err
+	}
+	if len(ownerid) != 0 {
+		qta, err = fetchOwnerQuota(ownerid)
+		if err != nil {
+			return qta, true, err
+		}
+	}
+
+	chk.Policy = qta.policy()
+	if chk.Policy != cntQuotaPolicyReject && chk.Policy != cntQuotaPolicyPartial {
+		// invalid quota policy
+		return qta, true, fmt.Errorf("invalid quota policy of plan: %v: %v (expecting '%v' or '%v')", qta.Plan, chk.Policy, cntQuotaPolicyReject, cntQuotaPolicyPartial)
+	}
+
+	switch dly, mly := qta.limits(); {
+
+	// CASE: no allowance applies - skip the check
+	case dly < 0 && mly < 0:
+		chk.Usage = quotaUsage{OwnerID: ownerid, Plan: qta.Plan, DailyLimit: -1, MonthlyLimit: -1, ReservedToday: -1, ReservedMonth: -1, DailyLeft: -1, MonthlyLeft: -1}
+		chk.Msg = fmt.Sprintf("no sending quota applies (plan: %v) - all %v recipients fit", qta.Plan, chk.Requested)
+		return qta, true, nil
+
+	// CASE: broadcast has no owner to check its allowances against
+	case len(ownerid) == 0:
+		chk.Rejected = true
+		chk.Fits = 0
+		chk.Msg = fmt.Sprintf("promotion broadcast: %v has no owner (psendownerid) - its sending quota (plan: %v) can't be checked", psid, qta.Plan)
+		return qta, true, nil
+	}
+
+	return qta, false, nil
+}
+
+// checkQuota checks a number of recipients of an owner's promotion broadcast starting to send at a time against
+// the owner's allowances for the day and month of that time without reserving them (see reserveQuota)
+func checkQuota(psid string, numRcpts int, at time.Time) (quotaCheck, error) {
+	var (
+		err  error
+		qta  ownerQuota
+		done bool
+		chk  = quotaCheck{Requested: numRcpts, Fits: -1}
+	)
+
+	qta, done, err = startQuotaCheck(psid, &chk)
+	if err != nil || done {
+		return chk, err
+	}
+
+	chk.Usage, err = fetchQuotaUsage(qta.OwnerID, at)
+	if err != nil {
+		return chk, err
+	}
+	chk.Fits = chk.Usage.fits()
+	chk.decide(qta.OwnerID)
+
+	return chk, nil
+}
+
+// openUsagePeriod creates an owner's ownerusage document for a period if it doesn't exist, starting it at the
+// messages counted by fetchQuotaUsage (sent and queued in the period); returns the recipients reserved
+func openUsagePeriod(ownerid, period string, used int) (int, error) {
+	var (
+		err error
+		use ownerUsage
+	)
+
+	_, err = mgoCollOwnerUsage.Find(bson.M{"ownerid": ownerid, "period": period}).Apply(
+		mgo.Change{
+			Update:    bson.M{"$setOnInsert": ownerUsage{OwnerID: ownerid, Period: period, Reserved: used}},
+			Upsert:    true,
+			ReturnNew: true,
+		},
+		&use)
+	if mgo.IsDup(err) {
+		// created by a concurrent enqueue
+		return fetchReserved(ownerid, period)
+	}
+	if err != nil {
+		// error creating the owner's usage document
+		return 0, fmt.Errorf("error opening the %v usage of owner: %v; see: %v", period, ownerid, err)
+	}
+
+	return use.Reserved, nil
+}
+
+// reserveQuota reserves a number of recipients of an owner's promotion broadcast starting to send at a time
+// against the owner's allowances for the day and month of that time: each allowance's ownerusage document is
+// incremented only while the reserved recipients stay within the allowance, so of two concurrent enqueues only
+// those that fit pass; a broadcast that doesn't fit is rejected, or under the partial policy the recipients that
+// fit are reserved (Fits)
+func reserveQuota(psid string, numRcpts int, at time.Time) (quotaCheck, quotaReservation, error) {
+	var (
+		err  error
+		qta  ownerQuota
+		done bool
+		chk  = quotaCheck{Requested: numRcpts, Fits: -1}
+		res  quotaReservation
+	)
+
+	qta, done, err = startQuotaCheck(psid, &chk)
+	if err != nil || done {
+		return chk, res, err
+	}
+	res.OwnerID = qta.OwnerID
+
+	for try := 1; try <= cntQuotaReserveMaxTries; try++ {
+		var (
+			lmts    = make(map[string]int)
+			dayP    string
+			monthP  string
+			dly     int
+			mly     int
+			contend bool
+		)
+
+		chk.Rejected, chk.Dropped = false, 0
+		chk.Usage, err = fetchQuotaUsage(qta.OwnerID, at)
+		if err != nil {
+			return chk, res, err
+		}
+
+		// Open the usage documents of the limited allowances and work out the recipients that fit
+		dayP, monthP = usagePeriods(at)
+		dly, mly = chk.Usage.DailyLimit, chk.Usage.MonthlyLimit
+		if dly >= 0 {
+			lmts[dayP] = dly
+			chk.Usage.ReservedToday, err = openUsagePeriod(qta.OwnerID, dayP, chk.Usage.SentToday+chk.Usage.QueuedToday)
+			if err != nil {
+				return chk, res, err
+			}
+			chk.Usage.DailyLeft = quotaLeft(dly, chk.Usage.ReservedToday)
+		}
+		if mly >= 0 {
+			lmts[monthP] = mly
+			chk.Usage.ReservedMonth, err = openUsagePeriod(qta.OwnerID, monthP, chk.Usage.SentMonth+chk.Usage.QueuedMonth)
+			if err != nil {
+				return chk, res, err
+			}
+			chk.Usage.MonthlyLeft = quotaLeft(mly, chk.Usage.ReservedMonth)
+		}
+		chk.Fits = chk.Usage.fits()
+		chk.decide(qta.OwnerID)
+		if chk.Rejected {
+			return chk, res, nil
+		}
+		res.Num = numRcpts - chk.Dropped
+
+		// Reserve the recipients against each allowance - unless a concurrent enqueue reserved them first
+		res.Periods = nil
+		for prd, lmt := range lmts {
+			err = mgoCollOwnerUsage.Update(
+				bson.M{"ownerid": qta.OwnerID, "period": prd, "reserved": bson.M{"$lte": lmt - res.Num}},
+				bson.M{"$inc": bson.M{"reserved": res.Num}})
+			if err == mgo.ErrNotFound {
+				// allowance taken by a concurrent enqueue
+				contend = true
+				break
+			}
+			if err != nil {
+				// error reserving the recipients
+				err = fmt.Errorf("error reserving %v recipients against the %v usage of owner: %v; see: %v", res.Num, prd, qta.OwnerID, err)
+				break
+			}
+			res.Periods = append(res.Periods, prd)
+		}
+		if !contend && err == nil {
+			if chk.Fits >= 0 {
+				chk.Fits = res.Num
+			}
+			return chk, res, nil
+		}
+
+		// Undo the reservations made before the contended (or failed) one
+		rerr := res.release()
+		if err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return chk, res, err
+		}
+	}
+
+	return chk, res, fmt.Errorf("sending quota of owner: %v contended by concurrent enqueues - try again", qta.OwnerID)
+}
+
+// release returns the recipients left in a reservation to the owner's allowances
+func (res *quotaReservation) release() error {
+	if res.Num <= 0 {
+		res.Num, res.Periods = 0, nil
+		return nil
+	}
+
+	for _, prd := range res.Periods {
+		err := mgoCollOwnerUsage.Update(
+			bson.M{"ownerid": res.OwnerID, "period": prd},
+			bson.M{"$inc": bson.M{"reserved": -res.Num}})
+		if err != nil {
+			// error releasing the recipients
+			return fmt.Errorf("error releasing %v recipients from the %v usage of owner: %v; see: %v", res.Num, prd, res.OwnerID, err)
+		}
+	}
+	res.Num, res.Periods = 0, nil
+
+	return nil
+}
+
+// take hands a number of a reservation's recipients (those of the jobs pushed for a promotion broadcast) over
+// to the broadcast, recording them on it so the recipients it never texts are released once it completes
+func (res *quotaReservation) take(psid string, num int) error {
+	if num > res.Num {
+		num = res.Num
+	}
+	if num <= 0 || !bson.IsObjectIdHex(psid) {
+		return nil
+	}
+
+	err := mgoCollPromoBroadcasts.UpdateId(
+		bson.ObjectIdHex(psid),
+		bson.M{"$push": bson.M{"psendquotares": quotaReservation{OwnerID: res.OwnerID, Periods: res.Periods, Num: num}}})
+	if err != nil {
+		// error recording the reservation
+		return fmt.Errorf("error recording the quota reservation of promotion broadcast: %v; see: %v", psid, err)
+	}
+	res.Num = res.Num - num
+
+	return nil
+}
+
+// releaseUnsent returns the reserved recipients a completed promotion broadcast didn't text (failed, skipped,
+// stop listed, halted or cancelled) to its owner's allowances
+func releaseUnsent(id bson.ObjectId, sent int) error {
+	var (
+		err   error
+		psend struct {
+			Reservations []quotaReservation `bson:"psendquotares"`
+		}
+	)
+
+	err = mgoCollPromoBroadcasts.FindId(id).Select(bson.M{"psendquotares": 1}).One(&psend)
+	if err != nil {
+		// error fetching the broadcast's reservations
+		return fmt.Errorf("error fetching the quota reservations of promotion broadcast: %v; see: %v", id.Hex(), err)
+	}
+
+	// Keep the sent messages reserved (earliest reservations first) and release the rest
+	for i := range psend.Reservations {
+		res := &psend.Reservations[i]
+		if sent >= res.Num {
+			sent = sent - res.Num
+			continue
+		}
+		res.Num = res.Num - sent
+		sent = 0
+
+		err = res.release()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mgoCollPromoBroadcasts.UpdateId(id, bson.M{"$unset": bson.M{"psendquotares": ""}})
+	if err != nil {
+		// error clearing the broadcast's reservations
+		return fmt.Errorf("error clearing the quota reservations of promotion broadcast: %v; see: %v", id.Hex(), err)
+	}
+
+	return nil
+}
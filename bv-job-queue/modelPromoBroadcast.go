@@ -0,0 +1,88 @@
+// modelPromoBroadcast.go contains functions that update promotion broadcast (promobroadcasts) documents
+package main
+
+import (
+	"fmt"
+	"time"
+
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// setPSendScheduled records the time a scheduled promotion broadcast starts sending
+func setPSendScheduled(id string, at time.Time) error {
+	var err error
+
+	if !bson.IsObjectIdHex(id) {
+		// id is not a valid document id
+		return fmt.Errorf("%v is not a valid document id", id)
+	}
+
+	err = mgoCollPromoBroadcasts.UpdateId(
+		bson.ObjectIdHex(id),
+		bson.M{"$set": bson.M{
+			"psendscheduledtime":     at.UTC(),
+			"psenddispscheduledtime": hlprDispDateTime(at),
+			"psendscheduledtz":       at.Location().String(),
+		}},
+	)
+	if err != nil {
+		// error recording the scheduled send time
+		return fmt.Errorf("error recording the scheduled send time: %v", err)
+	}
+
+	return nil
+}
+
+// setPSendHalted flags a promotion broadcast as halted
+func setPSendHalted(id, msg string) error {
+	var err error
+
+	if !bson.IsObjectIdHex(id) {
+		// id is not a valid document id
+		return fmt.Errorf("%v is not a valid document id", id)
+	}
+
+	// Set the isHalted flag and a reason code (message)
+	err = mgoCollPromoBroadcasts.UpdateId(
+		bson.ObjectIdHex(id),
+		bson.M{"$set": bson.M{"ishalted": true, "psendhaltedmsg": msg}},
+	)
+	if err != nil {
+		// error flagging the promotion as halted
+		return fmt.Errorf("error flagging the promotion as halted: %v", err)
+	}
+
+	return nil
+}
+
+// fetchTagBroadcasts fetches the document ids of the promotion broadcasts linked by a tag id (created
+// together for several promotions) in the order they were created
+func fetchTagBroadcasts(tagid string) ([]string, error) {
+	var (
+		err  error
+		ids  []string
+		docs []struct {
+			ID bson.ObjectId `bson:"_id"`
+		}
+	)
+
+	if !bson.IsObjectIdHex(tagid) {
+		// tagid is not a valid document id
+		return nil, fmt.Errorf("%v is not a valid tag id", tagid)
+	}
+
+	err = mgoCollPromoBroadcasts.Find(bson.M{"psendtagid": bson.ObjectIdHex(tagid), "isdeleted": bson.M{"$ne": true}}).
+		Select(bson.M{"_id": 1}).
+		Sort("psendcreatetime", "_id").
+		All(&docs)
+	if err != nil {
+		// error fetching the tagged broadcasts
+		return nil, fmt.Errorf("error fetching the promotion broadcasts with tag id: %v; see: %v", tagid, err)
+	}
+
+	for _, d := range docs {
+		ids = append(ids, d.ID.Hex())
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,111 @@
+// modelSendSchedule.go models how a broadcast's recipients are split into chunk jobs and when those jobs run
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// sendSchedule models the chunk sizes and job timing used to send a broadcast
+type sendSchedule struct {
+	MsgsPerSec    float64       // target send rate for the broadcast (0: fixed threshold/delay schedule)
+	ChunkSize     int           // maximum number of recipients per chunk job
+	Lanes         int           // number of chunk jobs sending at the same time
+	MsgInterval   time.Duration // time between messages sent by a single chunk job (0: worker default)
+	ChunkInterval time.Duration // time between the start of consecutive chunk jobs in a lane
+}
+
+// newSendSchedule computes a send schedule for a number of recipients at a target messages per second;
+// a rate of zero (or less) uses the fixed WorkerMsgThreshold/WorkerJobDelaySec schedule
+func newSendSchedule(rate float64, numRcpts int) sendSchedule {
+	var (
+		sched    sendSchedule
+		laneRate float64
+	)
+
+	// Fixed schedule: threshold sized chunks, one after another, a fixed delay apart
+	if rate <= 0 || cfg.WorkerJobMsgsPerSec <= 0 {
+		sched.ChunkSize = cfg.WorkerMsgThreshold
+		sched.Lanes = 1
+		sched.ChunkInterval = time.Duration(cfg.WorkerJobDelaySec) * time.Second
+		return sched
+	}
+	sched.MsgsPerSec = rate
+
+	// Run as many chunk jobs side by side as the target rate allows (a single job sends
+	// at most WorkerJobMsgsPerSec) without exceeding the worker's concurrency
+	sched.Lanes = int(math.Floor(rate / cfg.WorkerJobMsgsPerSec))
+	if sched.Lanes < 1 {
+		sched.Lanes = 1
+	}
+	if sched.Lanes > cfg.WorkerSMSConcurrency {
+		sched.Lanes = cfg.WorkerSMSConcurrency
+	}
+
+	// Pace each job so the lanes together send at (not over) the target rate
+	laneRate = math.Min(rate/float64(sched.Lanes), cfg.WorkerJobMsgsPerSec)
+	sched.MsgInterval = time.Duration(float64(time.Second) / laneRate)
+
+	// Spread the recipients evenly across the lanes (capped at the threshold)
+	sched.ChunkSize = cfg.WorkerMsgThreshold
+	if numRcpts > 0 {
+		sched.ChunkSize = int(math.Min(
+			float64(cfg.WorkerMsgThreshold),
+			math.Ceil(float64(numRcpts)/float64(sched.Lanes))))
+	}
+
+	if sched.ChunkSize < 1 {
+		sched.ChunkSize = 1
+	}
+
+	// A lane's next chunk starts once its current chunk has had time to finish
+	sched.ChunkInterval = time.Duration(sched.ChunkSize) * sched.MsgInterval
+
+	return sched
+}
+
+// chunk groups recipients into chunks and assigns each chunk its start time
+func (sched sendSchedule) chunk(rcpts []map[string]string, start time.Time) []chunkPlan {
+	return sched.chunkAt(rcpts, start, 0, 1)
+}
+
+// chunkAt groups recipients into chunks numbered from num whose start times follow slot chunks
+// already scheduled (used to lay several broadcasts out on one schedule)
+func (sched sendSchedule) chunkAt(rcpts []map[string]string, start time.Time, slot, num int) []chunkPlan {
+	var plan []chunkPlan
+
+	for i := 0; i*sched.ChunkSize < len(rcpts); i++ {
+		end := int(math.Min(float64((i+1)*sched.ChunkSize), float64(len(rcpts))))
+
+		// Chunks fill the lanes round by round
+		plan = append(plan, chunkPlan{
+			Number: num + i,
+			At:     start.Add(time.Duration((slot+i)/sched.Lanes) * sched.ChunkInterval),
+			Data:   rcpts[i*sched.ChunkSize : end],
+		})
+	}
+
+	return plan
+}
+
+// finishTime estimates when the last chunk of a plan finishes sending
+func (sched sendSchedule) finishTime(plan []chunkPlan) time.Time {
+	var (
+		fin time.Time
+		dur = sched.MsgInterval
+	)
+
+	// Fixed schedule: jobs send at the worker's own pace
+	if dur == 0 && cfg.WorkerJobMsgsPerSec > 0 {
+		dur = time.Duration(float64(time.Second) / cfg.WorkerJobMsgsPerSec)
+	}
+
+	for _, chk := range plan {
+		end := chk.At.Add(time.Duration(len(chk.Data)) * dur)
+		if end.After(fin) {
+			fin = end
+		}
+	}
+
+	return fin
+}
@@ -0,0 +1,35 @@
+// msgtemplate.go validates and previews the text message of a promotion broadcast as bv-job-worker-smsmsgs renders
+// it (see github.com/whopdan/bv-common/msgtemplate), with a sample shortlink in place of a generated one
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/whopdan/bv-common/msgtemplate"
+)
+
+// previewVars returns the values a message template can reference for a recipient, with the sample shortlink
+// (WorkerShortLinkPreview: a generated shortlink's length) in place of the text's shortlink
+func previewVars(drtv ProcessDirective, rcp map[string]string) map[string]string {
+	return msgtemplate.Vars(rcp, cfg.WorkerShortLinkPreview, path.Base(cfg.WorkerShortLinkPreview), drtv.OwnerName, drtv.PromoExpiry)
+}
+
+// validateMsgTemplate validates the text message of a job directive by rendering it for every recipient (with a
+// sample shortlink) so a broken template is rejected before any text is sent
+func validateMsgTemplate(drtv ProcessDirective) error {
+	mt, err := msgtemplate.Parse(drtv.Message, drtv.IsXPathwayGenericPromo)
+	if err != nil {
+		return err
+	}
+
+	for i, rcp := range drtv.Data {
+		_, err = mt.Render(previewVars(drtv, rcp))
+		if err != nil {
+			// template can't be rendered for the recipient
+			return fmt.Errorf("data row #%v: %v", i, err)
+		}
+	}
+
+	return nil
+}
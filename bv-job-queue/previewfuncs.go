@@ -0,0 +1,193 @@
+// previewfuncs.go contains code to preview (dry run) a promotion broadcast without queuing any jobs
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	bson "github.com/globalsign/mgo/bson"
+	"github.com/whopdan/bv-common/msgtemplate"
+)
+
+// previewResponse models the response returned to a request to preview a promotion broadcast
+type previewResponse struct {
+	Status          string              `json:"status"`          // outcome of the request: 'preview', 'rejected'
+	Msg             string              `json:"msg"`             // message describing the outcome
+	PromoSendID     string              `json:"promosenddocid"`  // document id of the broadcast being previewed
+	EnqueueDocID    string              `json:"enqueuedocid"`    // jobqueueenqueues document id (idstring)
+	SendAt          string              `json:"sendat"`          // time the broadcast would start sending (empty: immediately)
+	MsgsPerSec      float64             `json:"msgspersec"`      // target send rate used to schedule the chunks (0: fixed schedule)
+	EstFinishTime   string              `json:"estfinishtime"`   // estimated time the last chunk would finish sending
+	NumRecipients   int                 `json:"numrecipients"`   // number of recipients that would be queued
+	NumChunks       int                 `json:"numchunks"`       // number of jobs that would be pushed
+	NumStopListed   int                 `json:"numstoplisted"`   // number of recipients on the stop list (skipped by the worker)
+	FirstMessage    string              `json:"firstmessage"`    // message text rendered for the first recipient
+	AlreadyEnqueued bool                `json:"alreadyenqueued"` // broadcast already has queued jobs (an enqueue would return them)
+	Chunks          []previewChunk      `json:"chunks"`          // per chunk schedule
+	SkippedRows     []enqueueSkippedRow `json:"skippedrows"`     // data rows that would not be queued
+	StopListed      []string            `json:"stoplisted"`      // phone numbers on the stop list
+	Quota           *quotaCheck         `json:"quota"`           // outcome of checking the broadcast against its owner's sending quota
+	Segments        *segmentEstimate    `json:"segments"`        // estimated segments and cost of the broadcast's messages
+}
+
+// previewChunk models a chunk job that would be queued for a promotion broadcast
+type previewChunk struct {
+	ChunkNumber   int    `json:"chunknumber"`   // sequence number of the chunk within the broadcast
+	NumRecipients int    `json:"numrecipients"` // number of recipients in the chunk
+	ScheduledTime string `json:"scheduledtime"` // time the job would be scheduled to run
+}
+
+// fetchStopListed returns the recipient phone numbers that are on the stop list
+func fetchStopListed(plan []chunkPlan) ([]string, error) {
+	var (
+		err  error
+		phns []string
+		docs []struct {
+			Phone string `bson:"phonetwilioformat"`
+		}
+		stop []string
+	)
+
+	for _, chk := range plan {
+		for _, rcp := range chk.Data {
+			phns = append(phns, fmt.Sprintf("+1%v", rcp["phonenumber"]))
+		}
+	}
+
+	err = mgoCollStopPhoneList.Find(bson.M{
+		"phonetwilioformat": bson.M{"$in": phns},
+		"stopmessages":      true,
+	}).Select(bson.M{"phonetwilioformat": 1}).All(&docs)
+	if err != nil {
+		// error querying the stop list
+		return nil, fmt.Errorf("error querying the stop phone list; see: %v", err)
+	}
+
+	for _, d := range docs {
+		stop = append(stop, d.Phone)
+	}
+
+	return stop, nil
+}
+
+// renderMessage renders the text message a recipient would receive (as bv-job-worker-smsmsgs does)
+// using a sample shortlink in place of the generated one
+func renderMessage(drtv ProcessDirective, rcp map[string]string) (string, error) {
+	mt, err := msgtemplate.Parse(drtv.Message, drtv.IsXPathwayGenericPromo)
+	if err != nil {
+		return "", err
+	}
+
+	return mt.Render(previewVars(drtv, rcp))
+}
+
+// hdlPreviewSMSJob handles inbound requests to preview (dry run) a promotion broadcast; the request
+// is validated and chunked exactly as an enqueue request but no jobs are pushed and nothing is written
+func hdlPreviewSMSJob(c *gin.Context) {
+	var (
+		err   error
+		plan  []chunkPlan
+		qchk  quotaCheck
+		sched sendSchedule
+		chnks []enqueueChunk
+		req   enqueueRequest
+		rsp   previewResponse
+	)
+
+	// Read and validate the request
+	req, err = readEnqueueRequest(c)
+	rsp.EnqueueDocID = req.DocID
+	rsp.PromoSendID = req.Drtv.PromoSendID
+	if err != nil {
+		// invalid preview request
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+	if len(req.SendAt) != 0 {
+		rsp.SendAt = req.Start.Format(time.RFC3339)
+	}
+
+	// Has this promotion send already been queued?
+	chnks, err = fetchEnqueuedChunks(req.Drtv.PromoSendID)
+	if err != nil {
+		// error checking for existing job directives
+		appLog("ERROR: %v - error checking for existing job directives. See: %v\n", utils.FileLine(), err)
+		rsp.Status = cntEnqueueResultFailed
+		rsp.Msg = fmt.Sprintf("error checking for existing job directives; see: %v", err)
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+	rsp.AlreadyEnqueued = len(chnks) != 0
+
+	// Validate the data rows and break them up into chunks
+	plan, rsp.SkippedRows, sched = buildChunkPlan(req.Drtv.Data, req.Start, req.Rate)
+	rsp.MsgsPerSec = sched.MsgsPerSec
+	rsp.NumChunks = len(plan)
+	for _, chk := range plan {
+		rsp.NumRecipients = rsp.NumRecipients + len(chk.Data)
+		rsp.Chunks = append(rsp.Chunks, previewChunk{
+			ChunkNumber:   chk.Number,
+			NumRecipients: len(chk.Data),
+			ScheduledTime: chk.At.In(timePT).Format(time.RFC3339Nano),
+		})
+	}
+
+	if len(plan) == 0 {
+		// no valid data rows - nothing would be queued
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = "no valid end customer data rows - nothing to enqueue"
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+	rsp.EstFinishTime = sched.finishTime(plan).In(timePT).Format(time.RFC3339)
+	rsp.FirstMessage, err = renderMessage(req.Drtv, plan[0].Data[0])
+	if err != nil {
+		// message can't be rendered (validated when the request was read)
+		rsp.FirstMessage = err.Error()
+	}
+
+	// Estimate the segments and cost of the messages
+	est := estimateSegments(req.Drtv, planRecipients(plan))
+	rsp.Segments = &est
+
+	// Which recipients are on the stop list?
+	rsp.StopListed, err = fetchStopListed(plan)
+	if err != nil {
+		// error querying the stop list - report the rest of the preview
+		appLog("ERROR: %v - error querying the stop list for psend: %v. See: %v\n", utils.FileLine(), req.Drtv.PromoSendID, err)
+	}
+	rsp.NumStopListed = len(rsp.StopListed)
+
+	rsp.Status = cntEnqueueResultPreview
+	rsp.Msg = fmt.Sprintf("would enqueue %v jobs sending %v messages (%v data rows skipped, %v recipients stop listed)",
+		rsp.NumChunks,
+		rsp.NumRecipients,
+		len(rsp.SkippedRows),
+		rsp.NumStopListed)
+	if err != nil {
+		rsp.Msg = fmt.Sprintf("%v; stop list unavailable: %v", rsp.Msg, err)
+	}
+	if len(est.Warning) != 0 {
+		rsp.Msg = fmt.Sprintf("%v; %v (segment policy: %v)", rsp.Msg, est.Warning, cfg.WorkerSegmentPolicy)
+	}
+
+	// Would the broadcast fit its owner's sending quota?
+	qchk, err = checkQuota(req.Drtv.PromoSendID, rsp.NumRecipients, req.Start)
+	if err != nil {
+		// error checking the sending quota - report the rest of the preview
+		appLog("ERROR: %v - error checking the sending quota of psend: %v. See: %v\n", utils.FileLine(), req.Drtv.PromoSendID, err)
+		rsp.Msg = fmt.Sprintf("%v; sending quota unavailable: %v", rsp.Msg, err)
+	} else {
+		rsp.Quota = &qchk
+		if qchk.Rejected || qchk.Dropped != 0 {
+			rsp.Msg = fmt.Sprintf("%v; %v", rsp.Msg, qchk.Msg)
+		}
+	}
+
+	c.JSON(http.StatusOK, rsp)
+}
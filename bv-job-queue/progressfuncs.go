@@ -0,0 +1,254 @@
+// progressfuncs.go contains code that reports the progress of a promotion broadcast: the state of its chunk jobs,
+// the messages sent so far, its halt state, and its job log
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// progressResponse models the response returned to a request for the progress of a promotion broadcast
+type progressResponse struct {
+	Status      string          `json:"status"`         // outcome of the request: 'ok', 'rejected', 'failed'
+	Msg         string          `json:"msg"`            // message describing the progress
+	PromoSendID string          `json:"promosenddocid"` // document id of the broadcast
+	PSendStatus string          `json:"psendstatus"`    // status of the broadcast (e.g. 'sent' once every chunk job has finished)
+	IsHalted    bool            `json:"ishalted"`       // broadcast has been halted (or cancelled)
+	HaltedMsg   string          `json:"haltedmsg"`      // reason the broadcast was halted
+	NumChunks   int             `json:"numchunks"`      // number of chunk jobs queued
+	NumSent     int             `json:"numsent"`        // messages accepted by Twilio so far
+	NumFailed   int             `json:"numfailed"`      // messages that failed to send so far
+	JobStates   map[string]int  `json:"jobstates"`      // number of chunk jobs by job state
+	Results     *psendResults   `json:"results"`        // final counts (once the broadcast has been flagged as sent)
+	Chunks      []progressChunk `json:"chunks"`         // per chunk job progress
+	Log         progressLog     `json:"log"`            // page of the broadcast's job log
+}
+
+// progressChunk models the progress of a single chunk job
+type progressChunk struct {
+	ChunkNumber   int    `json:"chunknumber"`         // sequence number of the chunk within the broadcast
+	JobID         string `json:"jobid"`               // Faktory job id
+	IDString      string `json:"idstring"`            // workerjobdata document id
+	State         string `json:"state"`               // job state: 'pending', 'orphaned', 'scheduled', 'queued', 'busy', 'dead', 'done'
+	Outcome       string `json:"outcome"`             // outcome recorded by the worker: 'done', 'halted', 'failed', 'cancelled'
+	NumRecipients int    `json:"numrecipients"`       // number of recipients in the chunk
+	Sent          int    `json:"sent"`                // messages accepted by Twilio so far
+	Failed        int    `json:"failed"`              // messages that failed to send so far
+	ScheduledTime string `json:"scheduledtime"`       // time the job is scheduled to run
+	StartTime     string `json:"starttime"`           // time the job started (empty: not started)
+	FinishTime    string `json:"finishtime"`          // time the job finished (empty: not finished)
+	Cancelled     bool   `json:"cancelled"`           // chunk has been cancelled
+	Reschedules   int    `json:"reschedules"`         // times the job was rescheduled to run when the call hours open
+	DeferredFrom  string `json:"deferredfrom"`        // chunk (idstring) whose recipients outside their call hours this chunk texts (empty: a planned chunk)
+	PushError     string `json:"pusherror,omitempty"` // last error pushing the job (if any)
+}
+
+// progressLog models a page of a promotion broadcast's job log
+type progressLog struct {
+	Page     int                `json:"page"`     // page number (starting at 1)
+	PageSize int                `json:"pagesize"` // lines per page
+	NumLines int                `json:"numlines"` // number of lines in the log
+	NumPages int                `json:"numpages"` // number of pages in the log
+	Lines    []WorkerJobLogLine `json:"lines"`    // log lines of the page (in the order they were written)
+}
+
+// chunkState derives the state of a chunk's job from its job directive; Faktory's client can't look up a
+// job, so the state follows the outbox state and the start and outcome recorded by the worker (a job that
+// ends with an error sits in Faktory's Dead set as jobs are not retried)
+func chunkState(d ProcessDirective) string {
+	switch {
+
+	// CASE: job ended (or was cancelled before it ran)
+	case len(d.ChunkResult.Status) != 0:
+		if d.ChunkResult.Status == cntChunkStatusDone {
+			return cntJobStateDone
+		}
+		return cntJobStateDead
+
+	// CASE: job running
+	case !d.ChunkStartTime.IsZero():
+		return cntJobStateBusy
+
+	// CASE: job not pushed yet (or never pushed)
+	case d.PushStatus == cntPushStatusPending || d.PushStatus == cntPushStatusPushing:
+		return cntJobStatePending
+	case d.PushStatus == cntPushStatusOrphaned:
+		return cntJobStateOrphaned
+
+	// CASE: job waiting to run at its scheduled time
+	case d.PushAt.After(time.Now()):
+		return cntJobStateScheduled
+
+	// DEFAULT: job waiting for a worker
+	default:
+		return cntJobStateQueued
+	}
+}
+
+// fetchMsgCounts counts the messages sent (accepted by Twilio) and failed for a promotion broadcast by job id
+func fetchMsgCounts(psid string) (map[string][2]int, error) {
+	var (
+		err  error
+		cnts = make(map[string][2]int)
+		docs []struct {
+			JobID string `bson:"_id"`
+			Sent  int    `bson:"sent"`
+			Total int    `bson:"total"`
+		}
+	)
+
+	err = mgoCollSMSMessages.Pipe([]bson.M{
+		{"$match": bson.M{"promotionsendid": bson.ObjectIdHex(psid)}},
+		{"$group": bson.M{
+			"_id":   "$jobid",
+			"sent":  bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$eq": []interface{}{"$twiliostatus", 201}}, 1, 0}}},
+			"total": bson.M{"$sum": 1},
+		}},
+	}).All(&docs)
+	if err != nil {
+		// error counting the broadcast's messages
+		return cnts, fmt.Errorf("error counting the messages of promotion broadcast: %v; see: %v", psid, err)
+	}
+
+	for _, d := range docs {
+		cnts[d.JobID] = [2]int{d.Sent, d.Total - d.Sent}
+	}
+
+	return cnts, nil
+}
+
+// hdlBroadcastProgress handles an inbound request for the progress of a promotion broadcast; the job log is
+// paginated with the 'page' (starting at 1) and 'pagesize' query parameters
+func hdlBroadcastProgress(c *gin.Context) {
+	var (
+		err   error
+		drts  []ProcessDirective
+		cnts  map[string][2]int
+		rsp   = progressResponse{PromoSendID: c.Param("psid"), JobStates: make(map[string]int)}
+		psend struct {
+			Status    string        `bson:"status"`
+			IsHalted  bool          `bson:"ishalted"`
+			HaltedMsg string        `bson:"psendhaltedmsg"`
+			Results   *psendResults `bson:"psendresults"`
+		}
+	)
+
+	// Validate the request
+	if !bson.IsObjectIdHex(rsp.PromoSendID) {
+		// invalid promotion send document id
+		rsp.Status = cntProgressResultRejected
+		rsp.Msg = fmt.Sprintf("invalid promotion send document id: %v", rsp.PromoSendID)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+	rsp.Log.Page, err = strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || rsp.Log.Page < 1 {
+		// invalid page number
+		rsp.Status = cntProgressResultRejected
+		rsp.Msg = fmt.Sprintf("invalid page parameter: %v", c.Query("page"))
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+	rsp.Log.PageSize, err = strconv.Atoi(c.DefaultQuery("pagesize", strconv.Itoa(cntProgressLogPageSize)))
+	if err != nil || rsp.Log.PageSize < 1 || rsp.Log.PageSize > cntProgressLogMaxPageSize {
+		// invalid page size
+		rsp.Status = cntProgressResultRejected
+		rsp.Msg = fmt.Sprintf("invalid pagesize parameter: %v (expecting 1 to %v)", c.Query("pagesize"), cntProgressLogMaxPageSize)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	// failed returns a failed response
+	failed := func(msg string, err error) {
+		appLog("ERROR: %v - %v for psend: %v. See: %v\n", utils.FileLine(), msg, rsp.PromoSendID, err)
+		rsp.Status = cntProgressResultFailed
+		rsp.Msg = fmt.Sprintf("%v; see: %v", msg, err)
+		c.JSON(http.StatusInternalServerError, rsp)
+	}
+
+	// Fetch the promotion broadcast's status
+	err = mgoCollPromoBroadcasts.FindId(bson.ObjectIdHex(rsp.PromoSendID)).One(&psend)
+	if err != nil {
+		failed("error fetching the promotion broadcast", err)
+		return
+	}
+	rsp.PSendStatus, rsp.IsHalted, rsp.HaltedMsg, rsp.Results = psend.Status, psend.IsHalted, psend.HaltedMsg, psend.Results
+
+	// Fetch the broadcast's chunk jobs and the messages they have sent
+	err = mgoCollWorkerJobData.Find(bson.M{"promosendid": rsp.PromoSendID}).Sort("chunknumber", "enqueuetime").All(&drts)
+	if err != nil {
+		failed("error fetching the job directives", err)
+		return
+	}
+	cnts, err = fetchMsgCounts(rsp.PromoSendID)
+	if err != nil {
+		failed("error counting the messages sent", err)
+		return
+	}
+
+	for _, d := range drts {
+		var chk = progressChunk{
+			ChunkNumber:   d.ChunkNumber,
+			JobID:         d.JobID,
+			IDString:      d.IDString,
+			State:         chunkState(d),
+			Outcome:       d.ChunkResult.Status,
+			NumRecipients: len(d.Data),
+			ScheduledTime: d.ScheduledTime,
+			Cancelled:     d.Cancelled,
+			Reschedules:   d.NumReschedules,
+			DeferredFrom:  d.DeferredFrom,
+			PushError:     d.PushError,
+		}
+
+		if !d.ChunkStartTime.IsZero() {
+			chk.StartTime = d.ChunkStartTime.In(timePT).Format(time.RFC3339)
+		}
+
+		// A finished job's recorded counts include messages that failed to post to Twilio (and were not stored)
+		for _, jid := range append(d.PriorJobIDs, d.JobID) {
+			chk.Sent, chk.Failed = chk.Sent+cnts[jid][0], chk.Failed+cnts[jid][1]
+		}
+		if len(d.ChunkResult.Status) != 0 {
+			chk.Sent, chk.Failed = d.ChunkResult.Sent, d.ChunkResult.Failed
+			chk.FinishTime = d.ChunkResult.FinishTime.In(timePT).Format(time.RFC3339)
+		}
+
+		rsp.NumSent = rsp.NumSent + chk.Sent
+		rsp.NumFailed = rsp.NumFailed + chk.Failed
+		rsp.JobStates[chk.State]++
+		rsp.Chunks = append(rsp.Chunks, chk)
+	}
+	rsp.NumChunks = len(rsp.Chunks)
+
+	// Fetch the requested page of the job log
+	qry := mgoCollWorkerJobLog.Find(bson.M{"promosenddocid": bson.ObjectIdHex(rsp.PromoSendID)})
+	rsp.Log.NumLines, err = qry.Count()
+	if err != nil {
+		failed("error counting the job log lines", err)
+		return
+	}
+	rsp.Log.NumPages = (rsp.Log.NumLines + rsp.Log.PageSize - 1) / rsp.Log.PageSize
+	err = qry.Sort("sequencenumber", "_id").Skip((rsp.Log.Page - 1) * rsp.Log.PageSize).Limit(rsp.Log.PageSize).All(&rsp.Log.Lines)
+	if err != nil {
+		failed("error fetching the job log lines", err)
+		return
+	}
+
+	rsp.Status = cntProgressResultOK
+	rsp.Msg = fmt.Sprintf("%v chunk jobs (%v done, %v busy, %v dead) - %v messages sent, %v failed (halted?: %v)",
+		rsp.NumChunks,
+		rsp.JobStates[cntJobStateDone],
+		rsp.JobStates[cntJobStateBusy],
+		rsp.JobStates[cntJobStateDead],
+		rsp.NumSent,
+		rsp.NumFailed,
+		rsp.IsHalted)
+	c.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,204 @@
+// qrgenfuncs.go contains code to enqueue jobs generating QR code images for a list of shortcodes on an ad hoc basis (shared promos)
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+
+	fak "github.com/contribsys/faktory/client"
+
+	cmn "github.com/whopdan/wrkrcom"
+)
+
+// qrGenBatchRequest models a request to generate QR code images for a list of shortcodes
+type qrGenBatchRequest struct {
+	ShortCodes  []string `json:"shortcodes"`     // shortcodes to encode
+	PromoSendID string   `json:"promosenddocid"` // (optional) promotion send the shortcodes belong to
+	OwnerID     string   `json:"ownerid"`        // (optional) owner (e.g. dispensary) the shortcodes belong to
+}
+
+// qrGenBatchResponse models the response returned to a request to generate QR code images for a list of shortcodes
+type qrGenBatchResponse struct {
+	Status        string            `json:"status"`         // outcome of the request: 'enqueued', 'partial', 'failed', 'rejected'
+	Msg           string            `json:"msg"`            // message describing the outcome
+	PromoSendID   string            `json:"promosenddocid"` // promotion send the shortcodes belong to
+	OwnerID       string            `json:"ownerid"`        // owner the shortcodes belong to
+	NumJobs       int               `json:"numjobs"`        // number of jobs successfully pushed
+	NumShortCodes int               `json:"numshortcodes"`  // number of shortcodes in successfully pushed jobs
+	JobIDs        map[string]string `json:"jobids"`         // shortcode -> id of the job that renders its QR code
+	Errors        map[string]string `json:"errors"`         // shortcode -> error pushing its job
+	Skipped       []string          `json:"skipped"`        // empty or duplicate shortcodes that were not queued
+}
+
+// postAdHocQRGenBatchJob enqueues a job to generate and save QR code images for a batch of shortcodes
+func postAdHocQRGenBatchJob(grp string, shrtcdes []string) (string, error) {
+	var (
+		err  error
+		args = []interface{}{cntQRGenAdHocBatch, grp}
+	)
+
+	// Light validation of the inbound directive - do we have data?
+	if len(shrtcdes) == 0 {
+		// missing job data
+		return "", fmt.Errorf("missing job data - nothing to do")
+	}
+
+	for _, scde := range shrtcdes {
+		args = append(args, scde)
+	}
+
+	job := fak.NewJob(cmn.CntWorkerNameQRCodeGen, args...) // Create a new job
+	job.ReserveFor = cfg.WorkerJobTimeoutSec               // Assign a timeout
+	job.Queue = cmn.CntWorkerNameQRCodeGen                 // Post to the "bv-job-worker-qrcode-gen" queue
+	job.Retry = -1                                         // Failed jobs should are not retried and moved to the 'Dead' Faktory tab
+
+	err = fakPush(job)
+	if err != nil {
+		// error pushing a faktory job
+		appLog("ERROR: %v - error pushing a faktory job: %v (%v). See: %v\n",
+			utils.FileLine(),
+			cmn.CntWorkerNameQRCodeGen,
+			job.Jid,
+			err)
+		return "", fmt.Errorf("error pushing a faktory job: %v (%v); see: %v",
+			job.Jid,
+			cmn.CntWorkerNameQRCodeGen,
+			err)
+	}
+
+	appLog("INFO: %v - submitted job: %v (%v) for %v shortcodes (group: '%v')\n",
+		utils.FileLine(),
+		cmn.CntWorkerNameQRCodeGen,
+		job.Jid,
+		len(shrtcdes),
+		grp)
+
+	return job.Jid, nil
+}
+
+// hdlQueueAdHocQRGenBatch handles inbound requests to generate QR code images for a list of shortcodes
+func hdlQueueAdHocQRGenBatch(c *gin.Context) {
+	var (
+		err      error
+		jbID     string
+		grp      string
+		rbytes   []byte
+		shrtcdes []string
+		seen     = make(map[string]bool)
+		req      qrGenBatchRequest
+		rsp      = qrGenBatchResponse{JobIDs: make(map[string]string), Errors: make(map[string]string)}
+	)
+
+	// Read the request body
+	rbytes, err = c.GetRawData()
+	if err != nil {
+		// error reading the request data
+		appLog("ERROR: %v - error reading the request data. See: %v\n", utils.FileLine(), err)
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = fmt.Sprintf("error reading the request data; see: %v", err)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	// Parse the inbound request data
+	err = utils.FromJSONBytes(rbytes, &req)
+	if err != nil {
+		// error parsing the request data
+		appLog("ERROR: %v - error parsing the request data. See: %v\n", utils.FileLine(), err)
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = fmt.Sprintf("error parsing the request data; see: %v", err)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+	rsp.PromoSendID = req.PromoSendID
+	rsp.OwnerID = req.OwnerID
+
+	// Drop empty and duplicate shortcodes
+	for _, scde := range req.ShortCodes {
+		scde = strings.TrimSpace(scde)
+		if len(scde) == 0 || seen[scde] {
+			rsp.Skipped = append(rsp.Skipped, scde)
+			continue
+		}
+		seen[scde] = true
+		shrtcdes = append(shrtcdes, scde)
+	}
+
+	if len(shrtcdes) == 0 {
+		// missing parameter data
+		appLog("ERROR: %v - missing shortcodes parameter data\n", utils.FileLine())
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = "missing shortcodes parameter"
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	// Group the jobs by promotion send (or owner) for the worker's logs
+	grp = req.PromoSendID
+	if len(grp) == 0 {
+		grp = req.OwnerID
+	}
+
+	appLog("INFO: %v - inbound ad hoc qr generation request for %v shortcodes (group: '%v') in environment: %v\n",
+		utils.FileLine(),
+		len(shrtcdes),
+		grp,
+		cfg.WorkerEnvironment)
+
+	// Post (enqueue) a job for each batch of shortcodes
+	for i := 0; i < len(shrtcdes); i = i + cfg.WorkerQRBatchSize {
+		end := i + cfg.WorkerQRBatchSize
+		if end > len(shrtcdes) || cfg.WorkerQRBatchSize <= 0 {
+			end = len(shrtcdes)
+		}
+
+		jbID, err = postAdHocQRGenBatchJob(grp, shrtcdes[i:end])
+		for _, scde := range shrtcdes[i:end] {
+			if err != nil {
+				rsp.Errors[scde] = err.Error()
+				continue
+			}
+			rsp.JobIDs[scde] = jbID
+		}
+		if err == nil {
+			rsp.NumJobs = rsp.NumJobs + 1
+			rsp.NumShortCodes = rsp.NumShortCodes + end - i
+		}
+
+		if end == len(shrtcdes) {
+			break
+		}
+	}
+
+	switch {
+
+	// CASE: no jobs pushed
+	case rsp.NumJobs == 0:
+		rsp.Status = cntEnqueueResultFailed
+
+	// CASE: some jobs failed to push
+	case len(rsp.Errors) != 0:
+		rsp.Status = cntEnqueueResultPartial
+
+	// DEFAULT: all jobs pushed
+	default:
+		rsp.Status = cntEnqueueResultEnqueued
+	}
+	rsp.Msg = fmt.Sprintf("enqueued %v jobs encoding %v of %v shortcodes (%v skipped)",
+		rsp.NumJobs,
+		rsp.NumShortCodes,
+		len(shrtcdes),
+		len(rsp.Skipped))
+
+	// return to the caller
+	appLog("INFO: %v - returning to caller requesting to encode QR codes (group: '%v') - %v\n", utils.FileLine(), grp, rsp.Msg)
+	if rsp.Status == cntEnqueueResultFailed {
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+	c.JSON(http.StatusOK, rsp)
+}
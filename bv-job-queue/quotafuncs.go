@@ -0,0 +1,175 @@
+// quotafuncs.go contains the admin routes that view and adjust the sending quotas of promotion broadcast owners
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// quotaResponse models the response returned to a request to view or adjust an owner's sending quota
+type quotaResponse struct {
+	Status string     `json:"status"` // outcome of the request: 'ok', 'rejected', 'failed'
+	Msg    string     `json:"msg"`    // message describing the outcome
+	Quota  ownerQuota `json:"quota"`  // owner's allowance adjustments
+	Usage  quotaUsage `json:"usage"`  // owner's effective allowances and usage
+}
+
+// hdlGetOwnerQuota handles an inbound request to view an owner's sending quota and usage
+func hdlGetOwnerQuota(c *gin.Context) {
+	var (
+		err     error
+		rsp     quotaResponse
+		ownerid = c.Param("ownerid")
+	)
+
+	if !bson.IsObjectIdHex(ownerid) {
+		// missing or invalid owner id
+		rsp.Status = cntQuotaResultRejected
+		rsp.Msg = fmt.Sprintf("invalid owner id: %v", ownerid)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	rsp.Quota, err = fetchOwnerQuota(ownerid)
+	if err == nil {
+		rsp.Usage, err = fetchQuotaUsage(ownerid, time.Now())
+	}
+	if err != nil {
+		// error fetching the owner's quota
+		appLog("ERROR: %v - error fetching the sending quota of owner: %v. See: %v\n", utils.FileLine(), ownerid, err)
+		rsp.Status = cntQuotaResultFailed
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+
+	rsp.Status = cntQuotaResultOK
+	rsp.Msg = fmt.Sprintf("owner %v (plan: %v) has %v messages left today and %v left this month (-1: unlimited)",
+		ownerid,
+		rsp.Usage.Plan,
+		rsp.Usage.DailyLeft,
+		rsp.Usage.MonthlyLeft)
+	c.JSON(http.StatusOK, rsp)
+}
+
+// hdlAdjustOwnerQuota handles an inbound request to adjust an owner's plan and/or allowances; the request
+// parameters are ownerid (required), plan, dailylimit and monthlylimit (0: plan allowance, -1: unlimited), quotapolicy
+// ('reject', 'partial' or 'plan': the plan's policy), and note
+func hdlAdjustOwnerQuota(c *gin.Context) {
+	var (
+		err    error
+		params map[string]string
+		rsp    quotaResponse
+	)
+
+	params, err = readRequestParams(c)
+	if err != nil {
+		// invalid request
+		rsp.Status = cntQuotaResultRejected
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	if !bson.IsObjectIdHex(params["ownerid"]) {
+		// missing or invalid owner id
+		rsp.Status = cntQuotaResultRejected
+		rsp.Msg = fmt.Sprintf("missing or invalid ownerid parameter: %v", params["ownerid"])
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	rsp.Quota, err = fetchOwnerQuota(params["ownerid"])
+	if err != nil {
+		// error fetching the owner's quota
+		appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+		rsp.Status = cntQuotaResultFailed
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+
+	// Apply the adjustments
+	if len(params["plan"]) != 0 {
+		rsp.Quota.Plan = params["plan"]
+	}
+	for key, lmt := range map[string]*int{"dailylimit": &rsp.Quota.DailyLimit, "monthlylimit": &rsp.Quota.MonthlyLimit} {
+		if len(params[key]) == 0 {
+			continue
+		}
+
+		*lmt, err = strconv.Atoi(params[key])
+		if err != nil || *lmt < -1 {
+			// invalid allowance
+			rsp.Status = cntQuotaResultRejected
+			rsp.Msg = fmt.Sprintf("invalid %v parameter: %v (expecting a number of messages, 0: plan allowance, or -1: unlimited)", key, params[key])
+			c.JSON(http.StatusBadRequest, rsp)
+			return
+		}
+	}
+	switch params["quotapolicy"] {
+
+	// CASE: policy not adjusted
+	case "":
+
+	// CASE: back to the plan's policy
+	case "plan":
+		rsp.Quota.Policy = ""
+
+	// CASE: policy adjusted for the owner
+	case cntQuotaPolicyReject, cntQuotaPolicyPartial:
+		rsp.Quota.Policy = params["quotapolicy"]
+
+	// DEFAULT: invalid policy
+	default:
+		rsp.Status = cntQuotaResultRejected
+		rsp.Msg = fmt.Sprintf("invalid quotapolicy parameter: %v (expecting '%v', '%v' or 'plan')", params["quotapolicy"], cntQuotaPolicyReject, cntQuotaPolicyPartial)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+	rsp.Quota.Note = params["note"]
+	rsp.Quota.UpdatedBy = c.GetString(cntCtxCaller)
+	rsp.Quota.UpdatedTime = time.Now().UTC()
+
+	err = saveOwnerQuota(rsp.Quota)
+	if err != nil {
+		// error saving the owner's quota
+		appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+		rsp.Status = cntQuotaResultFailed
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+
+	appLog("INFO: %v - sending quota of owner: %v adjusted by: '%v' - plan: %v daily: %v monthly: %v policy: %v (note: %v)\n",
+		utils.FileLine(),
+		rsp.Quota.OwnerID,
+		rsp.Quota.UpdatedBy,
+		rsp.Quota.Plan,
+		rsp.Quota.DailyLimit,
+		rsp.Quota.MonthlyLimit,
+		rsp.Quota.policy(),
+		rsp.Quota.Note)
+
+	// Return the effective allowances
+	rsp.Usage, err = fetchQuotaUsage(rsp.Quota.OwnerID, time.Now())
+	if err != nil {
+		// quota saved but usage unavailable
+		appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+		rsp.Msg = fmt.Sprintf("quota adjusted; usage unavailable: %v", err)
+	} else {
+		rsp.Msg = fmt.Sprintf("quota adjusted - owner %v (plan: %v) has %v messages left today and %v left this month (-1: unlimited)",
+			rsp.Quota.OwnerID,
+			rsp.Usage.Plan,
+			rsp.Usage.DailyLeft,
+			rsp.Usage.MonthlyLeft)
+	}
+	rsp.Status = cntQuotaResultOK
+	c.JSON(http.StatusOK, rsp)
+}
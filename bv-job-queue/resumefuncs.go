@@ -0,0 +1,280 @@
+// resumefuncs.go contains code to resume the halted or crashed chunk jobs of a promotion broadcast: a resumed
+// job skips the recipients its earlier run(s) checkpointed as handled, so only the remainder is texted
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	mgo "github.com/globalsign/mgo"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// chunkProgress models the per recipient progress of a chunk job checkpointed on its job directive by bv-job-worker-smsmsgs
+type chunkProgress struct {
+	LastIndex int                         `bson:"lastindex" json:"lastindex"` // index (in the directive's data) of the last recipient handled
+	Outcomes  map[string]recipientOutcome `bson:"outcomes" json:"outcomes"`   // outcome of each recipient handled (keyed by index)
+}
+
+// recipientOutcome models the outcome of a single recipient of a chunk job
+type recipientOutcome struct {
+	Phone   string    `bson:"phone" json:"phone"`     // recipient phone number
+	Outcome string    `bson:"outcome" json:"outcome"` // 'sent', 'failed', 'skipped', 'stoplisted' or 'deferred'
+	Time    time.Time `bson:"time" json:"time"`       // time the recipient was handled
+}
+
+// resumeResponse models the response returned to a request to resume a promotion broadcast
+type resumeResponse struct {
+	Status      string        `json:"status"`         // outcome of the request: 'ok', 'rejected', 'failed'
+	Msg         string        `json:"msg"`            // message describing the outcome
+	PromoSendID string        `json:"promosenddocid"` // document id of the broadcast being resumed
+	NumResumed  int           `json:"numresumed"`     // number of chunk jobs re-queued
+	Chunks      []resumeChunk `json:"chunks"`         // per chunk outcome
+}
+
+// resumeChunk models the outcome of resuming a single chunk job
+type resumeChunk struct {
+	ChunkNumber int    `json:"chunknumber"`      // sequence number of the chunk within the broadcast
+	IDString    string `json:"idstring"`         // workerjobdata document id
+	PriorJobID  string `json:"priorjobid"`       // job id of the halted or crashed run
+	JobID       string `json:"jobid"`            // job id of the resumed run (empty: not resumed)
+	Handled     int    `json:"handled"`          // recipients handled by the earlier run(s)
+	Remaining   int    `json:"remaining"`        // recipients left to text
+	Resumed     bool   `json:"resumed"`          // chunk job re-queued
+	PushStatus  string `json:"pushstatus"`       // outbox state of the resumed job ('pushed' or 'pending')
+	Reason      string `json:"reason,omitempty"` // reason the chunk was not resumed
+	Error       string `json:"error,omitempty"`  // error resuming the chunk (if any)
+}
+
+// resumable determines if a chunk job can be resumed: its job halted or failed, or it crashed (started but
+// never recorded an outcome within the job timeout); returns the reason if it can't
+func resumable(d ProcessDirective) (bool, string) {
+	switch {
+
+	// CASE: cancelled chunk
+	case d.Cancelled || d.ChunkResult.Status == cntChunkStatusCancelled:
+		return false, "chunk cancelled"
+
+	// CASE: halted or failed chunk
+	case d.ChunkResult.Status == cntChunkStatusHalted || d.ChunkResult.Status == cntChunkStatusFailed:
+		return true, ""
+
+	// CASE: finished chunk
+	case len(d.ChunkResult.Status) != 0:
+		return false, fmt.Sprintf("chunk finished (%v)", d.ChunkResult.Status)
+
+	// CASE: chunk not started
+	case d.ChunkStartTime.IsZero():
+		return false, "chunk has not started"
+
+	// CASE: chunk running (within the job timeout)
+	case time.Since(d.ChunkStartTime) < time.Duration(cfg.WorkerJobTimeoutSec)*time.Second:
+		return false, "chunk is running"
+
+	// DEFAULT: chunk crashed
+	default:
+		return true, ""
+	}
+}
+
+// resumeDirective re-queues the job of a halted or crashed job directive under a new job id; the directive's
+// outcome is cleared (the completion tracker waits for it again) and its checkpointed progress is kept
+func resumeDirective(d ProcessDirective) (string, string, error) {
+	var (
+		err    error
+		prior  = d.JobID
+		claimd bool
+	)
+
+	d.JobID = ""
+	d.PushAt = time.Now().UTC()
+	jid := newSMSJob(d).Jid
+
+	// Reset the directive (unless another request resumed it first)
+	err = mgoCollWorkerJobData.Update(
+		bson.M{"_id": d.ID, "jobid": prior},
+		bson.M{
+			"$set": bson.M{
+				"jobid":        jid,
+				"pushstatus":   cntPushStatusPending,
+				"pushat":       d.PushAt,
+				"pushattempts": 0,
+				"pusherror":    "",
+			},
+			"$unset": bson.M{"chunkresult": "", "chunkqrdone": "", "chunksnapdone": "", "chunkstarttime": ""},
+			"$push":  bson.M{"priorjobids": prior},
+		})
+	if err == mgo.ErrNotFound {
+		// directive resumed by another request
+		return "", "", fmt.Errorf("job directive: %v was resumed by another request", d.IDString)
+	}
+	if err != nil {
+		// error resetting the directive
+		return "", "", fmt.Errorf("error resetting job directive: %v for resuming; see: %v", d.IDString, err)
+	}
+
+	// Push the job (left pending for the outbox dispatcher if the push fails)
+	d, claimd, err = claimPush(d.ID)
+	if err != nil || !claimd {
+		return jid, cntPushStatusPending, err
+	}
+	err = pushDirective(d)
+	if err != nil {
+		appLog("ERROR: %v - job: %v left pending for the outbox dispatcher. See: %v\n", utils.FileLine(), jid, err)
+		return jid, cntPushStatusPending, nil
+	}
+
+	return jid, cntPushStatusPushed, nil
+}
+
+// resumePSend clears the halted flag of a promotion broadcast and has the completion tracker wait for its
+// resumed chunk jobs again
+func resumePSend(id string) error {
+	var err error
+
+	err = mgoCollPromoBroadcasts.UpdateId(
+		bson.ObjectIdHex(id),
+		bson.M{
+			"$set":   bson.M{"ishalted": false, "psendhaltedmsg": "", "psendtracking": true, "status": cntPromoSendNotSent},
+			"$unset": bson.M{"psendresults": ""},
+		})
+	if err != nil {
+		// error resetting the broadcast
+		return fmt.Errorf("error resetting promotion broadcast: %v for resuming; see: %v", id, err)
+	}
+
+	return nil
+}
+
+// hdlResumeBroadcast handles an inbound request to resume the halted or crashed chunk jobs of a promotion
+// broadcast (promosenddocid), or a single chunk (idstring); only the recipients not yet handled are texted.
+// A halt directive set for the broadcast in bv-job-halt must be lifted first or the resumed jobs halt again.
+func hdlResumeBroadcast(c *gin.Context) {
+	var (
+		err      error
+		params   map[string]string
+		drts     []ProcessDirective
+		logLines []string
+		rsp      resumeResponse
+		qry      bson.M
+	)
+
+	params, err = readRequestParams(c)
+	if err != nil {
+		// invalid request
+		rsp.Status = cntResumeResultRejected
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	// Validate the request data
+	rsp.PromoSendID = params["promosenddocid"]
+	if !bson.IsObjectIdHex(rsp.PromoSendID) {
+		// missing or invalid promotion send document id
+		rsp.Status = cntResumeResultRejected
+		rsp.Msg = fmt.Sprintf("missing or invalid promosenddocid parameter: %v", rsp.PromoSendID)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+	qry = bson.M{"promosendid": rsp.PromoSendID}
+	if len(params["idstring"]) != 0 {
+		qry["idstring"] = params["idstring"]
+	}
+
+	appLog("INFO: %v - inbound request to resume psend: %v (chunk: %v) in environment: %v\n",
+		utils.FileLine(),
+		rsp.PromoSendID,
+		params["idstring"],
+		cfg.WorkerEnvironment)
+
+	// Fetch the job directives
+	err = mgoCollWorkerJobData.Find(qry).Sort("chunknumber").All(&drts)
+	if err != nil {
+		// error fetching the broadcast's job directives
+		appLog("ERROR: %v - error fetching the job directives of psend: %v. See: %v\n", utils.FileLine(), rsp.PromoSendID, err)
+		rsp.Status = cntResumeResultFailed
+		rsp.Msg = fmt.Sprintf("error fetching the broadcast's job directives; see: %v", err)
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+	if len(drts) == 0 {
+		// no chunks found
+		rsp.Status = cntResumeResultRejected
+		rsp.Msg = "no job directives found for the broadcast (or chunk)"
+		c.JSON(http.StatusNotFound, rsp)
+		return
+	}
+
+	// Resume the halted and crashed chunks with recipients left to text
+	for _, d := range drts {
+		var chk = resumeChunk{
+			ChunkNumber: d.ChunkNumber,
+			IDString:    d.IDString,
+			PriorJobID:  d.JobID,
+			Handled:     len(d.ChunkProgress.Outcomes),
+			Remaining:   len(d.Data) - len(d.ChunkProgress.Outcomes),
+		}
+
+		ok, reason := resumable(d)
+		switch {
+
+		// CASE: chunk can't be resumed
+		case !ok:
+			chk.Reason = reason
+
+		// CASE: every recipient handled
+		case chk.Remaining <= 0:
+			chk.Reason = "no recipients left to text"
+
+		// DEFAULT: resume the chunk
+		default:
+			chk.JobID, chk.PushStatus, err = resumeDirective(d)
+			if err != nil {
+				appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+				chk.Error = err.Error()
+			}
+			if len(chk.JobID) != 0 {
+				chk.Resumed = true
+				rsp.NumResumed = rsp.NumResumed + 1
+				logLines = jobLog(logLines, "RESUME: chunk #%v (job: %v) resumed as job: %v - %v recipients left to text (%v handled)",
+					chk.ChunkNumber,
+					chk.PriorJobID,
+					chk.JobID,
+					chk.Remaining,
+					chk.Handled)
+			}
+		}
+
+		rsp.Chunks = append(rsp.Chunks, chk)
+	}
+
+	if rsp.NumResumed == 0 {
+		// nothing to resume
+		rsp.Status = cntResumeResultRejected
+		rsp.Msg = "no halted or crashed chunks with recipients left to text"
+		c.JSON(http.StatusConflict, rsp)
+		return
+	}
+
+	// Clear the broadcast's halted flag and track its completion again
+	err = resumePSend(rsp.PromoSendID)
+	if err != nil {
+		appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+		rsp.Status = cntResumeResultFailed
+		rsp.Msg = fmt.Sprintf("resumed %v chunk job(s) but %v", rsp.NumResumed, err)
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+
+	// Write the "log lines" to the database
+	wrtJobLog(cfg.WorkerEnvironment, logLines, rsp.PromoSendID, "")
+
+	rsp.Status = cntResumeResultOK
+	rsp.Msg = fmt.Sprintf("resumed %v of %v chunk job(s) of broadcast %v", rsp.NumResumed, len(drts), rsp.PromoSendID)
+	appLog("INFO: %v - %v\n", utils.FileLine(), rsp.Msg)
+	c.JSON(http.StatusOK, rsp)
+}
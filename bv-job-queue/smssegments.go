@@ -0,0 +1,97 @@
+// smssegments.go estimates the segments and cost of the messages of a promotion broadcast (each message is
+// analyzed as bv-job-worker-smsmsgs analyzes the texts it sends - see github.com/whopdan/bv-common/smssegments)
+package main
+
+import (
+	"fmt"
+
+	"github.com/whopdan/bv-common/msgtemplate"
+	"github.com/whopdan/bv-common/smssegments"
+)
+
+// segmentEstimate models the estimated segments and cost of the messages of a promotion broadcast
+type segmentEstimate struct {
+	Messages    int     `json:"messages"`          // messages estimated
+	NumUCS2     int     `json:"numucs2"`           // messages encoded as UCS-2
+	Segments    int     `json:"segments"`          // segments of every message
+	MaxSegments int     `json:"maxsegments"`       // segments of the longest message
+	NumOver     int     `json:"numover"`           // messages over the segment limit (WorkerMaxSegments)
+	IsMMS       bool    `json:"ismms"`             // messages are sent with media (billed per message rather than per segment)
+	EstCost     float64 `json:"estcost"`           // estimated cost (USD)
+	Rejected    bool    `json:"rejected"`          // messages over the segment limit rejected by the segment policy
+	Warning     string  `json:"warning,omitempty"` // messages over the segment limit (segment policy: 'warn')
+}
+
+// add adds a message to the estimate
+func (est *segmentEstimate) add(seg smssegments.Segments) {
+	est.Messages++
+	est.Segments = est.Segments + seg.Segments
+	if seg.Encoding == smssegments.EncodingUCS2 {
+		est.NumUCS2++
+	}
+	if seg.Segments > est.MaxSegments {
+		est.MaxSegments = seg.Segments
+	}
+	if cfg.WorkerMaxSegments > 0 && seg.Segments > cfg.WorkerMaxSegments {
+		est.NumOver++
+	}
+}
+
+// merge adds the messages of another estimate (e.g. a tag group's merged messages) to the estimate
+func (est *segmentEstimate) merge(oth segmentEstimate) {
+	est.Messages = est.Messages + oth.Messages
+	est.NumUCS2 = est.NumUCS2 + oth.NumUCS2
+	est.Segments = est.Segments + oth.Segments
+	est.NumOver = est.NumOver + oth.NumOver
+	est.EstCost = est.EstCost + oth.EstCost
+	if oth.MaxSegments > est.MaxSegments {
+		est.MaxSegments = oth.MaxSegments
+	}
+	est.applyPolicy()
+}
+
+// applyPolicy applies the segment policy (WorkerSegmentPolicy) to messages over the segment limit
+func (est *segmentEstimate) applyPolicy() {
+	est.Rejected, est.Warning = false, ""
+	if est.NumOver == 0 {
+		return
+	}
+
+	msg := fmt.Sprintf("%v of %v messages exceed the limit of %v segments (longest: %v segments)",
+		est.NumOver,
+		est.Messages,
+		cfg.WorkerMaxSegments,
+		est.MaxSegments)
+	if cfg.WorkerSegmentPolicy == cntSegmentPolicyReject {
+		est.Rejected = true
+	}
+	est.Warning = msg
+}
+
+// estimateSegments estimates the segments and cost of the messages of a job directive sent to its recipients:
+// each message is rendered (with a sample shortlink of a generated shortlink's length) as the worker renders it
+func estimateSegments(drtv ProcessDirective, rcpts []map[string]string) segmentEstimate {
+	var est = segmentEstimate{IsMMS: len(drtv.MediaURL) != 0}
+
+	mt, err := msgtemplate.Parse(drtv.Message, drtv.IsXPathwayGenericPromo)
+	if err != nil {
+		// invalid template (rejected when the request was read)
+		return est
+	}
+
+	for _, rcp := range rcpts {
+		msg, err := mt.Render(previewVars(drtv, rcp))
+		if err != nil {
+			continue
+		}
+		est.add(smssegments.Analyze(msg))
+	}
+
+	est.EstCost = float64(est.Segments) * cfg.WorkerCostPerSegment
+	if est.IsMMS {
+		est.EstCost = float64(est.Messages) * cfg.WorkerCostPerMMS
+	}
+	est.applyPolicy()
+
+	return est
+}
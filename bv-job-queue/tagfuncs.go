@@ -0,0 +1,585 @@
+// tagfuncs.go contains code to enqueue the promotion broadcasts of a tag group (broadcasts created together for several promotions) in one request
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// tagEnqueueResponse models the response returned to a request to enqueue a tag group of promotion broadcasts
+type tagEnqueueResponse struct {
+	Status         string            `json:"status"`         // outcome of the request: 'enqueued', 'partial', 'failed', 'existing', 'rejected'
+	Msg            string            `json:"msg"`            // message describing the outcome
+	TagID          string            `json:"tagid"`          // tag id linking the broadcasts
+	DuplicateRule  string            `json:"duplicaterule"`  // rule applied to recipients of several broadcasts: 'all', 'first', 'merge'
+	SendAt         string            `json:"sendat"`         // time the broadcasts are scheduled to start sending (empty: immediately)
+	MsgsPerSec     float64           `json:"msgspersec"`     // target send rate shared by the broadcasts (0: fixed schedule)
+	EstFinishTime  string            `json:"estfinishtime"`  // estimated time the last chunk finishes sending
+	NumJobs        int               `json:"numjobs"`        // number of jobs queued (pushed or pending)
+	NumMessages    int               `json:"nummessages"`    // number of recipients in queued jobs
+	NumFailedJobs  int               `json:"numfailedjobs"`  // number of jobs that failed to queue (nothing stored)
+	NumPendingJobs int               `json:"numpendingjobs"` // number of queued jobs that failed to push (left pending for the outbox dispatcher)
+	NumDupRemoved  int               `json:"numdupremoved"`  // number of recipients removed from a broadcast by the duplicate rule
+	NumMerged      int               `json:"nummerged"`      // number of recipients sent a merged message
+	Broadcasts     []enqueueResponse `json:"broadcasts"`     // per broadcast job plan (in creation order)
+	Quota          *quotaCheck       `json:"quota"`          // outcome of checking the tag group against its owner's sending quota
+}
+
+// tagGroup models a single promotion broadcast of a tag group being enqueued
+type tagGroup struct {
+	Req      enqueueRequest      // enqueue request for the broadcast (shares the group's schedule)
+	Rcpts    []map[string]string // valid recipients of the broadcast
+	Rows     map[string]int      // normalized phone number -> data row
+	Plan     []chunkPlan         // chunks of the broadcast's own message
+	Merged   []*tagMerge         // merged message chunks attributed to this broadcast
+	Existing bool                // broadcast has already been enqueued
+	Dropped  int                 // recipients (including merged message recipients) removed to fit the sending quota
+	Kept     int                 // recipients (including merged message recipients) remaining after the quota check
+	Rsp      enqueueResponse     // enqueue outcome of the broadcast
+}
+
+// tagMerge models recipients of several broadcasts sent one message combining the broadcasts' messages
+type tagMerge struct {
+	Drtv  ProcessDirective    // directive holding the merged message
+	Rcpts []map[string]string // recipients of the merged message
+	Plan  []chunkPlan         // chunks of the merged message
+}
+
+// fetchTagGroup fetches and validates the enqueue data of each broadcast of a tag group
+func fetchTagGroup(tagid string, params map[string]string) ([]*tagGroup, error) {
+	var (
+		err   error
+		psids []string
+		grps  []*tagGroup
+	)
+
+	psids, err = fetchTagBroadcasts(tagid)
+	if err != nil {
+		return nil, err
+	}
+	if len(psids) == 0 {
+		// no broadcasts carry the tag
+		return nil, fmt.Errorf("no promotion broadcasts found with tag id: %v", tagid)
+	}
+
+	for _, psid := range psids {
+		var grp = tagGroup{Rows: make(map[string]int)}
+
+		// Fetch the broadcast's (latest) enqueue data
+		err = mgoCollJobQueueEnqueues.Find(bson.M{"promosendid": psid}).Sort("-_id").One(&grp.Req.Drtv)
+		if err != nil {
+			// error fetching job enqueuing data
+			return nil, fmt.Errorf("error fetching job enqueuing data for promotion send: %v; see: %v", psid, err)
+		}
+
+		err = validateDirective(grp.Req.Drtv)
+		if err != nil {
+			return nil, fmt.Errorf("promotion send: %v; %v", psid, err)
+		}
+
+		grp.Req.DocID = grp.Req.Drtv.IDString
+		grp.Req.Force = params["force"] == cntParamTrue
+		grp.Req.Params = params
+		grp.Rsp.PromoSendID = psid
+		grp.Rsp.EnqueueDocID = grp.Req.DocID
+
+		// Has this promotion send already been queued?
+		if !grp.Req.Force {
+			grp.Rsp.Chunks, err = fetchEnqueuedChunks(psid)
+			if err != nil {
+				return nil, err
+			}
+			grp.Existing = len(grp.Rsp.Chunks) != 0
+		}
+
+		grp.Rcpts, grp.Rsp.SkippedRows = filterRecipients(grp.Req.Drtv.Data)
+
+		// Map the valid recipients back to their data rows
+		for k, v := range grp.Req.Drtv.Data {
+			if phn, ok := hlprNormalizePhone(v["phonenumber"]); ok {
+				if _, fnd := grp.Rows[phn]; !fnd {
+					grp.Rows[phn] = k
+				}
+			}
+		}
+
+		grps = append(grps, &grp)
+	}
+
+	return grps, nil
+}
+
+// removeRecipient removes a recipient from a broadcast of a tag group, recording the reason
+func (grp *tagGroup) removeRecipient(phn, reason string) {
+	for i, rcp := range grp.Rcpts {
+		if rcp["phonenumber"] != phn {
+			continue
+		}
+
+		grp.Rsp.SkippedRows = append(grp.Rsp.SkippedRows, enqueueSkippedRow{
+			Row:         grp.Rows[phn],
+			DocumentID:  rcp["documentid"],
+			PhoneNumber: rcp["phonenumber"],
+			Reason:      reason,
+		})
+		grp.Rcpts = append(grp.Rcpts[:i], grp.Rcpts[i+1:]...)
+		return
+	}
+}
+
+// applyDuplicateRule handles recipients of several broadcasts of a tag group; recipients of a broadcast
+// that has already been enqueued are removed from the others unless every broadcast is sent to them
+func applyDuplicateRule(grps []*tagGroup, rule string, rsp *tagEnqueueResponse) {
+	var (
+		phns   []string                     // phone numbers in order of first appearance
+		owners = make(map[string][]int)     // phone number -> broadcasts (indexes) sent to it
+		merges = make(map[string]*tagMerge) // broadcasts (indexes) -> merged message
+	)
+
+	if rule == cntDupRuleAll {
+		return
+	}
+
+	for i, grp := range grps {
+		for _, rcp := range grp.Rcpts {
+			if len(owners[rcp["phonenumber"]]) == 0 {
+				phns = append(phns, rcp["phonenumber"])
+			}
+			owners[rcp["phonenumber"]] = append(owners[rcp["phonenumber"]], i)
+		}
+	}
+
+	for _, phn := range phns {
+		var (
+			sent  = -1 // broadcast already enqueued to the phone number
+			queue []int
+		)
+
+		if len(owners[phn]) < 2 {
+			continue
+		}
+
+		for _, i := range owners[phn] {
+			switch {
+
+			// CASE: first broadcast already enqueued to the phone number
+			case grps[i].Existing && sent == -1:
+				sent = i
+
+			// CASE: broadcast not enqueued yet
+			case !grps[i].Existing:
+				queue = append(queue, i)
+			}
+		}
+
+		switch {
+
+		// CASE: the phone number was already sent (or is only sent) the first broadcast
+		case sent != -1 || rule == cntDupRuleFirst || len(queue) < 2:
+			keep := sent
+			if keep == -1 {
+				keep = queue[0]
+			}
+			for _, i := range queue {
+				if i == keep {
+					continue
+				}
+				grps[i].removeRecipient(phn, fmt.Sprintf("%v (sent promotion send: %v)", cntSkipReasonTagDuplicate, grps[keep].Rsp.PromoSendID))
+				rsp.NumDupRemoved = rsp.NumDupRemoved + 1
+			}
+
+		// DEFAULT: merge the broadcasts into one message attributed to the first broadcast
+		default:
+			var (
+				key  = fmt.Sprint(queue)
+				rcp  map[string]string
+				ids  []string
+				msgs []string
+			)
+
+			for _, i := range queue {
+				ids = append(ids, grps[i].Rsp.PromoSendID)
+			}
+
+			mrg, fnd := merges[key]
+			if !fnd {
+				for _, i := range queue {
+					msgs = append(msgs, grps[i].Req.Drtv.Message)
+				}
+
+				mrg = &tagMerge{Drtv: grps[queue[0]].Req.Drtv}
+				mrg.Drtv.Message = strings.Join(msgs, "\n\n")
+				mrg.Drtv.MergedPromoSendIDs = ids
+				merges[key] = mrg
+				grps[queue[0]].Merged = append(grps[queue[0]].Merged, mrg)
+			}
+
+			for _, r := range grps[queue[0]].Rcpts {
+				if r["phonenumber"] == phn {
+					rcp = r
+				}
+			}
+			for _, i := range queue {
+				grps[i].removeRecipient(phn, fmt.Sprintf("%v (promotion sends: %v)", cntSkipReasonTagMerged, strings.Join(ids, ", ")))
+			}
+			mrg.Rcpts = append(mrg.Rcpts, rcp)
+			rsp.NumMerged = rsp.NumMerged + 1
+		}
+	}
+}
+
+// fitTagGroup trims the recipients of a tag group to a number of recipients, keeping the recipients of the
+// earliest created broadcasts (and their merged messages) first
+func fitTagGroup(grps []*tagGroup, num int) {
+	for _, grp := range grps {
+		if grp.Existing {
+			continue
+		}
+
+		if len(grp.Rcpts) > num {
+			grp.Dropped = grp.Dropped + len(grp.Rcpts) - num
+			grp.Rcpts = grp.Rcpts[:num]
+		}
+		num = num - len(grp.Rcpts)
+		grp.Kept = len(grp.Rcpts)
+
+		for _, mrg := range grp.Merged {
+			if len(mrg.Rcpts) > num {
+				grp.Dropped = grp.Dropped + len(mrg.Rcpts) - num
+				mrg.Rcpts = mrg.Rcpts[:num]
+			}
+			num = num - len(mrg.Rcpts)
+			grp.Kept = grp.Kept + len(mrg.Rcpts)
+		}
+	}
+}
+
+// hdlQueueTagJob handles inbound requests to enqueue the promotion broadcasts of a tag group as one
+// consolidated job plan
+func hdlQueueTagJob(c *gin.Context) {
+	var (
+		err       error
+		params    map[string]string
+		grps      []*tagGroup
+		sched     sendSchedule
+		claimed   bool
+		enqStatus string
+		numRcpts  int
+		slot      int
+		plans     []chunkPlan
+		qchk      quotaCheck
+		res       quotaReservation
+		rsp       tagEnqueueResponse
+	)
+
+	// Read and validate the request
+	params, err = readRequestParams(c)
+	if err != nil {
+		// invalid request
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	rsp.TagID = params["tagid"]
+	rsp.DuplicateRule = params["duplicaterule"]
+	if len(rsp.DuplicateRule) == 0 {
+		rsp.DuplicateRule = cntDupRuleAll
+	}
+	if rsp.DuplicateRule != cntDupRuleAll &&
+		rsp.DuplicateRule != cntDupRuleFirst &&
+		rsp.DuplicateRule != cntDupRuleMerge {
+		// invalid duplicate rule
+		appLog("ERROR: %v - invalid duplicaterule parameter: %v\n", utils.FileLine(), rsp.DuplicateRule)
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = fmt.Sprintf("invalid duplicaterule parameter: %v (expecting '%v', '%v', or '%v')",
+			rsp.DuplicateRule, cntDupRuleAll, cntDupRuleFirst, cntDupRuleMerge)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	grps, err = fetchTagGroup(rsp.TagID, params)
+	if err != nil {
+		// invalid tag group
+		appLog("ERROR: %v - invalid tag group: %v. See: %v\n", utils.FileLine(), rsp.TagID, err)
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	// The broadcasts share one schedule - taken from the request or the first broadcast's enqueue data
+	err = grps[0].Req.resolveSchedule()
+	if err != nil {
+		// invalid schedule
+		rsp.Status = cntEnqueueResultRejected
+		rsp.Msg = err.Error()
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+	if len(grps[0].Req.SendAt) != 0 {
+		rsp.SendAt = grps[0].Req.Start.Format(time.RFC3339)
+	}
+
+	// Handle recipients of several broadcasts
+	applyDuplicateRule(grps, rsp.DuplicateRule, &rsp)
+
+	// Lay the broadcasts (and their merged messages) out one after the other on one schedule
+	for _, grp := range grps {
+		if grp.Existing {
+			continue
+		}
+		numRcpts = numRcpts + len(grp.Rcpts)
+		for _, mrg := range grp.Merged {
+			numRcpts = numRcpts + len(mrg.Rcpts)
+		}
+	}
+
+	// Reserve the tag group's recipients against its owner's sending quota (the broadcasts of a tag group share an owner)
+	if numRcpts != 0 {
+		qchk, res, err = reserveQuota(grps[0].Req.Drtv.PromoSendID, numRcpts, grps[0].Req.Start)
+		if err != nil {
+			// error reserving against the sending quota
+			appLog("ERROR: %v - error reserving against the sending quota of tag group: %v. See: %v\n", utils.FileLine(), rsp.TagID, err)
+			rsp.Status = cntEnqueueResultFailed
+			rsp.Msg = fmt.Sprintf("error checking the sending quota; see: %v", err)
+			c.JSON(http.StatusInternalServerError, rsp)
+			return
+		}
+		rsp.Quota = &qchk
+		if qchk.Rejected {
+			// tag group exceeds the quota (or has no owner to check it against)
+			appLog("WARN: %v - tag group: %v not enqueued - %v\n", utils.FileLine(), rsp.TagID, qchk.Msg)
+			rsp.Status = cntEnqueueResultRejected
+			rsp.Msg = qchk.Msg
+			c.JSON(http.StatusForbidden, rsp)
+			return
+		}
+		if qchk.Dropped != 0 {
+			// queue only the recipients that fit
+			fitTagGroup(grps, qchk.Fits)
+			numRcpts = qchk.Fits
+		}
+	}
+	sched = newSendSchedule(grps[0].Req.Rate, numRcpts)
+	rsp.MsgsPerSec = sched.MsgsPerSec
+
+	for _, grp := range grps {
+		grp.Req.SendAt, grp.Req.Start, grp.Req.Rate = grps[0].Req.SendAt, grps[0].Req.Start, grps[0].Req.Rate
+		if grp.Existing {
+			continue
+		}
+
+		grp.Plan = sched.chunkAt(grp.Rcpts, grp.Req.Start, slot, 1)
+		slot = slot + len(grp.Plan)
+		plans = append(plans, grp.Plan...)
+		num := len(grp.Plan) + 1
+		for _, mrg := range grp.Merged {
+			mrg.Plan = sched.chunkAt(mrg.Rcpts, grp.Req.Start, slot, num)
+			slot = slot + len(mrg.Plan)
+			num = num + len(mrg.Plan)
+			plans = append(plans, mrg.Plan...)
+		}
+	}
+	rsp.EstFinishTime = sched.finishTime(plans).In(timePT).Format(time.RFC3339)
+
+	// Enqueue each broadcast
+	for _, grp := range grps {
+		var (
+			logLines []string
+			jbIDs    []string
+			ids      []string
+			jbID     string
+		)
+
+		grp.Rsp.MsgsPerSec = sched.MsgsPerSec
+		grp.Rsp.SendAt = rsp.SendAt
+		logLines = jobLog(logLines, "INFO: enqueuing promotion send as part of tag group: %v (duplicate rule: %v)",
+			rsp.TagID,
+			rsp.DuplicateRule)
+		logLines = append(logLines, grp.Rsp.recipientReport(len(grp.Req.Drtv.Data))...)
+
+		// Estimate the segments and cost of the broadcast's messages (and its merged messages)
+		if !grp.Existing {
+			est := estimateSegments(grp.Req.Drtv, grp.Rcpts)
+			for _, mrg := range grp.Merged {
+				est.merge(estimateSegments(mrg.Drtv, mrg.Rcpts))
+			}
+			grp.Rsp.Segments = &est
+		}
+
+		switch {
+
+		// CASE: broadcast already queued - don't push the jobs again
+		case grp.Existing:
+			for _, chk := range grp.Rsp.Chunks {
+				grp.Rsp.NumJobs = grp.Rsp.NumJobs + 1
+				grp.Rsp.NumMessages = grp.Rsp.NumMessages + chk.NumRecipients
+			}
+			grp.Rsp.Status = cntEnqueueResultExisting
+			grp.Rsp.Msg = fmt.Sprintf("promotion send %v has already been enqueued - no new jobs enqueued", grp.Rsp.PromoSendID)
+			rsp.Broadcasts = append(rsp.Broadcasts, grp.Rsp)
+			continue
+
+		// CASE: every recipient removed to fit the sending quota - leave the broadcast to be enqueued later
+		case grp.Dropped != 0 && grp.Kept == 0:
+			grp.Rsp.Status = cntEnqueueResultRejected
+			grp.Rsp.Msg = fmt.Sprintf("all %v recipients removed to fit the sending quota - no jobs enqueued", grp.Dropped)
+			logLines = jobLog(logLines, "QUOTA: %v", grp.Rsp.Msg)
+			wrtJobLog("", logLines, grp.Rsp.PromoSendID, "")
+			rsp.Broadcasts = append(rsp.Broadcasts, grp.Rsp)
+			continue
+
+		// CASE: messages over the segment limit - leave the broadcast to be enqueued later
+		case grp.Rsp.Segments.Rejected:
+			grp.Rsp.Status = cntEnqueueResultRejected
+			grp.Rsp.Msg = grp.Rsp.Segments.Warning
+			logLines = jobLog(logLines, "SEGMENTS: %v", grp.Rsp.Msg)
+			wrtJobLog("", logLines, grp.Rsp.PromoSendID, "")
+			rsp.Broadcasts = append(rsp.Broadcasts, grp.Rsp)
+			continue
+		}
+
+		// Claim the enqueue request so concurrent (retried) requests don't push the same jobs
+		claimed, enqStatus, err = claimEnqueue(grp.Req.DocID, grp.Req.Force)
+		if err != nil || !claimed {
+			// error claiming the enqueue request, or another request is queuing this broadcast
+			appLog("ERROR: %v - enqueue request: %v not claimed (status: %v). See: %v\n",
+				utils.FileLine(),
+				grp.Req.DocID,
+				enqStatus,
+				err)
+			grp.Rsp.Status = cntEnqueueResultExisting
+			grp.Rsp.Msg = fmt.Sprintf("promotion send %v is already being enqueued (status: %v)", grp.Rsp.PromoSendID, enqStatus)
+			if err != nil {
+				grp.Rsp.Status = cntEnqueueResultFailed
+				grp.Rsp.Msg = fmt.Sprintf("error claiming the enqueue request; see: %v", err)
+			}
+			rsp.Broadcasts = append(rsp.Broadcasts, grp.Rsp)
+			continue
+		}
+
+		if grp.Dropped != 0 {
+			logLines = jobLog(logLines, "QUOTA: %v recipients removed to fit the sending quota - %v", grp.Dropped, qchk.Msg)
+		}
+
+		// Post (enqueue) the broadcast's jobs and its merged message jobs
+		jbIDs, logLines = pushChunkPlan(grp.Req.DocID, grp.Req.Drtv, grp.Plan, sched, &grp.Rsp, logLines)
+		for _, mrg := range grp.Merged {
+			logLines = jobLog(logLines, "INFO: sending %v recipients one message merging promotion sends: %v",
+				len(mrg.Rcpts),
+				strings.Join(mrg.Drtv.MergedPromoSendIDs, ", "))
+			ids, logLines = pushChunkPlan(grp.Req.DocID, mrg.Drtv, mrg.Plan, sched, &grp.Rsp, logLines)
+			jbIDs = append(jbIDs, ids...)
+		}
+
+		grp.Rsp.summarize()
+		if len(grp.Plan) == 0 && len(grp.Merged) == 0 {
+			// every recipient was removed by the duplicate rule - record the broadcast as enqueued so it isn't sent later
+			err = markEnqueued(grp.Req.DocID, []string{})
+			if err != nil {
+				appLog("ERROR: %v - error recording the enqueue outcome for request: %v. See: %v\n", utils.FileLine(), grp.Req.DocID, err)
+			}
+			grp.Rsp.Status = cntEnqueueResultEnqueued
+			grp.Rsp.Msg = "no recipients remain after applying the duplicate rule - no jobs enqueued"
+		} else {
+			logLines = recordEnqueue(grp.Req, grp.Rsp.PromoSendID, jbIDs, logLines)
+		}
+		if len(jbIDs) != 0 {
+			jbID = jbIDs[len(jbIDs)-1]
+		}
+
+		// Hand the reserved recipients of the pushed jobs to the broadcast
+		err = res.take(grp.Rsp.PromoSendID, grp.Rsp.NumMessages)
+		if err != nil {
+			// error recording the quota reservation
+			appLog("ERROR: %v - error recording the quota reservation of psend: %v. See: %v\n", utils.FileLine(), grp.Rsp.PromoSendID, err)
+		}
+
+		// Write the "log lines" to the database
+		wrtJobLog("", logLines, grp.Rsp.PromoSendID, jbID)
+
+		rsp.Broadcasts = append(rsp.Broadcasts, grp.Rsp)
+	}
+
+	// Release the reserved recipients of broadcasts not pushed (rejected, claimed elsewhere or failed)
+	err = res.release()
+	if err != nil {
+		// error releasing the quota reservation
+		appLog("ERROR: %v - error releasing the quota reservation of tag group: %v. See: %v\n", utils.FileLine(), rsp.TagID, err)
+	}
+
+	// return to the caller
+	rsp.summarize()
+	appLog("INFO: %v - returning to caller with tag group: %v - %v\n",
+		utils.FileLine(),
+		rsp.TagID,
+		rsp.Msg)
+
+	if rsp.Status == cntEnqueueResultFailed {
+		c.JSON(http.StatusInternalServerError, rsp)
+		return
+	}
+	c.JSON(http.StatusOK, rsp)
+}
+
+// summarize totals the broadcasts of a tag group and sets the outcome status and message
+func (rsp *tagEnqueueResponse) summarize() {
+	var numExisting, numFailed int
+
+	for _, brd := range rsp.Broadcasts {
+		switch brd.Status {
+
+		// CASE: broadcast already queued
+		case cntEnqueueResultExisting:
+			numExisting = numExisting + 1
+
+		// CASE: broadcast failed to queue (or was rejected)
+		case cntEnqueueResultFailed, cntEnqueueResultRejected:
+			numFailed = numFailed + 1
+			rsp.NumFailedJobs = rsp.NumFailedJobs + brd.NumFailedJobs
+
+		// DEFAULT: broadcast (partially) queued
+		default:
+			rsp.NumJobs = rsp.NumJobs + brd.NumJobs
+			rsp.NumMessages = rsp.NumMessages + brd.NumMessages
+			rsp.NumFailedJobs = rsp.NumFailedJobs + brd.NumFailedJobs
+			rsp.NumPendingJobs = rsp.NumPendingJobs + brd.NumPendingJobs
+		}
+	}
+
+	switch {
+
+	// CASE: every broadcast was already queued
+	case numExisting == len(rsp.Broadcasts):
+		rsp.Status = cntEnqueueResultExisting
+
+	// CASE: no broadcast queued
+	case numFailed+numExisting == len(rsp.Broadcasts):
+		rsp.Status = cntEnqueueResultFailed
+
+	// CASE: some broadcasts or jobs failed
+	case numFailed != 0 || rsp.NumFailedJobs != 0 || rsp.NumPendingJobs != 0:
+		rsp.Status = cntEnqueueResultPartial
+
+	// DEFAULT: all broadcasts queued
+	default:
+		rsp.Status = cntEnqueueResultEnqueued
+	}
+
+	rsp.Msg = fmt.Sprintf("enqueued %v jobs sending %v messages for %v promotion sends (%v already enqueued, %v failed; %v recipients removed as duplicates, %v sent a merged message)",
+		rsp.NumJobs,
+		rsp.NumMessages,
+		len(rsp.Broadcasts),
+		numExisting,
+		numFailed,
+		rsp.NumDupRemoved,
+		rsp.NumMerged)
+}
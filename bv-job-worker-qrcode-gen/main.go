@@ -8,6 +8,7 @@ import (
 	"log"
 	"log/syslog"
 	"os"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo/gridfs"
@@ -205,6 +206,60 @@ func genQRCode(ctx worker.Context, args ...interface{}) error {
 		return fmt.Errorf("document parameter is an unexpected type or is missing")
 	}
 
+	// Are we executing an ad hoc job to create QR codes for a batch of shortcodes?
+	//   args: "adhocbatch", group (promotion send or owner id - may be empty), shortcode, shortcode, ...
+	if jid == cntAdHocBatch {
+		var errs []string
+
+		if len(args) <= 2 {
+			// shortcode parameters missing for the ad hoc batch job
+			log.Printf("ERROR: Job Id: %v - %v - 'ad hoc batch' job request but shortcode parameters missing\n",
+				ctx.Jid(),
+				utils.FileLine())
+
+			return fmt.Errorf("shortcode parameters are missing")
+		}
+
+		grp, _ := args[1].(string)
+		for _, arg := range args[2:] {
+			scde, ok = arg.(string)
+			if !ok || len(scde) == 0 {
+				// empty shortcode parameter - skip it
+				errs = append(errs, fmt.Sprintf("invalid shortcode parameter: %v", arg))
+				continue
+			}
+
+			// encode the shortcode - keep going if a shortcode fails
+			err = createQRCodeImage(scde)
+			if err != nil {
+				log.Printf("ERROR: Job Id: %v - %v - error generating the ad hoc QR code for shortcode: %v (group: '%v'). See: %v\n",
+					ctx.Jid(),
+					utils.FileLine(),
+					scde,
+					grp,
+					err)
+				errs = append(errs, fmt.Sprintf("%v: %v", scde, err))
+				continue
+			}
+
+			ctr++
+		}
+
+		log.Printf("INFO: Job Id: %v - %v - completing job: %v encoded (%v of %v) ad hoc shortcodes (group: '%v')\n",
+			ctx.Jid(),
+			utils.FileLine(),
+			ctx.JobType(),
+			ctr,
+			len(args)-2,
+			grp)
+
+		if len(errs) != 0 {
+			// fail the job so the failed shortcodes show up in Faktory
+			return fmt.Errorf("error encoding %v of %v shortcodes: %v", len(errs), len(args)-2, strings.Join(errs, "; "))
+		}
+		return nil
+	}
+
 	// Are we executing an ad hoc job to create a one off QR code?
 	if jid == "adhoc" {
 		// executing an adhoc job - check for a shortcode to encode
@@ -253,6 +308,9 @@ func genQRCode(ctx worker.Context, args ...interface{}) error {
 		return nil
 	}
 
+	// Flag the sms job's directive when this job ends (successfully or not) for the completion tracker
+	defer hlprFlagChunkDone(jid)
+
 	// Fetch the SMS Messages that have been sent from the given Faktory jobid
 	err = mgoDB.C("smsmessages").Find(bson.M{"jobid": jid}).All(&msgs)
 	if err != nil {
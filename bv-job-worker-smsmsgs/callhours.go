@@ -0,0 +1,392 @@
+// callhours.go enforces the call hours (WorkerMsgStartHour to WorkerMsgEndHour) in each recipient's local
+// timezone, worked out from the recipient's postal code or phone number area code; recipients outside their
+// call hours are deferred to a follow-up job that runs when their call hours open, and a chunk job run outside the
+// call hours of every recipient is rescheduled to run when they next open
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	faktory "github.com/contribsys/faktory/client"
+	"github.com/danoand/utils"
+	mgo "github.com/globalsign/mgo"
+	bson "github.com/globalsign/mgo/bson"
+
+	cmn "github.com/whopdan/wrkrcom"
+)
+
+// cntZoneAreaCodes lists the US area codes by the timezone most of the area code's numbers are in
+var cntZoneAreaCodes = map[string][]string{
+	"America/New_York": {
+		"201", "202", "203", "207", "212", "215", "216", "220", "223", "227", "229", "231", "234", "239", "240", "248",
+		"252", "260", "267", "269", "272", "276", "283", "301", "302", "304", "305", "313", "315", "317", "321",
+		"326", "330", "332", "336", "339", "347", "351", "352", "380", "386", "401", "404", "407", "410", "412", "413",
+		"419", "423", "434", "436", "440", "443", "445", "448", "463", "470", "475", "478", "484", "502", "508", "513",
+		"516", "517", "518", "540", "551", "561", "567", "570", "571", "574", "582", "585", "586", "603", "606", "607",
+		"609", "610", "614", "616", "617", "631", "640", "646", "656", "667", "678", "680", "681", "689", "703", "704",
+		"706", "716", "717", "718", "724", "727", "732", "734", "740", "743", "754", "757", "762", "765", "770", "771",
+		"772", "774", "781", "786", "802", "803", "804", "810", "812", "813", "814", "828", "835", "838", "839", "843",
+		"845", "848", "850", "854", "856", "857", "859", "860", "862", "863", "864", "865", "878", "904", "906", "908",
+		"910", "912", "914", "917", "919", "929", "934", "937", "941", "943", "947", "948", "954", "959", "973", "978",
+		"980", "984", "989",
+	},
+	"America/Chicago": {
+		"205", "210", "214", "217", "218", "219", "224", "225", "228", "251", "254", "256", "262", "270", "274", "281",
+		"308", "309", "312", "314", "316", "318", "319", "320", "325", "327", "331", "334", "337", "346", "353", "361", "364",
+		"402", "405", "409", "414", "417", "430", "432", "447", "464", "469", "479", "501", "504", "507", "512", "515",
+		"531", "534", "539", "557", "563", "572", "573", "580", "601", "605", "608", "612", "615", "618", "620", "629",
+		"630", "636", "641", "651", "659", "660", "662", "682", "701", "708", "712", "713", "715", "726", "730", "731",
+		"737", "763", "769", "773", "779", "785", "806", "815", "816", "817", "830", "832", "847", "870", "872", "901",
+		"903", "913", "918", "920", "924", "931", "936", "938", "940", "945", "952", "956", "972", "975", "979", "985",
+	},
+	"America/Denver":      {"208", "303", "307", "385", "406", "435", "505", "575", "719", "720", "801", "915", "970", "983", "986"},
+	"America/Phoenix":     {"480", "520", "602", "623", "928"},
+	"America/Los_Angeles": {"206", "209", "213", "253", "279", "310", "323", "341", "350", "360", "408", "415", "424", "425", "442", "458", "503", "509", "510", "530", "541", "559", "562", "564", "619", "626", "628", "650", "657", "661", "669", "702", "707", "714", "725", "747", "760", "775", "805", "818", "820", "831", "840", "858", "909", "916", "925", "949", "951", "971"},
+	"America/Anchorage":   {"907"},
+	"Pacific/Honolulu":    {"808"},
+	"America/Puerto_Rico": {"787", "939"},
+}
+
+// zipZone maps a range of (3 digit) US postal code prefixes to a timezone
+type zipZone struct {
+	lo, hi int
+	zone   string
+}
+
+// cntZipZones maps the US postal code prefixes to the timezone most of the prefix's addresses are in
+var cntZipZones = []zipZone{
+	{6, 9, "America/Puerto_Rico"},
+	{10, 323, "America/New_York"},
+	{324, 325, "America/Chicago"}, // Florida panhandle
+	{326, 349, "America/New_York"},
+	{350, 375, "America/Chicago"},
+	{376, 379, "America/New_York"}, // east Tennessee
+	{380, 397, "America/Chicago"},
+	{398, 419, "America/New_York"},
+	{420, 427, "America/Chicago"}, // west Kentucky
+	{430, 462, "America/New_York"},
+	{463, 464, "America/Chicago"}, // northwest Indiana
+	{465, 475, "America/New_York"},
+	{476, 477, "America/Chicago"}, // southwest Indiana
+	{478, 499, "America/New_York"},
+	{500, 576, "America/Chicago"},
+	{577, 577, "America/Denver"}, // west South Dakota
+	{580, 588, "America/Chicago"},
+	{590, 599, "America/Denver"},
+	{600, 689, "America/Chicago"},
+	{690, 693, "America/Denver"}, // west Nebraska
+	{700, 797, "America/Chicago"},
+	{798, 799, "America/Denver"}, // El Paso
+	{800, 834, "America/Denver"},
+	{835, 838, "America/Los_Angeles"}, // north Idaho
+	{840, 847, "America/Denver"},
+	{850, 865, "America/Phoenix"},
+	{870, 884, "America/Denver"},
+	{889, 966, "America/Los_Angeles"},
+	{967, 968, "Pacific/Honolulu"},
+	{969, 994, "America/Los_Angeles"},
+	{995, 999, "America/Anchorage"},
+}
+
+var (
+	areaCodeZones = make(map[string]string)         // timezone by area code (built from cntZoneAreaCodes)
+	zoneLocs      = make(map[string]*time.Location) // loaded timezones
+	zoneLocsMtx   sync.Mutex
+)
+
+func init() {
+	for zone, codes := range cntZoneAreaCodes {
+		for _, code := range codes {
+			areaCodeZones[code] = zone
+		}
+	}
+}
+
+// loadZone loads (and caches) a timezone; returns the Pacific timezone if the timezone can't be loaded
+func loadZone(zone string) *time.Location {
+	zoneLocsMtx.Lock()
+	defer zoneLocsMtx.Unlock()
+
+	if loc, ok := zoneLocs[zone]; ok {
+		return loc
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		// unknown timezone
+		appLog("WARN: %v - error loading timezone: %v - using: %v. See: %v\n", utils.FileLine(), zone, timeLocStr, err)
+		loc = timePT
+	}
+	zoneLocs[zone] = loc
+
+	return loc
+}
+
+// recipientZone works out the local timezone of a recipient from the recipient's postal code (if any) or the area
+// code of the recipient's phone number; returns the Pacific timezone if neither is known
+func recipientZone(rcpt map[string]string) *time.Location {
+	var zip = hlprTransPhone(rcpt["postalcode"])
+
+	if len(zip) >= 3 {
+		pfx, _ := strconv.Atoi(zip[:3])
+		for _, z := range cntZipZones {
+			if pfx >= z.lo && pfx <= z.hi {
+				return loadZone(z.zone)
+			}
+		}
+	}
+
+	phn := hlprTransPhone(rcpt["phonenumber"])
+	if len(phn) == 10 {
+		if zone, ok := areaCodeZones[phn[:3]]; ok {
+			return loadZone(zone)
+		}
+	}
+
+	return timePT
+}
+
+// callWindow returns the configured call hours (the start hour and the hour at which texts can't be sent)
+func callWindow() (int, int) {
+	start, err := strconv.Atoi(cfg.WorkerMsgStartHour)
+	if err != nil {
+		start = 8
+	}
+	end, err := strconv.Atoi(cfg.WorkerMsgEndHour)
+	if err != nil {
+		end = 20
+	}
+
+	return start, end
+}
+
+// inCallHours is a function that determines if a time is in the time window (for sending texts) of a timezone
+func inCallHours(loc *time.Location, t time.Time) bool {
+	// Checking to see if within call hours?
+	//    - no  check (send texts at any time of day)
+	//    - yes check (proceed and check time against call/text time window)
+	if !cfg.WorkerMsgCheckIfInCallHours {
+		// Call hour check disabled - send the message regardless of time (return true)
+		return true
+	}
+
+	start, end := callWindow()
+	hr := t.In(loc).Hour()
+
+	// Is the hour before the window start hour or after the window end hour?
+	return hr >= start && hr < end
+}
+
+// anyInCallHours determines if a time is in the call hours of any of a chunk's recipients
+func anyInCallHours(data []map[string]string, t time.Time) bool {
+	for _, rcpt := range data {
+		if inCallHours(recipientZone(rcpt), t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextCallHours returns the time the call hours of a timezone next open (after a time outside of them)
+func nextCallHours(loc *time.Location, t time.Time) time.Time {
+	start, _ := callWindow()
+	lcl := t.In(loc)
+
+	opn := time.Date(lcl.Year(), lcl.Month(), lcl.Day(), start, 0, 0, 0, loc)
+	if !opn.After(lcl) {
+		opn = opn.AddDate(0, 0, 1)
+	}
+
+	return opn
+}
+
+// deferRecipients queues a follow-up job directive (a copy of a chunk's job directive) texting a chunk's
+// recipients when their call hours open; the enqueue service's outbox dispatcher pushes the follow-up job
+// (scheduled via job.At) and its completion tracker waits for it. The follow-up directive is numbered after the
+// broadcast's chunks (the tracked chunk count) and flagged as deferred from the chunk. Returns the follow-up job's id.
+func deferRecipients(jdtid string, rcpts []map[string]string, at time.Time) (string, error) {
+	var (
+		err   error
+		doc   bson.M
+		psid  string
+		id    = bson.NewObjectId()
+		jid   = faktory.RandomJid()
+		psend struct {
+			NumChunks int `bson:"psendnumchunks"`
+		}
+	)
+
+	err = mgoCollWorkerJobData.Find(bson.M{"idstring": jdtid}).One(&doc)
+	if err != nil {
+		// error fetching the chunk's job directive
+		return "", fmt.Errorf("error fetching job directive: %v to defer recipients; see: %v", jdtid, err)
+	}
+	psid = fmt.Sprintf("%v", doc["promosendid"])
+	if !bson.IsObjectIdHex(psid) {
+		// job directive without a valid broadcast
+		return "", fmt.Errorf("job directive: %v has an invalid promotion broadcast id: %v", jdtid, psid)
+	}
+
+	// Have the completion tracker wait for the follow-up job, numbering it after the broadcast's chunks
+	_, err = mgoMgoCollPromoBroadcasts.FindId(bson.ObjectIdHex(psid)).Apply(
+		mgo.Change{
+			Update:    bson.M{"$inc": bson.M{"psendnumchunks": 1}, "$set": bson.M{"psendtracking": true}},
+			ReturnNew: true,
+		},
+		&psend)
+	if err != nil {
+		// error tracking the follow-up job
+		return "", fmt.Errorf("error tracking a follow-up job directive of job directive: %v; see: %v", jdtid, err)
+	}
+
+	// Drop the chunk job's outcome and outbox state
+	for _, k := range []string{"chunkresult", "chunkqrdone", "chunksnapdone", "chunkstarttime", "chunkprogress",
+		"priorjobids", "pushclaimtime", "pushedtime", "cancelled"} {
+		delete(doc, k)
+	}
+
+	doc["_id"] = id
+	doc["idstring"] = id.Hex()
+	doc["jobid"] = jid
+	doc["data"] = rcpts
+	doc["chunknumber"] = psend.NumChunks
+	doc["deferredfrom"] = jdtid
+	doc["enqueuetime"] = time.Now().In(timePT).Format(time.RFC3339Nano)
+	doc["scheduledtime"] = at.In(timePT).Format(time.RFC3339Nano)
+	doc["pushstatus"] = cntPushStatusPending
+	doc["pushat"] = at.UTC()
+	doc["pushattempts"] = 0
+	doc["pusherror"] = ""
+
+	err = mgoCollWorkerJobData.Insert(doc)
+	if err != nil {
+		// error queuing the follow-up job directive - stop the completion tracker waiting for it
+		uerr := mgoMgoCollPromoBroadcasts.UpdateId(bson.ObjectIdHex(psid), bson.M{"$inc": bson.M{"psendnumchunks": -1}})
+		if uerr != nil {
+			appLog("ERROR: %v - error untracking the follow-up job directive of job directive: %v. See: %v\n", utils.FileLine(), jdtid, uerr)
+		}
+		return "", fmt.Errorf("error queuing a follow-up job directive for job directive: %v; see: %v", jdtid, err)
+	}
+
+	return jid, nil
+}
+
+// deferredRecipients groups the recipients deferred by a chunk job (by index) by the time their call hours open
+func deferredRecipients(data []map[string]string, idxs []int, t time.Time) (map[time.Time][]map[string]string, []time.Time) {
+	var (
+		grps = make(map[time.Time][]map[string]string)
+		ats  []time.Time
+	)
+
+	for _, i := range idxs {
+		at := nextCallHours(recipientZone(data[i]), t).UTC()
+		if _, ok := grps[at]; !ok {
+			ats = append(ats, at)
+		}
+		grps[at] = append(grps[at], data[i])
+	}
+	sort.Slice(ats, func(a, b int) bool { return ats[a].Before(ats[b]) })
+
+	return grps, ats
+}
+
+// nextAnyCallHours returns the time the call hours of a chunk's first recipient (in time) next open
+func nextAnyCallHours(data []map[string]string, t time.Time) time.Time {
+	var nxt time.Time
+
+	for _, rcpt := range data {
+		at := nextCallHours(recipientZone(rcpt), t)
+		if nxt.IsZero() || at.Before(nxt) {
+			nxt = at
+		}
+	}
+
+	return nxt
+}
+
+// rescheduleDirective re-pushes the job of a job directive (run outside the call hours of every recipient) under a
+// new job id, scheduled (via job.At) to run when the call hours next open, and records the deferral on the
+// promotion broadcast; a job that fails to push is left pending for the enqueue service's outbox dispatcher (the
+// directive is claimed while it is pushed so the dispatcher doesn't push it too)
+func rescheduleDirective(psdid, jdtid, env, prior string, at time.Time) (string, error) {
+	var (
+		err error
+		job = faktory.NewJob(cmn.CntWorkerNameSendSMSMessages, psdid, jdtid, env)
+	)
+
+	job.At = at.UTC().Format(time.RFC3339Nano)
+	job.ReserveFor = cfg.WorkerJobTimeoutSec     // Assign a timeout
+	job.Queue = cmn.CntWorkerNameSendSMSMessages // Post to the "bv-job-worker-smsmsgs" queue
+	job.Retry = -1                               // Failed jobs should are not retried and moved to the 'Dead' Faktory tab
+
+	// Point the directive at the new job (the running job's start is cleared so the directive reads as scheduled)
+	err = mgoCollWorkerJobData.Update(
+		bson.M{"idstring": jdtid, "jobid": prior},
+		bson.M{
+			"$set": bson.M{
+				"jobid":         job.Jid,
+				"pushstatus":    cntPushStatusPushing,
+				"pushclaimtime": time.Now().UTC(),
+				"pushat":        at.UTC(),
+				"pushattempts":  1,
+				"pusherror":     "",
+				"scheduledtime": at.In(timePT).Format(time.RFC3339Nano),
+			},
+			"$unset": bson.M{"chunkstarttime": ""},
+			"$push":  bson.M{"priorjobids": prior},
+			"$inc":   bson.M{"numreschedules": 1},
+		})
+	if err != nil {
+		// error rescheduling the directive
+		return "", fmt.Errorf("error rescheduling job directive: %v (job: %v); see: %v", jdtid, prior, err)
+	}
+
+	// Record the deferral on the promotion broadcast
+	err = mgoMgoCollPromoBroadcasts.UpdateId(bson.ObjectIdHex(psdid), bson.M{"$push": bson.M{"psenddeferrals": bson.M{
+		"idstring":   jdtid,
+		"priorjobid": prior,
+		"jobid":      job.Jid,
+		"deferuntil": at.UTC(),
+		"time":       time.Now().UTC(),
+		"reason":     "outside the call hours of every recipient",
+	}}})
+	if err != nil {
+		// error recording the deferral - the job is rescheduled regardless
+		appLog("ERROR: %v - error recording the deferral of job directive: %v on promotion broadcast: %v. See: %v\n",
+			utils.FileLine(),
+			jdtid,
+			psdid,
+			err)
+	}
+
+	// Push the job (left pending for the outbox dispatcher if the push fails)
+	err = cmnWrkr.FakClient.Push(job)
+	if err != nil {
+		appLog("ERROR: %v - job: %v left pending for the outbox dispatcher. See: %v\n", utils.FileLine(), job.Jid, err)
+		err = mgoCollWorkerJobData.Update(bson.M{"idstring": jdtid, "jobid": job.Jid}, bson.M{"$set": bson.M{
+			"pushstatus": cntPushStatusPending,
+			"pusherror":  err.Error(),
+		}})
+		if err != nil {
+			// error returning the directive to the pending state - the enqueue service's reconciler flags it
+			appLog("ERROR: %v - error returning job directive: %v to the pending state. See: %v\n", utils.FileLine(), jdtid, err)
+		}
+		return job.Jid, nil
+	}
+
+	err = mgoCollWorkerJobData.Update(bson.M{"idstring": jdtid, "jobid": job.Jid}, bson.M{"$set": bson.M{
+		"pushstatus": cntPushStatusPushed,
+		"pushedtime": time.Now().UTC(),
+	}})
+	if err != nil {
+		// job pushed but not recorded - the enqueue service's reconciler flags the directive if it stays claimed
+		appLog("ERROR: %v - error recording job directive: %v as pushed. See: %v\n", utils.FileLine(), jdtid, err)
+	}
+
+	return job.Jid, nil
+}
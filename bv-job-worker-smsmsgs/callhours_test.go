@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// loadTestPT loads the Pacific timezone the worker falls back on (set by main at startup)
+func loadTestPT(t *testing.T) {
+	var err error
+
+	timePT, err = time.LoadLocation(timeLocStr)
+	if err != nil {
+		t.Fatalf("error loading timezone: %v: %v", timeLocStr, err)
+	}
+}
+
+func TestZoneAreaCodes(t *testing.T) {
+	var seen = make(map[string]string)
+
+	for zone, codes := range cntZoneAreaCodes {
+		if _, err := time.LoadLocation(zone); err != nil {
+			t.Errorf("area code timezone %v doesn't load: %v", zone, err)
+		}
+		for _, code := range codes {
+			if len(code) != 3 || len(hlprTransPhone(code)) != 3 {
+				t.Errorf("area code %q (%v) isn't 3 digits", code, zone)
+			}
+			if prv, ok := seen[code]; ok {
+				t.Errorf("area code %v listed under %v and %v", code, prv, zone)
+			}
+			seen[code] = zone
+		}
+	}
+	if len(areaCodeZones) != len(seen) {
+		t.Errorf("areaCodeZones has %v area codes; want %v", len(areaCodeZones), len(seen))
+	}
+}
+
+func TestZipZones(t *testing.T) {
+	for i, z := range cntZipZones {
+		if _, err := time.LoadLocation(z.zone); err != nil {
+			t.Errorf("postal code timezone %v doesn't load: %v", z.zone, err)
+		}
+		if z.lo > z.hi || z.lo < 0 || z.hi > 999 {
+			t.Errorf("postal code range %03d-%03d (%v) is invalid", z.lo, z.hi, z.zone)
+		}
+		if i > 0 && z.lo <= cntZipZones[i-1].hi {
+			t.Errorf("postal code range %03d-%03d (%v) overlaps or precedes %03d-%03d (%v)",
+				z.lo, z.hi, z.zone, cntZipZones[i-1].lo, cntZipZones[i-1].hi, cntZipZones[i-1].zone)
+		}
+	}
+}
+
+func TestRecipientZone(t *testing.T) {
+	var tests = []struct {
+		name  string
+		zip   string
+		phone string
+		zone  string
+	}{
+		{"zip new york", "10001", "", "America/New_York"},
+		{"zip plus four", "10001-1234", "", "America/New_York"},
+		{"zip florida panhandle", "32401", "", "America/Chicago"},
+		{"zip chicago", "60601", "", "America/Chicago"},
+		{"zip west south dakota", "57701", "", "America/Denver"},
+		{"zip phoenix", "85001", "", "America/Phoenix"},
+		{"zip north idaho", "83814", "", "America/Los_Angeles"},
+		{"zip honolulu", "96813", "", "Pacific/Honolulu"},
+		{"zip anchorage", "99501", "", "America/Anchorage"},
+		{"zip puerto rico", "00901", "", "America/Puerto_Rico"},
+		{"zip before area code", "94105", "2125551212", "America/Los_Angeles"},
+		{"area code new york", "", "2125551212", "America/New_York"},
+		{"area code formatted", "", "(312) 555-1212", "America/Chicago"},
+		{"area code denver", "", "303-555-1212", "America/Denver"},
+		{"short zip falls back to area code", "12", "2125551212", "America/New_York"},
+		{"unmapped zip prefix falls back to area code", "00501", "6025551212", "America/Phoenix"},
+		{"unknown area code", "", "5555551212", timeLocStr},
+		{"nothing known", "", "", timeLocStr},
+	}
+
+	loadTestPT(t)
+	for _, tt := range tests {
+		loc := recipientZone(map[string]string{"postalcode": tt.zip, "phonenumber": tt.phone})
+		if loc.String() != tt.zone {
+			t.Errorf("%v: recipientZone(zip: %q, phone: %q) = %v; want %v", tt.name, tt.zip, tt.phone, loc, tt.zone)
+		}
+	}
+}
+
+func TestNextCallHours(t *testing.T) {
+	var tests = []struct {
+		name string
+		zone string
+		at   string // time outside the call hours (RFC3339)
+		want string // time the call hours open (RFC3339, UTC)
+	}{
+		{"before opening", "America/Chicago", "2026-10-16T06:59:00-05:00", "2026-10-16T13:00:00Z"},
+		{"after closing", "America/Chicago", "2026-10-16T21:00:00-05:00", "2026-10-17T13:00:00Z"},
+		{"utc date ahead of local date", "America/Los_Angeles", "2026-10-17T02:00:00Z", "2026-10-17T15:00:00Z"},
+		{"utc date ahead of local date east", "America/New_York", "2026-10-17T03:30:00Z", "2026-10-17T12:00:00Z"},
+		{"dst starts overnight", "America/New_York", "2026-03-07T23:00:00-05:00", "2026-03-08T12:00:00Z"},
+		{"dst starts the same morning", "America/New_York", "2026-03-08T01:00:00-05:00", "2026-03-08T12:00:00Z"},
+		{"dst ends the same morning", "America/Los_Angeles", "2026-11-01T00:30:00-07:00", "2026-11-01T16:00:00Z"},
+		{"dst ends overnight", "America/Los_Angeles", "2026-10-31T22:00:00-07:00", "2026-11-01T16:00:00Z"},
+		{"no dst", "America/Phoenix", "2026-07-01T22:00:00-07:00", "2026-07-02T15:00:00Z"},
+		{"month and year rollover", "Pacific/Honolulu", "2026-12-31T22:00:00-10:00", "2027-01-01T18:00:00Z"},
+	}
+
+	defer func(spec Specification) { cfg = spec }(cfg)
+	cfg.WorkerMsgStartHour = "08"
+	cfg.WorkerMsgEndHour = "20"
+
+	for _, tt := range tests {
+		loc, err := time.LoadLocation(tt.zone)
+		if err != nil {
+			t.Fatalf("%v: error loading timezone: %v: %v", tt.name, tt.zone, err)
+		}
+		at, err := time.Parse(time.RFC3339, tt.at)
+		if err != nil {
+			t.Fatalf("%v: error parsing time: %v: %v", tt.name, tt.at, err)
+		}
+
+		got := nextCallHours(loc, at)
+		if got.UTC().Format(time.RFC3339) != tt.want {
+			t.Errorf("%v: nextCallHours(%v, %v) = %v; want %v", tt.name, tt.zone, tt.at, got.UTC().Format(time.RFC3339), tt.want)
+		}
+		if got.In(loc).Hour() != 8 {
+			t.Errorf("%v: nextCallHours(%v, %v) = %v; want 08:00 local", tt.name, tt.zone, tt.at, got.In(loc))
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 const (
 	cntAppEnvDev   = "development"
 	cntAppEnvStage = "staging"
@@ -11,8 +13,61 @@ const (
 	cntPromoSendNotSent = "not sent"
 	cntPromoSendSent    = "sent"
 
+	// Outcomes of a chunk job recorded on its job directive (read by the enqueue service's completion tracker)
+	cntChunkStatusDone   = "done"   // every recipient processed
+	cntChunkStatusHalted = "halted" // job halted (or cancelled) before processing every recipient
+	cntChunkStatusFailed = "failed" // job ended due to an error
+
+	cntChunkStatusRescheduled = "rescheduled" // job re-pushed to run when the call hours open (no outcome is recorded)
+
+	// Outcomes of a chunk job's recipient checkpointed on its job directive
+	cntRcptSent       = "sent"       // text accepted by the SMS provider
+	cntRcptFailed     = "failed"     // text rejected by (or not posted to) the SMS provider
+	cntRcptSkipped    = "skipped"    // recipient skipped (e.g. invalid phone number)
+	cntRcptStopListed = "stoplisted" // recipient on the stop list
+	cntRcptDeferred   = "deferred"   // recipient outside their call hours - deferred to a follow-up job
+
+	cntPushStatusPending = "pending" // job directive's job not pushed yet - pushed by the enqueue service's outbox dispatcher
+	cntPushStatusPushing = "pushing" // job directive's job is being pushed
+	cntPushStatusPushed  = "pushed"  // job directive's job pushed to Faktory
+
+	// SMS providers (carriers) sending the text messages (see WorkerSMSProvider)
+	cntSMSProviderTwilio  = "twilio"         // Twilio's Messages API
+	cntSMSProviderFake    = "fake"           // in-memory fake (no text messages are sent)
+	cntSMSProviderTimeout = 30 * time.Second // timeout of a request to an SMS provider
+
+	cntTwilioMessagesURL     = "https://api.twilio.com/2010-04-01/Accounts/%v/Messages.json" // Twilio Messages resource of an account
+	cntTwilioErrUnsubscribed = "21610"                                                       // Twilio error: recipient unsubscribed (replied STOP)
+
+	// Classes of an SMS provider's send outcome
+	cntSMSErrNone      = ""          // message accepted
+	cntSMSErrTransient = "transient" // temporary failure before the message reached the provider (e.g. connection refused, rate limited, provider unavailable) - retried
+	cntSMSErrUnknown   = "unknown"   // no outcome - the provider may have accepted the message (e.g. timeout, provider error) - not retried so it isn't texted twice
+	cntSMSErrPermanent = "permanent" // message rejected (e.g. a problem with the sending number)
+	cntSMSErrBadNumber = "badnumber" // message rejected because of the recipient's number (e.g. invalid or landline) - skipped by future broadcasts
+	cntSMSErrOptOut    = "optout"    // recipient opted out of texts from the sending number
+
+	cntSendRetryMaxWait = 30 * time.Second // longest wait between retries of a send failing with a transient error (cut short by a halt)
+
+	// Delivery statuses reported by Twilio's status callbacks
+	cntDeliveryQueued      = "queued"
+	cntDeliverySending     = "sending"
+	cntDeliverySent        = "sent"
+	cntDeliveryDelivered   = "delivered"
+	cntDeliveryUndelivered = "undelivered"
+	cntDeliveryFailed      = "failed"
+
+	// Actions requested by the keywords recipients text back (see modelStopPhoneList.go)
+	cntKeywordOptOut = "optout" // stop texting the number (e.g. STOP)
+	cntKeywordOptIn  = "optin"  // resume texting the number (e.g. START)
+	cntKeywordHelp   = "help"   // reply with help details (e.g. HELP)
+
 	cntDefaultObjectID string = "886e09000000000000000000" // Default bson.ObjectId string value
 
+	// Shortcodes of the shortlinks texted (see modelPromoCustomerLink.go)
+	cntShortCodeLen      = 7 // random characters of a shortcode (not counting a check character)
+	cntShortCodeMaxTries = 5 // attempts to store a shortlink with a unique shortcode
+
 	// MongoDB database collections
 	cntMgoCollSMS      = "smsmessages"
 	cntMgoCollPSend    = "promobroadcasts"
@@ -25,6 +80,23 @@ const (
 	cntEventSendSMSMessage  = "smsmessage_sent" // sms message 'sent' event
 )
 
+// cntDeliveryRanks orders the delivery statuses by progress (a final status outranks the statuses before it)
+var cntDeliveryRanks = map[string]int{
+	cntDeliveryQueued:      1,
+	cntDeliverySending:     2,
+	cntDeliverySent:        3,
+	cntDeliveryDelivered:   4,
+	cntDeliveryUndelivered: 4,
+	cntDeliveryFailed:      4,
+}
+
+// cntKeywordEventTypes maps keyword actions to the promoCustomerTextEvent event type logged for them
+var cntKeywordEventTypes = map[string]string{
+	cntKeywordOptOut: "text_optout",
+	cntKeywordOptIn:  "text_optin",
+	cntKeywordHelp:   "text_help",
+}
+
 var cntPromoSendStatuses = []string{
 	cntPromoSendNotSent,
 	cntPromoSendSent,
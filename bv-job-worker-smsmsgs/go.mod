@@ -8,10 +8,12 @@ require (
 	github.com/contribsys/faktory v1.3.0-1
 	github.com/contribsys/faktory_worker_go v1.4.0
 	github.com/danoand/utils v0.0.0-20200425204603-d9dbbe724b40
-	github.com/davecgh/go-spew v1.1.1
 	github.com/gin-gonic/gin v1.6.3
 	github.com/globalsign/mgo v0.0.0-20181015135952-eeefdecb41b8
 	github.com/jasonlvhit/gocron v0.0.0-20200423141508-ab84337f7963
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/whopdan/bv-common v0.0.0-00010101000000-000000000000
 	github.com/whopdan/wrkrcom v0.0.0-20200706003756-cc718c42ed4e
 )
+
+replace github.com/whopdan/bv-common => ../bv-common
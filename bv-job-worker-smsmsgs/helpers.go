@@ -1,8 +1,13 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"fmt"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -67,3 +72,27 @@ func hlprIsOneOfStr(tst string, set []string) (rbool bool) {
 
 	return
 }
+
+// hlprTwilioSignature computes Twilio's signature of a webhook request (see Twilio's "Webhooks Security"): the
+// base64 encoded HMAC-SHA1, keyed with the account's auth token, of the url followed by each posted parameter
+// name and value (sorted by name, then value)
+func hlprTwilioSignature(token, rawURL string, params url.Values) string {
+	var keys []string
+
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha1.New, []byte(token))
+	mac.Write([]byte(rawURL))
+	for _, k := range keys {
+		vals := append([]string(nil), params[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			mac.Write([]byte(k + v))
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
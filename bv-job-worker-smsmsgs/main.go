@@ -7,12 +7,11 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/gin-gonic/gin"
 	mgo "github.com/globalsign/mgo"
 	bson "github.com/globalsign/mgo/bson"
@@ -23,13 +22,15 @@ import (
 	"github.com/danoand/utils"
 	"github.com/kelseyhightower/envconfig"
 
+	"github.com/whopdan/bv-common/hmacauth"
+	"github.com/whopdan/bv-common/msgtemplate"
+	"github.com/whopdan/bv-common/smssegments"
 	cmn "github.com/whopdan/wrkrcom"
 )
 
 const (
 	appName = "NQJOB"
 
-	cntThreePipesPlaceholder     string = "|||"                           // placeholder that is ultimately replaced by a unique shortlink
 	cntTwilioSkipStopPhoneNumber string = "twilio_skip_stop_phone_number" // send event to skip a text to a (stop) phone number
 )
 
@@ -47,13 +48,8 @@ var (
 	mgoCollShortLinkStore     *mgo.Collection
 	mgoMgoCollPromoCustTexts  *mgo.Collection
 	mgoCollStopPhoneList      *mgo.Collection
-
-	// Twilio variables
-	twilioURLElement1         = "https://"
-	twilioURLElement2         = ":"
-	twilioURLElement3         = "@api.twilio.com/2010-04-01/Accounts/"
-	twilioURLElement4         = "/Messages.json"
-	twilioConstructMessageURL string
+	mgoCollTextEvents         *mgo.Collection
+	mgoCollUndeliverable      *mgo.Collection
 
 	// Time location
 	timePT     *time.Location
@@ -182,15 +178,46 @@ type Specification struct {
 	WorkerProgressInterval      int    `default:"100"`   // Text message interval used to report progress
 	WorkerStubTwilio            bool   `default:"false"` // false: MEANS SENDING LIVE TWILIO MESSAGES!
 	WorkerStubTwilioTestURL     string `default:"https://echo.danocloud.com/stubtwilio"`
+	WorkerSMSProvider           string `default:"twilio"`       // provider sending the text messages: 'twilio' or 'fake' (in-memory, nothing is sent)
+	WorkerStatusCallbackURL     string `required:"false"`       // public url of the /twilio/status route Twilio reports delivery statuses to (empty: no status callbacks)
+	WorkerInboundSMSURL         string `required:"false"`       // public url of the /twilio/inbound route Twilio posts texts sent to our numbers to (empty: no keyword handling)
 	WorkerMsgCheckIfInCallHours bool   `default:"false"`        // false: don't check if within call hours (i.e. send text at any time of day); true: check if within call hours
 	WorkerMsgStartHour          string `default:"08"`           // hour of 24 hour day at which texts can be sent   (e.g. 8am or '08')
 	WorkerMsgEndHour            string `default:"20"`           // hour of 24 hour day at which texts can't be sent (e.g. 10pm or '22')
 	WorkerInternalBVTestFlag    string `default:"96JCAKZ7(7DN"` // string in the message text that indicates the text is an internal BV test
 	WorkerJobTimeoutSec         int    `default:"7200"`         // job timeout in seconds
+	WorkerSMSConcurrency        int    `default:"5"`            // chunk jobs run at the same time (bv-job-queue schedules its chunks for the same setting)
 	ShortLinkBaseURL            string `default:"http://bdvi.be/x"`
 	ShortLinkBaseURLDev         string `default:"http://localhost:8080/x"`
 	ShortLinkBaseURLStg         string `default:"http://staging.bdvi.be/x"`
 	WorkerRedisURL              string `required:"false"`
+	WorkerHaltAuthKeyID         string `required:"false"` // key id signing requests to the halt job check service (empty: unsigned)
+	WorkerHaltAuthSecret        string `required:"false"` // HMAC secret signing requests to the halt job check service
+
+	// Request authentication of the /fakemessages route (see bv-common's hmacauth package; no keys: every request is rejected)
+	WorkerAuthKeys      map[string]string // caller key ids and HMAC secrets signing requests (e.g. 'ops:secret1')
+	WorkerAuthWindowSec int               `default:"300"` // seconds a signed request remains valid (replay window)
+
+	// Replies confirming the keywords texted to our numbers, returned as TwiML by the /twilio/inbound route (empty: no reply)
+	WorkerCarrierOptOut bool   `default:"true"` // Twilio's (carrier-managed) opt-out handling is active and replies to the keywords itself - no replies are returned
+	WorkerOptOutReply   string `default:"You are unsubscribed and will receive no further messages. Reply START to resubscribe."`
+	WorkerOptInReply    string `default:"You are resubscribed to text messages. Msg and data rates may apply. Reply STOP to unsubscribe, HELP for help."`
+	WorkerHelpReply     string `default:"Promotional text messages. Msg and data rates may apply. Reply STOP to unsubscribe."`
+
+	// Retries of a send failing with a transient error (e.g. rate limited or a provider outage)
+	WorkerSendMaxRetries  int `default:"2"`    // retries of a send (0: no retries)
+	WorkerSendRetryBaseMs int `default:"1000"` // wait in milliseconds before the first retry (doubled for each retry)
+
+	// Segments a message may take before a warning is logged (0: no limit; the queue applies the segment policy)
+	WorkerMaxSegments int `default:"0"`
+
+	// Pace of the texts a chunk job sends (see ratelimit.go)
+	WorkerSendRatePerSec  float64 `default:"0"` // texts sent per second (0: one text every WorkerSMSDelaySec; a scheduled interval overrides)
+	WorkerSendBurst       int     `default:"1"` // texts that can be sent at once after an idle spell
+	WorkerSendConcurrency int     `default:"4"` // senders texting concurrently (each waits on the rate limiter)
+
+	// Append a check character to the shortcodes (lets a POS catch a mistyped code; see modelPromoCustomerLink.go)
+	WorkerShortCodeCheckChar bool `default:"false"`
 }
 
 // ProcessDirective houses the job instructions
@@ -202,6 +229,11 @@ type ProcessDirective struct {
 	Data                   []map[string]string `bson:"data" json:"data"`                                     // data being processed
 	IsXPathwayGenericPromo bool                `bson:"isxpathwaygenericpromo" json:"isxpathwaygenericpromo"` // indicates if the underlying promotion is an xPathway generic promotion
 	TwilioOverridePhoneNum string              `bson:"twiliooverridephonenum" json:"twiliooverridephonenum"` // override the default Twilio Number with this number (if valid)
+	MsgIntervalMs          int                 `bson:"msgintervalms" json:"msgintervalms"`                   // time in milliseconds between texts set by the enqueue scheduler (0: use WorkerSMSDelaySec)
+	Cancelled              bool                `bson:"cancelled" json:"cancelled"`                           // indicates the broadcast (job) was cancelled via the enqueue service
+	ChunkProgress          chunkProgress       `bson:"chunkprogress" json:"chunkprogress"`                   // per recipient progress checkpointed by the job (a resumed job skips the recipients handled)
+	OwnerName              string              `bson:"ownername" json:"ownername"`                           // name of the broadcast's owner (e.g. dispensary) - {{.ownername}} in a message template
+	PromoExpiry            string              `bson:"promoexpiry" json:"promoexpiry"`                       // expiry date of the broadcast's promotion - {{.promoexpiry}} in a message template
 }
 
 // WorkerJobLogLine models a line to be logged capturing detail of a job run
@@ -220,30 +252,49 @@ type WorkerHaltResponse struct {
 }
 
 // stopWorker is a function that checks a shared Heroku Redis instance to determine
-//   if the worker job should not start or be halted (true = halt job)
+//   if the worker job should not start or be halted (true = halt job); a halt check the
+//   service refuses or fails to answer (e.g. 401 or 5xx) halts the job rather than risk texting
 func stopWorker(keys []string) bool {
 	var (
 		err    error
 		dat    interface{}
 		bbytes []byte
-		brdr   *bytes.Reader
+		req    *http.Request
 		rsp    *http.Response
 		rslt   WorkerHaltResponse
 	)
 
 	dat = append([]string{cntAppEnvProd}, keys...)
 
-	brdr, err = utils.ToJSONReader(&dat)
+	_, bbytes, err = utils.ToJSON(&dat)
 	if err != nil {
-		// error occurred translating a json object to a reader
-		log.Printf("ERROR: %v - error occurred translating a json object to a reader. See: %v\n",
+		// error occurred translating a json object to bytes
+		log.Printf("ERROR: %v - error occurred translating a json object to bytes. See: %v\n",
 			utils.FileLine(),
 			err)
 		return false
 	}
 
+	req, err = http.NewRequest(http.MethodPost, cfg.WorkerHaltJobCheckURL, bytes.NewReader(bbytes))
+	if err != nil {
+		// error occurred creating the halt job check request
+		log.Printf("ERROR: %v - error occurred creating the halt job check request. See: %v\n",
+			utils.FileLine(),
+			err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = hmacauth.Sign(req, bbytes, cfg.WorkerHaltAuthKeyID, cfg.WorkerHaltAuthSecret)
+	if err != nil {
+		// error signing the halt job check request - the service would reject it
+		log.Printf("ERROR: %v - error signing the halt job check request - halting. See: %v\n",
+			utils.FileLine(),
+			err)
+		return true
+	}
+
 	// Call out to the halt job check service
-	rsp, err = http.Post(cfg.WorkerHaltJobCheckURL, "application/json", brdr)
+	rsp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		// error occurred making call to the halt job check service
 		log.Printf("ERROR: %v - error occurred making call to the halt job check service. See: %v\n",
@@ -251,6 +302,15 @@ func stopWorker(keys []string) bool {
 			err)
 		return false
 	}
+	defer rsp.Body.Close()
+
+	// Halt check refused (e.g. 401: request not authenticated) or failed (5xx)? Halt rather than carry on texting
+	if rsp.StatusCode != http.StatusOK {
+		log.Printf("ERROR: %v - halt job check service responded with status: %v - halting\n",
+			utils.FileLine(),
+			rsp.Status)
+		return true
+	}
 
 	// Read the response body from the halt service
 	bbytes, err = ioutil.ReadAll(rsp.Body)
@@ -302,31 +362,19 @@ func setPSendHalted(id, msg string) error {
 	return nil
 }
 
-// inCallHours is a function that determines if the current time is in a time window (for sending texts) in the Pacific Timezone
-func inCallHours() bool {
-	// Checking to see if within call hours?
-	//    - no  check (send texts at any time of day)
-	//    - yes check (proceed and check time against call/text time window)
-	if !cfg.WorkerMsgCheckIfInCallHours {
-		// Call hour check disabled - send the message regardless of time (return true)
-		return true
-	}
-
-	// Get the current time in the Pacific timezone
-	crt := time.Now().In(timePT).Format(time.RFC3339Nano)
-	crtHour := crt[11:13]
-
-	// Is the current hour before the window start hour or after the window end hour?
-	//   before the start hour - don't send text
-	//   after the end hour    - don't send text
-	if crtHour < cfg.WorkerMsgStartHour || crtHour >= cfg.WorkerMsgEndHour {
-		// current hour is outside the call time window
-		appLog("INFO: %v - current hour is outside the call time window\n", utils.FileLine())
+// drtvCancelled is a function that checks if a job directive has been cancelled (via the enqueue service)
+func drtvCancelled(jdtid string) bool {
+	num, err := mgoCollWorkerJobData.Find(bson.M{"idstring": jdtid, "cancelled": true}).Count()
+	if err != nil {
+		// error checking if the job directive has been cancelled
+		appLog("ERROR: %v - error checking if job directive: %v has been cancelled. See: %v\n",
+			utils.FileLine(),
+			jdtid,
+			err)
 		return false
 	}
 
-	// Inside the call window
-	return true
+	return num != 0
 }
 
 // logSkipStopPhoneNumber is a function that sends an event to the web app to log skipping a stop phone number (ie. text not sent out to that number)
@@ -380,26 +428,45 @@ func logSkipStopPhoneNumber(twlPhnNum string) {
 	return
 }
 
+// sendTask models a text message handed by a chunk job to its pool of senders
+type sendTask struct {
+	i       int                  // index of the recipient (in the job directive's data)
+	req     smsRequest           // text message sent via the SMS provider
+	seg     smssegments.Segments // encoding and segments of the message
+	shrtlnk *shortLinkObj        // shortlink generated for the message
+}
+
 // wkrFirePromoSendSMSMsgs is a Faktory "job" that sends out a set of SMS messages associated
 //   ... with a promotion broadcast
 func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 	var (
-		err              error
-		jberr            error // error returned when job ends
-		flgGenPromo      bool
-		loglines         []string
-		psdid, jdtid     string
-		phn, msg, reqURL string
-		limit, ntrvlCtr  int
-		drtv             ProcessDirective
-		resp             *http.Response
-		bBytes           []byte
-		dur              = time.Duration(cfg.WorkerSMSDelaySec) * time.Second
-		shrtlnk          *shortLinkObj
-		job              *faktory.Job
-		cache            *bigcache.BigCache
-		mapCacheElms     []map[string]interface{}
-		byteOne          = []byte("1")
+		err             error
+		jberr           error // error returned when job ends
+		flgGenPromo     bool
+		loglines        []string
+		psdid, jdtid    string
+		phn, msg        string
+		limit, ntrvlCtr int
+		nsnd            int     // senders texting concurrently
+		rate            float64 // texts sent per second
+		drtv            ProcessDirective
+		mt              *msgtemplate.Template // text message (rendered for each recipient)
+		jbRslt          chunkResult           // outcome of the job recorded on its job directive
+		smsReq          smsRequest            // text message sent via the SMS provider
+		mtx             sync.Mutex            // guards the job result counted by the senders
+		wg              sync.WaitGroup
+		lmtr            *tokenBucket // rate limiter pacing the senders
+		tasks           = make(chan sendTask)
+		halt            = make(chan struct{}) // closed when the job is halted
+		rcptLogs        [][]string            // log lines of each recipient (by index) - written in order when the senders finish
+		shrtlnk         *shortLinkObj
+		job             *faktory.Job
+		cache           *bigcache.BigCache
+		mapCacheElms    []map[string]interface{}
+		undlvr          map[string]bool // phone numbers rejected as undeliverable by earlier broadcasts
+		dfrd            []int           // recipients (by index) outside their call hours - deferred to follow-up jobs
+		bvTest          bool
+		byteOne         = []byte("1")
 	)
 
 	// TODO: Note - looks like Faktory worker migrated to go Context contexts
@@ -430,6 +497,9 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 	// Append the job name and error disposition to the database
 	defer logJobName(psdid, ctx.Jid(), cfg.WorkerCurEnv, &jberr)
 
+	// Record the outcome of the job for the enqueue service's completion tracker
+	defer recordChunkResult(jdtid, &jbRslt, &jberr)
+
 	// Check if job should be halted
 	if stopWorker([]string{cfg.WorkerCurEnv, psdid, ctx.Jid()}) {
 		appLog("HALT: %v - halting job: %v before it starts\n",
@@ -448,6 +518,7 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 				err)
 		}
 		jberr = fmt.Errorf("job halted before processing began")
+		jbRslt.Status = cntChunkStatusHalted
 
 		return jberr
 	}
@@ -470,6 +541,9 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 		return jberr
 	}
 
+	// Record the start of the job (reported by the enqueue service's progress route)
+	recordChunkStart(jdtid)
+
 	// Query the database for the job directive
 	err = mgoCollWorkerJobData.Find(bson.M{"idstring": jdtid}).One(&drtv)
 	if err != nil {
@@ -492,6 +566,38 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 		drtv.PromoSendID,
 		drtv.TwilioOverridePhoneNum)
 
+	// Has the broadcast (job) been cancelled before it started?
+	if drtv.Cancelled {
+		appLog("HALT: %v - job: %v was cancelled before it started\n",
+			utils.FileLine(),
+			ctx.Jid())
+		loglines = jobLog(loglines, "halting job: %v - job was cancelled before processing began", ctx.Jid())
+		wrtJobLog(loglines, psdid, ctx.Jid(), cfg.WorkerCurEnv) // Write to the Promotion Send's log
+		jberr = fmt.Errorf("job cancelled before processing began")
+		jbRslt.Status = cntChunkStatusHalted
+
+		return jberr
+	}
+
+	// Resuming the job? Count the outcomes of the recipients handled by the earlier run(s) (they are skipped)
+	if len(drtv.ChunkProgress.Outcomes) != 0 {
+		for _, o := range drtv.ChunkProgress.Outcomes {
+			jbRslt.count(o.Outcome)
+		}
+		loglines = jobLog(loglines, "resuming job: %v of %v recipients were handled by an earlier run (last: #%v)",
+			len(drtv.ChunkProgress.Outcomes),
+			len(drtv.Data),
+			drtv.ChunkProgress.LastIndex)
+	}
+
+	// rcptDone counts the outcome of a recipient and checkpoints it on the job directive
+	rcptDone := func(i int, outcome string) {
+		mtx.Lock()
+		jbRslt.count(outcome)
+		mtx.Unlock()
+		recordRecipient(jdtid, i, drtv.Data[i]["phonenumber"], outcome)
+	}
+
 	// Validate the job data - missing execution data?
 	if len(drtv.Data) == 0 || len(drtv.Message) == 0 || len(drtv.PromoSendID) == 0 {
 		// missing job data
@@ -510,6 +616,7 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 	if len(drtv.Data) > cfg.WorkerMsgThreshold {
 		// data contains more the the allowed number of customers; capping at the threshold limit
 		limit = cfg.WorkerMsgThreshold
+		jbRslt.Skipped = jbRslt.Skipped + len(drtv.Data) - limit
 		appLog("WARN: %v - JobId: %v - %v: %v\n",
 			utils.FileLine(),
 			ctx.Jid(),
@@ -520,22 +627,73 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 		loglines = jobLog(loglines, "data contains more the the allowed number of customers; capping at the threshold limit")
 	}
 
+	rcptLogs = make([][]string, limit)
+
+	// Pace the texts at the configured rate (one text every WorkerSMSDelaySec if not set), or at the interval
+	// scheduled for this job (if any)
+	rate = cfg.WorkerSendRatePerSec
+	if rate <= 0 && cfg.WorkerSMSDelaySec > 0 {
+		rate = 1 / float64(cfg.WorkerSMSDelaySec)
+	}
+	if drtv.MsgIntervalMs > 0 {
+		rate = 1000 / float64(drtv.MsgIntervalMs)
+		loglines = jobLog(loglines, "sending texts at a scheduled interval of: %v", time.Duration(drtv.MsgIntervalMs)*time.Millisecond)
+	}
+	lmtr = newTokenBucket(rate, cfg.WorkerSendBurst)
+	nsnd = cfg.WorkerSendConcurrency
+	if nsnd < 1 {
+		nsnd = 1
+	}
+	loglines = jobLog(loglines, "sending texts with %v concurrent sender(s) at up to %.2f texts per second", nsnd, rate)
+
 	// Is this text message "firing" associated with an xPathway generic promotion?
 	flgGenPromo = drtv.IsXPathwayGenericPromo
 	if flgGenPromo {
 		loglines = jobLog(loglines, "processing an xPathway generic promotion")
 	}
 
+	// Parse the text message (a message template is rendered for each recipient)
+	mt, err = msgtemplate.Parse(drtv.Message, drtv.IsXPathwayGenericPromo)
+	if err != nil {
+		// invalid message template
+		appLog("ERROR: %v - JobId: %v - %v\n", utils.FileLine(), ctx.Jid(), err)
+
+		loglines = jobLog(loglines, "%v", err)
+		loglines = jobLog(loglines, "Job: %v ending due to an error", ctx.Jid())
+		wrtJobLog(loglines, psdid, ctx.Jid(), cfg.WorkerCurEnv) // Write to the Promotion Send's log
+		jberr = err
+		return jberr
+	}
+
 	// SHOULD JOB BE HALTED?
-	//   1. ARE WE OUT OF THE CALL HOURS? and
+	//   1. ARE WE OUT OF THE CALL HOURS OF EVERY RECIPIENT? and
 	//   2. THIS IS NOT AN INTERNAL BROADVIBE TEST (internal Broadvibe tests are excepted from the call hour restriction... use wisely!)
-	if !inCallHours() && !isBVTest(drtv.Message, cfg.WorkerCurEnv) {
-		// not in call window - halt (don't run) the job
-		appLog("INFO: %v - halting job: %v current time is outside of the call hours\n", utils.FileLine(), ctx.Jid())
-		loglines = jobLog(loglines, "halting job: %v current time is outside of the call hours", ctx.Jid())
-		jberr = fmt.Errorf("job is running outside of the call hours")
+	bvTest = isBVTest(drtv.Message, cfg.WorkerCurEnv)
+	if !anyInCallHours(drtv.Data[:limit], time.Now()) && !bvTest {
+		// not in the call window of any recipient - reschedule the job to run when the call hours next open
+		at := nextAnyCallHours(drtv.Data[:limit], time.Now())
+		appLog("INFO: %v - rescheduling job: %v current time is outside of the call hours of every recipient\n", utils.FileLine(), ctx.Jid())
 
-		return jberr
+		njid, err := rescheduleDirective(psdid, jdtid, drtv.Environment, ctx.Jid(), at)
+		if err != nil {
+			// error rescheduling the job - halt (don't run) the job
+			appLog("ERROR: %v - JobId: %v - %v\n", utils.FileLine(), ctx.Jid(), err)
+			loglines = jobLog(loglines, "halting job: %v current time is outside of the call hours of every recipient (error rescheduling: %v)", ctx.Jid(), err)
+			wrtJobLog(loglines, psdid, ctx.Jid(), cfg.WorkerCurEnv) // Write to the Promotion Send's log
+			jberr = fmt.Errorf("job is running outside of the call hours")
+			jbRslt.Status = cntChunkStatusHalted
+
+			return jberr
+		}
+
+		loglines = jobLog(loglines, "DEFERRED: job: %v is outside of the call hours of every recipient - rescheduled as job: %v at: %v",
+			ctx.Jid(),
+			njid,
+			at.In(timePT).Format(time.RFC3339))
+		wrtJobLog(loglines, psdid, ctx.Jid(), cfg.WorkerCurEnv) // Write to the Promotion Send's log
+		jbRslt.Status = cntChunkStatusRescheduled
+
+		return nil
 	}
 
 	// Construct a cache to house stop phone numbers
@@ -624,23 +782,189 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 		"creating a cache of stop phone numbers with a number of entries:",
 		cache.Len())
 
+	// Fetch the phone numbers rejected as undeliverable by earlier broadcasts (not fatal - they are texted)
+	undlvr, err = fetchUndeliverablePhones()
+	if err != nil {
+		appLog("ERROR: %v - JobId: %v - %v\n", utils.FileLine(), ctx.Jid(), err)
+		loglines = jobLog(loglines, "error fetching the undeliverable phone numbers - continue processing")
+	}
+
 	// TODO: Future code - make sure there are no other "bv-job-worker-smsmsgs" jobs are currently running for this account
 
-	// Iterate through the message data
+	// dropShortLink removes the shortlink generated for a text message that is never sent
+	dropShortLink := func(sl *shortLinkObj) {
+		err := sl.removeFromDB()
+		if err != nil {
+			appLog("ERROR: %v - JobId: %v - %v\n", utils.FileLine(), ctx.Jid(), err)
+		}
+	}
+
+	// sendText sends a text message handed to the pool of senders, counts its outcome and persists it as an
+	// smsmessage (run concurrently by the senders - the recipient's log lines are kept apart in rcptLogs)
+	sendText := func(t sendTask) {
+		var (
+			err    error
+			errCls string
+			sndAtt int
+			smsRsp smsResponse
+			lines  = rcptLogs[t.i]
+		)
+		defer func() { rcptLogs[t.i] = lines }()
+
+		// Send the text message via the SMS provider (retrying a transient failure)
+		smsRsp, errCls, sndAtt, err = sendWithRetry(smsPrvdr, t.req, halt)
+		if errCls == cntSMSErrTransient {
+			select {
+			case <-halt:
+				// halted while waiting to retry - the text wasn't accepted; leave it for a resumed run
+				lines = jobLog(lines, fmt.Sprintf("text message to: %v not sent - job halted while retrying (attempts: %v)",
+					drtv.Data[t.i]["phonenumber"], sndAtt))
+				dropShortLink(t.shrtlnk)
+				return
+			default:
+			}
+		}
+		if err != nil {
+			appLog("ERROR: %v - JobId: %v - error sending text message via: %v (attempts: %v). See: %v\n",
+				utils.FileLine(),
+				ctx.Jid(),
+				smsPrvdr.name(),
+				sndAtt,
+				err)
+
+			lines = jobLog(lines,
+				fmt.Sprintf("error sending text message: %v via %v (%v, attempts: %v) - %v",
+					drtv.Data[t.i]["phonenumber"], smsPrvdr.name(), errCls, sndAtt, err))
+
+			rcptDone(t.i, cntRcptFailed)
+			return
+		}
+
+		appLog("INFO: %v - JobId: %v - %v. %v message sent for broadcast: %v with status: %v and response: %v\n",
+			utils.FileLine(),
+			ctx.Jid(),
+			drtv.Data[t.i]["phonenumber"],
+			smsPrvdr.name(),
+			psdid,
+			smsRsp.StatusCode,
+			smsRsp.Body)
+
+		if errCls == cntSMSErrNone {
+			rcptDone(t.i, cntRcptSent)
+			mtx.Lock()
+			jbRslt.Segments = jbRslt.Segments + t.seg.Segments
+			mtx.Unlock()
+			lines = jobLog(lines, fmt.Sprintf(
+				"%v message sent to: %v (shortlink: %v) with status: %v",
+				smsPrvdr.name(),
+				drtv.Data[t.i]["phonenumber"],
+				t.shrtlnk.ShortLink,
+				smsRsp.StatusCode))
+		} else {
+			rcptDone(t.i, cntRcptFailed)
+			lines = jobLog(lines, fmt.Sprintf(
+				"%v message to: %v (shortlink: %v) failed with status: %v - %v error %v: %v (attempts: %v)",
+				smsPrvdr.name(),
+				drtv.Data[t.i]["phonenumber"],
+				t.shrtlnk.ShortLink,
+				smsRsp.StatusCode,
+				errCls,
+				smsRsp.ErrCode,
+				smsRsp.ErrMsg,
+				sndAtt))
+
+			// Record a failure caused by the recipient (bad number: skipped by future broadcasts; opted out: stop listed)
+			switch errCls {
+			case cntSMSErrBadNumber:
+				err = setUndeliverablePhone(t.req.To, drtv.Data[t.i]["documentid"], smsRsp.ErrCode, smsRsp.ErrMsg)
+			case cntSMSErrOptOut:
+				err = setStopPhone(t.req.To, "carrier:"+smsRsp.ErrCode, true)
+			default:
+				err = nil
+			}
+			if err != nil {
+				appLog("ERROR: %v - JobId: %v - %v\n", utils.FileLine(), ctx.Jid(), err)
+			}
+		}
+
+		// Parse the provider call data into a Go object
+		tMap := make(map[string]interface{})
+		tMap["from"] = t.req.From
+		tMap["to"] = t.req.To
+		tMap["body"] = t.req.Body
+		tMap["mediaurl"] = t.req.MediaURL
+
+		// Create an smsmessage object
+		sms := newSMSMessage(
+			bson.ObjectIdHex(drtv.PromoSendID),
+			tMap,
+			smsRsp.StatusCode,
+			smsRsp.Status,
+			smsRsp.Body,
+			t.shrtlnk.ShortLink,
+			t.shrtlnk.ShortCode,
+			ctx.Jid())
+
+		// Record the provider's id of the message and the class of a failure
+		sms.Provider = smsPrvdr.name()
+		sms.ProviderMsgID = smsRsp.MessageID
+		sms.ProviderErrClass = errCls
+		sms.SendAttempts = sndAtt
+		sms.Encoding = t.seg.Encoding
+		sms.Segments = t.seg.Segments
+
+		// Flag the sms object indicating if the underlying promotion is an xPathway generic promotion
+		sms.XPathIsGenericPromo = flgGenPromo
+
+		// Persist the smsmessage to the database
+		err = sms.persistSMSMsg(cfg.WorkerCurEnv)
+		if err != nil {
+			// error occurred saving an smsmessage object to the database
+			appLog("ERROR: %v - JobId: %v - %v - %v. See: %v\n",
+				utils.FileLine(),
+				ctx.Jid(),
+				"error occurred saving an smsmessage object",
+				sms.ID.Hex(),
+				err)
+		}
+	}
+
+	// Start the pool of senders; each waits on the rate limiter before sending a text and drops the text (left
+	// for a resumed run - its shortlink is removed) if the job is halted while it waits
+	for s := 0; s < nsnd; s++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				if !lmtr.wait(halt) {
+					dropShortLink(t.shrtlnk)
+					continue
+				}
+				sendText(t)
+			}
+		}()
+	}
+
+	// Iterate through the message data, handing the texts to the senders
 	ntrvlCtr = 0
 	for i := 0; i < limit; i++ {
+		// Recipient handled by an earlier run of the job?
+		if drtv.ChunkProgress.handled(i) {
+			continue
+		}
+
 		// SHOULD JOB BE HALTED? CHECK RELATED WEB SERVICE
 		// Check the promotionsend id - halt job?
 		if ntrvlCtr > cfg.WorkerSMSHaltJobInterval {
 			// Exceeded the interval threshold
-			// Check if job should be halted
-			if stopWorker([]string{cfg.WorkerCurEnv, psdid, ctx.Jid()}) {
+			// Check if job should be halted (halt directive or cancelled broadcast)
+			if stopWorker([]string{cfg.WorkerCurEnv, psdid, ctx.Jid()}) || drtvCancelled(jdtid) {
 				appLog("HALT: %v - halting job: %v before sending phone #%v - %v\n",
 					utils.FileLine(),
 					ctx.Jid(),
 					i,
 					drtv.Data[i]["phonenumber"])
-				loglines = jobLog(loglines, "halting job: %v before sending phone #%v - %v",
+				rcptLogs[i] = jobLog(rcptLogs[i], "halting job: %v before sending phone #%v - %v",
 					ctx.Jid(),
 					i,
 					drtv.Data[i]["phonenumber"])
@@ -664,11 +988,12 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 				}
 
 				jberr = fmt.Errorf("job halted before sending phone #%v - %v", i, drtv.Data[i]["phonenumber"])
+				jbRslt.Status = cntChunkStatusHalted
+				close(halt) // senders waiting on the rate limiter drop their texts
 				break
 			}
 
-			ntrvlCtr = 0
-			continue
+			ntrvlCtr = 0 // not halted - carry on with this recipient
 		}
 		ntrvlCtr++
 
@@ -690,10 +1015,11 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 				"invalid phone number",
 				drtv.Data[i]["phonenumber"])
 
-			loglines = jobLog(loglines,
+			rcptLogs[i] = jobLog(rcptLogs[i],
 				fmt.Sprintf("invalid phone number: %v for customer #%v",
 					drtv.Data[i]["phonenumber"], i))
 
+			rcptDone(i, cntRcptSkipped)
 			continue // skip to next customer
 		}
 		phn = hlprTransPhone(drtv.Data[i]["phonenumber"])
@@ -704,28 +1030,24 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 				ctx.Jid(),
 				"missing message copy/text")
 
-			loglines = jobLog(loglines,
+			rcptLogs[i] = jobLog(rcptLogs[i],
 				fmt.Sprintf("missing message copy/text for phone number: %v - customer #%v",
 					drtv.Data[i]["phonenumber"], i))
 
+			rcptDone(i, cntRcptSkipped)
 			continue // skip to next customer
 		}
-		msg = drtv.Message
-		if len(drtv.Data[i]["firstname"]) != 0 && !flgGenPromo {
-			// firstname has been specified and NOT an xPathway generic message, add "salutation" to the text message
-			msg = fmt.Sprintf("Hey %v! %v", drtv.Data[i]["firstname"], msg)
-		}
-
 		//* Skip phone numbers on the stop phonenumber list
 		rslt, err := cache.Get(fmt.Sprintf("+1%v", phn))
 		if err == nil && rslt != nil {
 			// phone number is on the stoplist - skip
-			loglines = jobLog(loglines, fmt.Sprintf(
+			rcptLogs[i] = jobLog(rcptLogs[i], fmt.Sprintf(
 				"Phone number: %v is on the xPathway stop list. Skipping", drtv.Data[i]["phonenumber"]))
 
 			// Log this event to the main web application
 			go logSkipStopPhoneNumber(drtv.Data[i]["phonenumber"])
 
+			rcptDone(i, cntRcptStopListed)
 			continue // skip number
 		}
 		if err != nil && err != bigcache.ErrEntryNotFound {
@@ -735,144 +1057,132 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 				fmt.Sprintf("+1%v", phn),
 				err)
 
-			loglines = jobLog(loglines, fmt.Sprintf(
+			rcptLogs[i] = jobLog(rcptLogs[i], fmt.Sprintf(
 				"Error checking the stop phone number cache for number: %v. Skipping", drtv.Data[i]["phonenumber"]))
 
+			rcptDone(i, cntRcptSkipped)
 			continue // skip number
 		}
 
+		//* Skip phone numbers rejected as undeliverable by an earlier broadcast (e.g. invalid or landline numbers)
+		if undlvr[fmt.Sprintf("+1%v", phn)] {
+			rcptLogs[i] = jobLog(rcptLogs[i], fmt.Sprintf(
+				"Phone number: %v was rejected as undeliverable by an earlier broadcast. Skipping", drtv.Data[i]["phonenumber"]))
+
+			rcptDone(i, cntRcptSkipped)
+			continue // skip number
+		}
+
+		//* Defer recipients outside their (local) call hours to a follow-up job
+		if loc := recipientZone(drtv.Data[i]); !bvTest && !inCallHours(loc, time.Now()) {
+			rcptLogs[i] = jobLog(rcptLogs[i], fmt.Sprintf(
+				"Phone number: %v is outside its call hours (%v). Deferring", drtv.Data[i]["phonenumber"], loc))
+
+			dfrd = append(dfrd, i)
+			rcptDone(i, cntRcptDeferred)
+			continue // defer number
+		}
+
 		//* Generate the text specific (unique at the promotion/phone number/text level) shortlink
-		shrtlnk = newShortLink(cfg.WorkerCurEnv)
-		err = shrtlnk.saveToDB()
+		shrtlnk, err = newShortLink(cfg.WorkerCurEnv)
+		if err == nil {
+			err = shrtlnk.saveToDB()
+		}
 		if err != nil {
-			// error occurred saving the shortlink to the database - log error but keep processing
-			appLog("ERROR: %v - error occurred saving the shortlink: %v to the database. See: %v\n",
+			// error creating or saving the shortlink - skip the recipient (an unsaved shortcode may not be unique)
+			appLog("ERROR: %v - error occurred creating or saving a shortlink to the database. See: %v\n",
 				utils.FileLine(),
-				shrtlnk.ShortLink,
 				err)
+
+			rcptLogs[i] = jobLog(rcptLogs[i], fmt.Sprintf(
+				"Phone number: %v - error creating a shortlink: %v. Skipping", drtv.Data[i]["phonenumber"], err))
+
+			rcptDone(i, cntRcptSkipped)
+			continue // skip number
 		}
 
-		//* Replace the text message's placeholder characters with the generated shortlink
-		msg = strings.Replace(msg, cntThreePipesPlaceholder, shrtlnk.ShortLink, 1)
+		//* Render the text message for the recipient with the generated shortlink (a merged message carries one per promotion)
+		msg, err = mt.Render(msgtemplate.Vars(drtv.Data[i], shrtlnk.ShortLink, shrtlnk.ShortCode, drtv.OwnerName, drtv.PromoExpiry))
+		if err != nil {
+			// message template can't be rendered for the recipient
+			appLog("WARN: %v - JobId: %v - %v. See: %v\n", utils.FileLine(), ctx.Jid(), drtv.Data[i]["phonenumber"], err)
+
+			rcptLogs[i] = jobLog(rcptLogs[i], fmt.Sprintf(
+				"Phone number: %v - %v. Skipping", drtv.Data[i]["phonenumber"], err))
 
-		// Construct the parameters to be sent via the HTTP POST
-		urlVals := url.Values{}
+			dropShortLink(shrtlnk)
+			rcptDone(i, cntRcptSkipped)
+			continue // skip number
+		}
+
+		// Work out the encoding and segments of the message (the queue rejects or warns of long messages at enqueue)
+		seg := smssegments.Analyze(msg)
+		if cfg.WorkerMaxSegments > 0 && seg.Segments > cfg.WorkerMaxSegments {
+			rcptLogs[i] = jobLog(rcptLogs[i], fmt.Sprintf(
+				"warning: message to: %v takes %v %v segments (limit: %v)",
+				drtv.Data[i]["phonenumber"],
+				seg.Segments,
+				seg.Encoding,
+				cfg.WorkerMaxSegments))
+		}
+
+		// Construct the text message to be sent
+		smsReq = smsRequest{
+			From:           cfg.WorkerTwilioNumber,
+			To:             fmt.Sprintf("+1%v", phn),
+			Body:           msg,
+			MediaURL:       drtv.MediaURL,
+			StatusCallback: cfg.WorkerStatusCallbackURL,
+		}
 
 		//* Override the Twilio from number if specified
-		urlVals.Set("From", cfg.WorkerTwilioNumber)
 		if rgxPhoneTwilioFormat.MatchString(drtv.TwilioOverridePhoneNum) {
 			appLog("DEBUG: %v - override telephone number is: %v\n", utils.FileLine(), drtv.TwilioOverridePhoneNum)
 			// a valid formatted Twilio override number exists -> use instead of the default
-			urlVals.Set("From", drtv.TwilioOverridePhoneNum)
-		}
-		urlVals.Set("To", fmt.Sprintf("+1%v", phn))
-		urlVals.Set("Body", msg)
-		if len(drtv.MediaURL) != 0 {
-			// Media URL is included - add to Twilio request
-			urlVals.Set("MediaUrl", drtv.MediaURL)
+			smsReq.From = drtv.TwilioOverridePhoneNum
 		}
 
-		reqURL = twilioConstructMessageURL
 		// Send Twilio request to a test server (stub out Twilio)?
-		if cfg.WorkerStubTwilio {
-			// * NOTE: DEPRECATED - USE THE FAUX TWILIO NUMBER FOR STUB TWILIO CALLS (SEE TWILIO DOCUMENTATION)
-			// yes, stubbing the Twilio API
-			reqURL = cfg.WorkerStubTwilioTestURL
-
+		if cfg.WorkerStubTwilio && smsPrvdr.name() == cntSMSProviderTwilio {
+			// * NOTE: DEPRECATED - USE THE FAKE SMS PROVIDER (WorkerSMSProvider: 'fake') FOR LOCAL AND TEST RUNS
 			appLog("INFO: %v - JobId: %v - trigger text web service message for phone number: %v - stubbing Twilio API calls to a test (faux) server: %v\n",
 				utils.FileLine(),
 				ctx.Jid(),
 				phn,
 				cfg.WorkerStubTwilioTestURL)
 
-			loglines = jobLog(loglines,
+			rcptLogs[i] = jobLog(rcptLogs[i],
 				fmt.Sprintf("note: making fake Twilio request to: %v - real text message should not occur",
 					cfg.WorkerStubTwilioTestURL))
 		}
 
-		// Configure an HTTP POST
-		spew.Dump(urlVals)
-		resp, err = http.PostForm(reqURL, urlVals)
-		if err != nil {
-			appLog("ERROR: %v - JobId: %v - %v. See: %v\n",
-				utils.FileLine(),
-				ctx.Jid(),
-				"error posting text message to Twilio",
-				err)
-
-			loglines = jobLog(loglines,
-				fmt.Sprintf("error posting text message: %v to Twilio - %v",
-					drtv.Data[i]["phonenumber"], err))
-
-			continue
-		}
-		defer resp.Body.Close()
-
-		// Read Twilio's response body
-		bBytes, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
-			appLog("ERROR: %v - JobId: %v - %v. See: %v\n",
-				utils.FileLine(),
-				ctx.Jid(),
-				"error reading Twilio's response",
-				err)
-
-			loglines = jobLog(loglines,
-				fmt.Sprintf("unknown Twilio response for number: %v - %v",
-					drtv.Data[i]["phonenumber"], err))
-
-			time.Sleep(dur) // Wait before sending next text message
-			continue
-		}
-
-		appLog("INFO: %v - JobId: %v - %v. Twilio message sent for broadcast: %v with status: %v and response: %v\n",
-			utils.FileLine(),
-			ctx.Jid(),
-			drtv.Data[i]["phonenumber"],
-			psdid,
-			resp.StatusCode,
-			string(bBytes))
-
-		loglines = jobLog(loglines, fmt.Sprintf(
-			"Twilio message sent to: %v (shortlink: %v) with status: %v",
-			drtv.Data[i]["phonenumber"],
-			shrtlnk.ShortLink,
-			resp.StatusCode))
-
-		// Parse the Twilio call json data into a Go object
-		tMap := make(map[string]interface{})
-		tMap["from"] = urlVals.Get("From")
-		tMap["to"] = urlVals.Get("To")
-		tMap["body"] = urlVals.Get("Body")
-		tMap["mediaurl"] = urlVals.Get("MediaUrl")
-
-		// Create an smsmessage object
-		sms := newSMSMessage(
-			bson.ObjectIdHex(drtv.PromoSendID),
-			tMap,
-			resp.StatusCode,
-			resp.Status,
-			string(bBytes),
-			shrtlnk.ShortLink,
-			shrtlnk.ShortCode,
-			ctx.Jid())
+		// Hand the text message to the senders
+		tasks <- sendTask{i: i, req: smsReq, seg: seg, shrtlnk: shrtlnk}
+	}
 
-		// Flag the sms object indicating if the underlying promotion is an xPathway generic promotion
-		sms.XPathIsGenericPromo = flgGenPromo
+	// Wait for the senders to finish the texts handed to them, then write the recipients' log lines in order
+	close(tasks)
+	wg.Wait()
+	for _, lines := range rcptLogs {
+		loglines = append(loglines, lines...)
+	}
 
-		// Persist the smsmessage to the database
-		err = sms.persistSMSMsg(cfg.WorkerCurEnv)
-		if err != nil {
-			// error occurred saving an smsmessage object to the database
-			appLog("ERROR: %v - JobId: %v - %v - %v. See: %v\n",
-				utils.FileLine(),
-				ctx.Jid(),
-				"error occurred saving an smsmessage object",
-				sms.ID.Hex(),
-				err)
+	// Queue follow-up jobs texting the deferred recipients when their call hours open (one job per opening time)
+	if len(dfrd) != 0 {
+		grps, ats := deferredRecipients(drtv.Data, dfrd, time.Now())
+		for _, at := range ats {
+			fjid, err := deferRecipients(jdtid, grps[at], at)
+			if err != nil {
+				appLog("ERROR: %v - JobId: %v - %v\n", utils.FileLine(), ctx.Jid(), err)
+				loglines = jobLog(loglines, "error deferring %v recipient(s) to %v - %v", len(grps[at]), at.In(timePT).Format(time.RFC3339), err)
+			}
+			if len(fjid) != 0 {
+				loglines = jobLog(loglines, "deferred %v recipient(s) outside their call hours to job: %v at: %v",
+					len(grps[at]),
+					fjid,
+					at.In(timePT).Format(time.RFC3339))
+			}
 		}
-
-		time.Sleep(dur) // Wait before next text message
 	}
 
 	// Push a Faktory job that generates QR codes for sms messages sent by this processing job: ctx.Jid
@@ -889,6 +1199,7 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 			"error pushing a QR encoding worker job on a queue",
 			err)
 	} else {
+		jbRslt.QRJobID = job.Jid
 		appLog("INFO: %v - JobId: %v - job: %v (%v) pushed to create QR codes for sms messages sent by this executing job: %v\n",
 			utils.FileLine(),
 			ctx.Jid(),
@@ -911,6 +1222,7 @@ func wkrFirePromoSendSMSMsgs(fnCtx context.Context, args ...interface{}) error {
 			"error pushing a an SMS message snapshot job",
 			err)
 	} else {
+		jbRslt.SnapJobID = job.Jid
 		appLog("INFO: %v - JobId: %v - job: %v (%v) pushed to create sms data snapshots for messages sent by this executing job: %v\n",
 			utils.FileLine(),
 			ctx.Jid(),
@@ -990,13 +1302,28 @@ func main() {
 	mgoCollShortLinkStore = mgoDB.C("shortlinkstore")        // collection storing generated shortlinks
 	mgoMgoCollPromoCustTexts = mgoDB.C("promocustomertexts") // collection storing promotion/customer/text instances or combinations
 	mgoCollStopPhoneList = mgoDB.C("stopphonelist")          // collection housing phone numbers that do NOT get text messages
+	mgoCollTextEvents = mgoDB.C("promocustomertextevents")   // collection storing events (e.g. opt-outs) logged for promotion/customer/texts
+	mgoCollUndeliverable = mgoDB.C("undeliverablephones")    // collection housing phone numbers rejected as undeliverable (e.g. landlines)
+
+	// Ensure the shortcodes of the stored shortlinks are unique (exactly and by case)
+	err = ensureShortLinkIndexes()
+	if err != nil {
+		// error creating the indexes - shortcode collisions wouldn't be detected
+		appLog("FATAL: %v - %v\n", utils.FileLine(), err)
+		os.Exit(1)
+	}
 
 	// Fire goroutine used to execute cron jobs
 	go schedJobs()
 
-	// Configure the Twilio URL
-	twilioConstructMessageURL = twilioURLElement1 + cfg.WorkerTwilioAccountSID + twilioURLElement2 + cfg.WorkerTwilioAuthToken +
-		twilioURLElement3 + cfg.WorkerTwilioAccountSID + twilioURLElement4
+	// Configure the SMS provider sending the text messages
+	smsPrvdr, err = newSMSProvider(cfg)
+	if err != nil {
+		// error configuring the SMS provider
+		appLog("FATAL: %v - error configuring the SMS provider. See: %v\n", utils.FileLine(), err)
+		os.Exit(1)
+	}
+	appLog("INFO: %v - sending text messages via the '%v' SMS provider\n", utils.FileLine(), smsPrvdr.name())
 
 	// Close the MongoDB session at the end of processing
 	defer mgoSession.Close()
@@ -1020,8 +1347,11 @@ func main() {
 	// Register the PromoSendSMSMessage worker
 	mgr.Register(cmn.CntWorkerNameSendSMSMessages, wkrFirePromoSendSMSMsgs)
 
-	// use up to N goroutines
-	mgr.Concurrency = 5
+	// use up to N goroutines (the number the enqueue service's send schedule assumes)
+	mgr.Concurrency = cfg.WorkerSMSConcurrency
+	if mgr.Concurrency < 1 {
+		mgr.Concurrency = 1
+	}
 
 	// pull jobs from these queues
 	mgr.ProcessStrictPriorityQueues("critical", cmn.CntWorkerNameSendSMSMessages)
@@ -1039,6 +1369,21 @@ func main() {
 	// Configure a status route
 	r.GET("/status", cmnWrkr.HndlrStatus)
 
+	// Configure the webhook receiving Twilio's delivery status callbacks (if configured)
+	if len(cfg.WorkerStatusCallbackURL) != 0 {
+		r.POST("/twilio/status", mdlTwilioSignature(cfg.WorkerStatusCallbackURL), hdlTwilioStatus)
+	}
+
+	// Configure the webhook receiving texts sent to our numbers (opt-out, opt-in and help keywords) (if configured)
+	if len(cfg.WorkerInboundSMSURL) != 0 {
+		r.POST("/twilio/inbound", mdlTwilioSignature(cfg.WorkerInboundSMSURL), hdlTwilioInbound)
+	}
+
+	// Configure a route listing the text messages accepted by the fake SMS provider (if in use) - signed requests only
+	if cfg.WorkerSMSProvider == cntSMSProviderFake {
+		r.GET("/fakemessages", mdlAuth(cfg.WorkerAuthKeys, time.Duration(cfg.WorkerAuthWindowSec)*time.Second), hdlFakeMessages)
+	}
+
 	// Start the "wrapping" web server
 	log.Printf("INFO: %v - starting the 'wrapping' web server...\n", utils.FileLine())
 	r.Run(fmt.Sprintf(":%v", port))
@@ -0,0 +1,134 @@
+// modelChunkResult.go models the outcome of a chunk job, recorded on its job directive so the enqueue
+// service can flag the promotion broadcast as sent once all of its chunk jobs have finished, and the job's
+// per recipient progress, checkpointed so a resumed job skips the recipients already handled
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/danoand/utils"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// chunkResult models the outcome of a chunk job
+type chunkResult struct {
+	Status     string    `bson:"status" json:"status"`         // 'done', 'halted' or 'failed'
+	Sent       int       `bson:"sent" json:"sent"`             // messages accepted by Twilio
+	Failed     int       `bson:"failed" json:"failed"`         // messages rejected by (or not posted to) Twilio
+	Skipped    int       `bson:"skipped" json:"skipped"`       // recipients skipped (e.g. invalid phone number)
+	StopListed int       `bson:"stoplisted" json:"stoplisted"` // recipients on the stop list
+	Deferred   int       `bson:"deferred" json:"deferred"`     // recipients outside their call hours (texted by follow-up jobs)
+	Segments   int       `bson:"segments" json:"segments"`     // segments of the messages accepted by Twilio (by this run of the job)
+	QRJobID    string    `bson:"qrjobid" json:"qrjobid"`       // job generating the QR codes of the chunk's messages
+	SnapJobID  string    `bson:"snapjobid" json:"snapjobid"`   // job taking snapshots of the chunk's messages
+	FinishTime time.Time `bson:"finishtime" json:"finishtime"` // time the chunk job finished
+}
+
+// chunkProgress models the per recipient progress of a chunk job checkpointed on its job directive
+type chunkProgress struct {
+	LastIndex int                         `bson:"lastindex" json:"lastindex"` // index (in the directive's data) of the last recipient handled
+	Outcomes  map[string]recipientOutcome `bson:"outcomes" json:"outcomes"`   // outcome of each recipient handled (keyed by index)
+}
+
+// recipientOutcome models the outcome of a single recipient of a chunk job
+type recipientOutcome struct {
+	Phone   string    `bson:"phone" json:"phone"`     // recipient phone number
+	Outcome string    `bson:"outcome" json:"outcome"` // 'sent', 'failed', 'skipped', 'stoplisted' or 'deferred'
+	Time    time.Time `bson:"time" json:"time"`       // time the recipient was handled
+}
+
+// count adds a recipient's outcome to the chunk's counts
+func (rslt *chunkResult) count(outcome string) {
+	switch outcome {
+	case cntRcptSent:
+		rslt.Sent++
+	case cntRcptFailed:
+		rslt.Failed++
+	case cntRcptStopListed:
+		rslt.StopListed++
+	case cntRcptDeferred:
+		rslt.Deferred++
+	default:
+		rslt.Skipped++
+	}
+}
+
+// handled returns true if a recipient was handled by an earlier run of the chunk job
+func (prg chunkProgress) handled(i int) bool {
+	_, ok := prg.Outcomes[strconv.Itoa(i)]
+	return ok
+}
+
+// recordRecipient checkpoints the outcome of a recipient (by index) on its job directive
+func recordRecipient(jdtid string, i int, phone, outcome string) {
+	err := mgoCollWorkerJobData.Update(
+		bson.M{"idstring": jdtid},
+		bson.M{
+			"$set": bson.M{fmt.Sprintf("chunkprogress.outcomes.%v", i): recipientOutcome{Phone: phone, Outcome: outcome, Time: time.Now().UTC()}},
+			"$max": bson.M{"chunkprogress.lastindex": i},
+		})
+	if err != nil {
+		// error checkpointing the recipient - a resumed job would text the recipient again
+		appLog("ERROR: %v - error checkpointing recipient #%v (%v) of job directive: %v. See: %v\n",
+			utils.FileLine(),
+			i,
+			phone,
+			jdtid,
+			err)
+	}
+}
+
+// recordChunkStart records the time a chunk job started on its job directive
+func recordChunkStart(jdtid string) {
+	err := mgoCollWorkerJobData.Update(bson.M{"idstring": jdtid}, bson.M{"$set": bson.M{"chunkstarttime": time.Now().UTC()}})
+	if err != nil {
+		// error recording the start of the chunk job
+		appLog("ERROR: %v - error recording the start of job directive: %v. See: %v\n",
+			utils.FileLine(),
+			jdtid,
+			err)
+	}
+}
+
+// recordChunkResult records the outcome of a chunk job on its job directive; a job ending with an error
+// (jberr) that wasn't halted is recorded as failed
+func recordChunkResult(jdtid string, rslt *chunkResult, jberr *error) {
+	var (
+		err error
+		set bson.M
+	)
+
+	// Job rescheduled? Its outcome is recorded by the rescheduled run
+	if rslt.Status == cntChunkStatusRescheduled {
+		return
+	}
+
+	if len(rslt.Status) == 0 {
+		rslt.Status = cntChunkStatusDone
+		if *jberr != nil {
+			rslt.Status = cntChunkStatusFailed
+		}
+	}
+	rslt.FinishTime = time.Now().UTC()
+
+	// No QR code or snapshot job to wait for?  (those jobs flag the directive themselves when they finish)
+	set = bson.M{"chunkresult": rslt}
+	if len(rslt.QRJobID) == 0 {
+		set["chunkqrdone"] = true
+	}
+	if len(rslt.SnapJobID) == 0 {
+		set["chunksnapdone"] = true
+	}
+
+	err = mgoCollWorkerJobData.Update(bson.M{"idstring": jdtid}, bson.M{"$set": set})
+	if err != nil {
+		// error recording the outcome of the chunk job
+		appLog("ERROR: %v - error recording the outcome (%v) of job directive: %v. See: %v\n",
+			utils.FileLine(),
+			rslt.Status,
+			jdtid,
+			err)
+	}
+}
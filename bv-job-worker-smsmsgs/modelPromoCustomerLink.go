@@ -2,27 +2,94 @@
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"math/big"
+	"strings"
 	"time"
 
 	"github.com/danoand/utils"
+	mgo "github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 )
 
-// List of possible shortlink characters
-const charset = "0123456789" + "abcdefghijklmnopqrstuvwxyz" + "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+// List of possible shortlink characters - drops the characters easily misread or mistyped at a POS (0/O/o, 1/l/I/i)
+const charset = "23456789" + "abcdefghjkmnpqrstuvwxyz" + "ABCDEFGHJKLMNPQRSTUVWXYZ"
 
-// Random process seed used to randomly create a shortlink code
-var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+// List of possible check characters - the case folded shortlink characters (a check character is computed on the
+// case folded shortcode so a code typed in any case can be checked)
+const checkCharset = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// StringWithCharset creates a random string of characters with a specified length using a specified set of
+// characters (drawn from a cryptographically secure source)
+func StringWithCharset(length int, charset string) (string, error) {
+	var max = big.NewInt(int64(len(charset)))
 
-// StringWithCharset creates a random string of characters with a specified length using a specified set of characters
-func StringWithCharset(length int, charset string) string {
 	b := make([]byte, length)
 	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			// error reading the random source
+			return "", fmt.Errorf("error reading a random number; see: %v", err)
+		}
+		b[i] = charset[n.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// shortCodeCheckChar computes the check character of a shortcode (Luhn mod N on the case folded shortcode); the
+// point of sale verifies a typed shortcode's check character with the same algorithm before looking it up
+func shortCodeCheckChar(code string) byte {
+	var (
+		sum    int
+		factor = 2
+		n      = len(checkCharset)
+	)
+
+	code = strings.ToUpper(code)
+	for i := len(code) - 1; i >= 0; i-- {
+		add := factor * strings.IndexByte(checkCharset, code[i])
+		sum = sum + add/n + add%n
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+	}
+
+	return checkCharset[(n-sum%n)%n]
+}
+
+// newShortCode creates a random shortcode (with a check character if configured)
+func newShortCode() (string, error) {
+	code, err := StringWithCharset(cntShortCodeLen, charset)
+	if err != nil {
+		return "", err
+	}
+	if cfg.WorkerShortCodeCheckChar {
+		code = code + string(shortCodeCheckChar(code))
+	}
+
+	return code, nil
+}
+
+// ensureShortLinkIndexes creates the unique indexes on the shortcodes of the stored shortlinks: the exact and the
+// case folded shortcode (shortlinks stored before the case folded shortcode was recorded are not indexed)
+func ensureShortLinkIndexes() error {
+	err := mgoCollShortLinkStore.EnsureIndex(mgo.Index{Key: []string{"shortcode"}, Unique: true, Background: true})
+	if err != nil {
+		// error creating the index
+		return fmt.Errorf("error creating the unique shortcode index on the shortlink store; see: %v", err)
+	}
+
+	err = mgoCollShortLinkStore.EnsureIndex(mgo.Index{Key: []string{"shortcodeuppercase"}, Unique: true, Sparse: true, Background: true})
+	if err != nil {
+		// error creating the index
+		return fmt.Errorf("error creating the unique case folded shortcode index on the shortlink store; see: %v", err)
 	}
-	return string(b)
+
+	return nil
 }
 
 // shortLinkObj models objects that "save" used shortlinks
@@ -33,20 +100,29 @@ type shortLinkObj struct {
 	ShortCode   string        `bson:"shortcode" json:"shortcode"`
 	ShortLink   string        `bson:"shortlink" json:"shortlink"`
 	Environment string        `bson:"environment" json:"environment"`
+
+	// Case folded shortcode (unique: codes differing only by case are never both issued)
+	ShortCodeUpperCase string `bson:"shortcodeuppercase" json:"shortcodeuppercase"`
 }
 
 // newShortLink creates a new shortlink
-func newShortLink(env string) *shortLinkObj {
+func newShortLink(env string) (*shortLinkObj, error) {
 	var (
+		err     error
 		retCode string
 		link    shortLinkObj
 	)
 
 	// Create a random shortcode that serves as the "path" of the shortlink URL
-	retCode = StringWithCharset(7, charset)
+	retCode, err = newShortCode()
+	if err != nil {
+		// error creating a shortcode
+		return nil, err
+	}
 
 	link.ID = bson.NewObjectId()
 	link.ShortCode = retCode
+	link.ShortCodeUpperCase = strings.ToUpper(retCode)
 	link.DateTime = time.Now().In(timePT)
 	link.Environment = env
 
@@ -68,11 +144,12 @@ func newShortLink(env string) *shortLinkObj {
 
 	link.ShortLink = link.BaseURL + retCode
 
-	return &link
+	return &link, nil
 }
 
-// saveToDB is a method on shortLinkObj that saves the object to the database
-func (sl shortLinkObj) saveToDB() error {
+// saveToDB is a method on shortLinkObj that saves the object to the database; a shortcode colliding with a stored
+// shortcode (exactly or by case) is replaced by a new shortcode and the save is retried
+func (sl *shortLinkObj) saveToDB() error {
 	var (
 		err      error
 		nullTime time.Time
@@ -95,10 +172,35 @@ func (sl shortLinkObj) saveToDB() error {
 		sl.DateTime = time.Now().In(timePT)
 	}
 
-	// Insert the object into the database
-	err = mgoCollShortLinkStore.Insert(sl)
-	if err != nil {
-		return fmt.Errorf("error inserting a shortlink object to the database: %v", err)
+	// Insert the object into the database (with a new shortcode if the shortcode is taken)
+	for try := 1; ; try++ {
+		err = mgoCollShortLinkStore.Insert(sl)
+		if err == nil {
+			return nil
+		}
+		if !mgo.IsDup(err) || try == cntShortCodeMaxTries {
+			return fmt.Errorf("error inserting a shortlink object to the database (attempts: %v): %v", try, err)
+		}
+
+		// shortcode collision - try a new shortcode
+		appLog("WARN: %v - shortcode: %v is taken - retrying with a new shortcode\n", utils.FileLine(), sl.ShortCode)
+		var code string
+		code, err = newShortCode()
+		if err != nil {
+			return fmt.Errorf("error creating a shortcode: %v", err)
+		}
+		sl.ShortCode = code
+		sl.ShortCodeUpperCase = strings.ToUpper(code)
+		sl.ShortLink = sl.BaseURL + code
+	}
+}
+
+// removeFromDB is a method on shortLinkObj that deletes the object from the database (a shortlink generated for a
+// text message that is never sent)
+func (sl *shortLinkObj) removeFromDB() error {
+	err := mgoCollShortLinkStore.RemoveId(sl.ID)
+	if err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("error removing shortlink: %v from the database; see: %v", sl.ShortCode, err)
 	}
 
 	return nil
@@ -159,7 +261,7 @@ func (pct promoCustomerTextObj) saveToDB(env string) error {
 // promoCustomerTextEvent models an event logged for a promoCustomerTextObj
 type promoCustomerTextEvent struct {
 	ID                 bson.ObjectId          `bson:"_id" json:"docid"`                             // document id
-	PromoCustTextDocID promoCustomerTextObj   `bson:"promocusttextdocid" json:"promocusttextdocid"` // promoCustomerTextObj document id
+	PromoCustTextDocID bson.ObjectId          `bson:"promocusttextdocid" json:"promocusttextdocid"` // promoCustomerTextObj document id
 	SMSDocID           bson.ObjectId          `bson:"smsdocid" json:"smsdocid"`                     // SMS Message document id
 	PsendDocID         bson.ObjectId          `bson:"psenddocid" json:"psenddocid"`                 // promotion broadcast (send) document id
 	PromoDocID         bson.ObjectId          `bson:"promodocid" json:"promodocid"`                 // promotion document id
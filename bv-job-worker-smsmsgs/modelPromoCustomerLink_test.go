@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkShortCode verifies the check character ending a shortcode as a point of sale would (in any case)
+func checkShortCode(code string) bool {
+	return len(code) > 1 && strings.ToUpper(code[len(code)-1:])[0] == shortCodeCheckChar(code[:len(code)-1])
+}
+
+func TestShortCodeCheckCharVectors(t *testing.T) {
+	var tests = []struct {
+		code  string
+		check byte
+	}{
+		{"2222222", '2'},
+		{"ABCDEFG", '9'},
+		{"xY7kPq9", 'X'},
+		{"Hn4tRwZ", '9'},
+	}
+
+	for _, tt := range tests {
+		if got := shortCodeCheckChar(tt.code); got != tt.check {
+			t.Errorf("shortCodeCheckChar(%q) = %q; want %q", tt.code, got, tt.check)
+		}
+		if got := shortCodeCheckChar(strings.ToLower(tt.code)); got != tt.check {
+			t.Errorf("shortCodeCheckChar(%q) = %q; want %q (case folded)", strings.ToLower(tt.code), got, tt.check)
+		}
+	}
+}
+
+func TestShortCodeCheckCharRoundTrip(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		code, err := StringWithCharset(cntShortCodeLen, charset)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		full := code + string(shortCodeCheckChar(code))
+
+		if !checkShortCode(full) || !checkShortCode(strings.ToLower(full)) || !checkShortCode(strings.ToUpper(full)) {
+			t.Fatalf("shortcode %q fails its own check character", full)
+		}
+
+		// Every mistyped character is caught
+		for p := 0; p < len(code); p++ {
+			for _, c := range checkCharset {
+				if byte(c) == strings.ToUpper(code[p : p+1])[0] {
+					continue
+				}
+				typo := full[:p] + string(c) + full[p+1:]
+				if checkShortCode(typo) {
+					t.Errorf("mistyped shortcode %q (of %q) passes the check", typo, full)
+				}
+			}
+		}
+
+		// Every swap of adjacent characters is caught (Luhn mod N can't tell the first and last check characters apart)
+		for p := 0; p+1 < len(code); p++ {
+			a, b := strings.ToUpper(code[p:p+1]), strings.ToUpper(code[p+1:p+2])
+			if a == b || a+b == "2Z" || a+b == "Z2" {
+				continue
+			}
+			swap := full[:p] + full[p+1:p+2] + full[p:p+1] + full[p+2:]
+			if checkShortCode(swap) {
+				t.Errorf("swapped shortcode %q (of %q) passes the check", swap, full)
+			}
+		}
+	}
+}
+
+func TestShortCodeCharsets(t *testing.T) {
+	for _, c := range "01OoIil" {
+		if strings.ContainsRune(charset, c) {
+			t.Errorf("shortcode charset includes the easily misread character %q", c)
+		}
+	}
+	for _, c := range charset {
+		if !strings.ContainsRune(checkCharset, []rune(strings.ToUpper(string(c)))[0]) {
+			t.Errorf("shortcode character %q has no case folded check character", c)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/danoand/utils"
 
+	mgo "github.com/globalsign/mgo"
 	bson "github.com/globalsign/mgo/bson"
 )
 
@@ -29,6 +30,31 @@ type smsmessage struct {
 	IsRedeemed           bool                   `bson:"isredeemed" json:"isredeemed"`                                   // indicates if this SMS Message has been redeemed
 	JobID                string                 `bson:"jobid" json:"jobid"`                                             // Job id of the job that sent/fired the sms message
 	XPathIsGenericPromo  bool                   `bson:"promogenxpathisgenericpromo" json:"promogenxpathisgenericpromo"` // indicates that the underlying promo is an xPathway generic promotion
+
+	// SMS provider details (see smsprovider.go)
+	Provider         string `bson:"provider" json:"provider"`                 // provider that sent the message (e.g. 'twilio')
+	ProviderMsgID    string `bson:"providermsgid" json:"providermsgid"`       // provider's id of the message (e.g. Twilio's message sid)
+	ProviderErrClass string `bson:"providererrclass" json:"providererrclass"` // class of a failed send: 'transient', 'unknown', 'permanent', 'badnumber', 'optout' (empty: accepted)
+	SendAttempts     int    `bson:"sendattempts" json:"sendattempts"`         // attempts to send the message (a transient failure is retried)
+
+	// Encoding and segments of the message (see smssegments.go)
+	Encoding string `bson:"encoding" json:"encoding"` // 'GSM-7' or 'UCS-2'
+	Segments int    `bson:"segments" json:"segments"` // segments the message is sent (and billed) as
+
+	// Delivery status reported by the provider's status callbacks (see webhookfuncs.go)
+	DeliveryStatus  string          `bson:"deliverystatus" json:"deliverystatus"`   // latest status: 'queued', 'sent', 'delivered', 'undelivered', 'failed'
+	DeliveryErrCode string          `bson:"deliveryerrcode" json:"deliveryerrcode"` // provider's error code of an undelivered or failed message
+	DeliveryRank    int             `bson:"deliveryrank" json:"deliveryrank"`       // progress of the latest status (see cntDeliveryRanks)
+	DeliveryTime    time.Time       `bson:"deliverytime" json:"deliverytime"`       // time the latest status was reported
+	DeliveryEvents  []deliveryEvent `bson:"deliveryevents" json:"deliveryevents"`   // every status reported (in the order received)
+}
+
+// deliveryEvent models a delivery status reported by the provider for a text message
+type deliveryEvent struct {
+	Status  string    `bson:"status" json:"status"`   // reported status (e.g. 'delivered')
+	ErrCode string    `bson:"errcode" json:"errcode"` // provider's error code (if any)
+	ErrMsg  string    `bson:"errmsg" json:"errmsg"`   // provider's error message (if any)
+	Time    time.Time `bson:"time" json:"time"`       // time the status was received
 }
 
 // newSMSMessage is a function that creates a new smsmessage object
@@ -93,3 +119,41 @@ func countSMSSends(pid bson.ObjectId) (int, error) {
 
 	return num, err
 }
+
+// recordDeliveryEvent records a delivery status reported for the text message with a provider message id;
+// callbacks can arrive out of order so the latest status only moves forward (e.g. 'sent' never replaces
+// 'delivered'); returns false if no message has the id
+func recordDeliveryEvent(msgid string, evt deliveryEvent) (bool, error) {
+	var (
+		err  error
+		rank = cntDeliveryRanks[evt.Status]
+	)
+
+	// Append the event
+	err = mgoMgoCollSMSMessages.Update(
+		bson.M{"providermsgid": msgid},
+		bson.M{"$push": bson.M{"deliveryevents": evt}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		// error recording the event
+		return false, fmt.Errorf("error recording delivery status: %v of message: %v; see: %v", evt.Status, msgid, err)
+	}
+
+	// Move the latest status forward
+	err = mgoMgoCollSMSMessages.Update(
+		bson.M{"providermsgid": msgid, "deliveryrank": bson.M{"$not": bson.M{"$gt": rank}}},
+		bson.M{"$set": bson.M{
+			"deliverystatus":  evt.Status,
+			"deliveryerrcode": evt.ErrCode,
+			"deliveryrank":    rank,
+			"deliverytime":    evt.Time,
+		}})
+	if err != nil && err != mgo.ErrNotFound {
+		// error recording the latest status
+		return true, fmt.Errorf("error recording the latest delivery status: %v of message: %v; see: %v", evt.Status, msgid, err)
+	}
+
+	return true, nil
+}
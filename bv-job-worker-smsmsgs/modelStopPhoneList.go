@@ -0,0 +1,190 @@
+// modelStopPhoneList.go maintains the stop phone number list (stopphonelist) from the opt-out, opt-in and help
+// keywords recipients text back, and logs the keyword against the promotion text the recipient replied to; it
+// also maintains the phone numbers rejected as undeliverable (undeliverablephones) that broadcasts skip
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	mgo "github.com/globalsign/mgo"
+	bson "github.com/globalsign/mgo/bson"
+)
+
+// cntKeywordActions maps the carrier standard (CTIA) keywords to the action they request
+var cntKeywordActions = map[string]string{
+	"STOP":        cntKeywordOptOut,
+	"STOPALL":     cntKeywordOptOut,
+	"UNSUBSCRIBE": cntKeywordOptOut,
+	"CANCEL":      cntKeywordOptOut,
+	"END":         cntKeywordOptOut,
+	"QUIT":        cntKeywordOptOut,
+	"OPTOUT":      cntKeywordOptOut,
+	"REVOKE":      cntKeywordOptOut,
+	"START":       cntKeywordOptIn,
+	"UNSTOP":      cntKeywordOptIn,
+	"YES":         cntKeywordOptIn,
+	"OPTIN":       cntKeywordOptIn,
+	"HELP":        cntKeywordHelp,
+	"INFO":        cntKeywordHelp,
+}
+
+// parseKeyword returns the keyword and requested action of an inbound text message; carriers match the whole
+// message, ignoring case, surrounding spaces and punctuation (e.g. 'Stop.' or ' opt-out') - returns an empty
+// action if the message isn't a keyword
+func parseKeyword(body string) (string, string) {
+	var kwd = strings.ToUpper(strings.TrimSpace(body))
+
+	kwd = strings.Trim(kwd, ".!?,;:'\" ")
+	kwd = strings.Replace(kwd, "-", "", -1)
+
+	return kwd, cntKeywordActions[kwd]
+}
+
+// setStopPhone adds (opt-out) or removes (opt-in) a phone number (Twilio format: +15555551212) on the stop list
+func setStopPhone(phone, kwd string, stop bool) error {
+	_, err := mgoCollStopPhoneList.Upsert(
+		bson.M{"phonetwilioformat": phone},
+		bson.M{"$set": bson.M{
+			"phonetwilioformat": phone,
+			"phonenumber":       hlprStripPlusOne(phone),
+			"stopmessages":      stop,
+			"stopkeyword":       kwd,
+			"stopupdatetime":    time.Now().UTC(),
+		}})
+	if err != nil {
+		// error updating the stop list
+		return fmt.Errorf("error updating phone number: %v on the stop list (stop: %v); see: %v", phone, stop, err)
+	}
+
+	return nil
+}
+
+// logKeywordEvent logs a keyword texted by a recipient as an event of the latest promotion text sent to the
+// recipient (if one can be found); returns false if no promotion text was found
+func logKeywordEvent(phone, kwd, action string, content map[string]interface{}) (bool, error) {
+	var (
+		err   error
+		sms   smsmessage
+		pct   promoCustomerTextObj
+		evt   promoCustomerTextEvent
+		psend struct {
+			PromoID    bson.ObjectId `bson:"psendpromoid"`
+			PromoClass string        `bson:"psendpromoclass"`
+		}
+	)
+
+	// Find the latest text sent to the recipient
+	err = mgoMgoCollSMSMessages.Find(bson.M{"mobilephoneto": hlprStripPlusOne(phone)}).Sort("-time").One(&sms)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		// error fetching the text
+		return false, fmt.Errorf("error fetching the latest text sent to: %v; see: %v", phone, err)
+	}
+
+	// Default the document ids that may not be found
+	evt.PromoDocID = bson.ObjectIdHex(cntDefaultObjectID)
+	evt.CustDocID = bson.ObjectIdHex(cntDefaultObjectID)
+	evt.PromoCustTextDocID = bson.ObjectIdHex(cntDefaultObjectID)
+
+	evt.ID = bson.NewObjectId()
+	evt.SMSDocID = sms.ID
+	evt.PsendDocID = sms.PromotionSendID
+	evt.PhoneNumber = hlprStripPlusOne(phone)
+	evt.ShortLink = sms.ShortLink
+	evt.ShortCode = sms.ShortCode
+	evt.EventType = cntKeywordEventTypes[action]
+	evt.EventTime = time.Now().In(timePT)
+	evt.EventContent = content
+	evt.EventContent["keyword"] = kwd
+
+	// Fetch the promotion of the broadcast
+	err = mgoMgoCollPromoBroadcasts.FindId(sms.PromotionSendID).Select(bson.M{"psendpromoid": 1, "psendpromoclass": 1}).One(&psend)
+	if err != nil && err != mgo.ErrNotFound {
+		// error fetching the broadcast
+		return false, fmt.Errorf("error fetching promotion broadcast: %v; see: %v", sms.PromotionSendID.Hex(), err)
+	}
+	if psend.PromoID.Valid() {
+		evt.PromoDocID = psend.PromoID
+	}
+	evt.PromoType = psend.PromoClass
+
+	// Fetch the promotion/customer/text combination (if recorded)
+	err = mgoMgoCollPromoCustTexts.Find(bson.M{"smsdocid": sms.ID}).One(&pct)
+	if err != nil && err != mgo.ErrNotFound {
+		// error fetching the promotion/customer/text combination
+		return false, fmt.Errorf("error fetching the promotion/customer/text of sms message: %v; see: %v", sms.ID.Hex(), err)
+	}
+	if err == nil {
+		evt.PromoCustTextDocID = pct.ID
+		if pct.CustDocID.Valid() {
+			evt.CustDocID = pct.CustDocID
+		}
+	}
+
+	err = mgoCollTextEvents.Insert(evt)
+	if err != nil {
+		// error logging the event
+		return false, fmt.Errorf("error logging a %v event for sms message: %v; see: %v", evt.EventType, sms.ID.Hex(), err)
+	}
+	if pct.ID.Valid() {
+		err = mgoMgoCollPromoCustTexts.UpdateId(pct.ID, bson.M{"$set": bson.M{"hasevents": true}})
+		if err != nil {
+			// error flagging the promotion/customer/text as having events
+			return true, fmt.Errorf("error flagging promotion/customer/text: %v as having events; see: %v", pct.ID.Hex(), err)
+		}
+	}
+
+	return true, nil
+}
+
+// setUndeliverablePhone records a phone number (Twilio format: +15555551212) an SMS provider rejected because of
+// the number itself (e.g. invalid or landline) against the customer, so future broadcasts skip it
+func setUndeliverablePhone(phone, custid, code, msg string) error {
+	_, err := mgoCollUndeliverable.Upsert(
+		bson.M{"phonetwilioformat": phone},
+		bson.M{
+			"$set": bson.M{
+				"phonetwilioformat": phone,
+				"phonenumber":       hlprStripPlusOne(phone),
+				"custdocid":         custid,
+				"undeliverable":     true,
+				"errcode":           code,
+				"errmsg":            msg,
+				"lastfailtime":      time.Now().UTC(),
+			},
+			"$inc": bson.M{"numfailures": 1},
+		})
+	if err != nil {
+		// error recording the undeliverable phone number
+		return fmt.Errorf("error recording phone number: %v as undeliverable; see: %v", phone, err)
+	}
+
+	return nil
+}
+
+// fetchUndeliverablePhones fetches the phone numbers (Twilio format) rejected as undeliverable by earlier broadcasts
+func fetchUndeliverablePhones() (map[string]bool, error) {
+	var (
+		err    error
+		phones = make(map[string]bool)
+		docs   []struct {
+			Phone string `bson:"phonetwilioformat"`
+		}
+	)
+
+	err = mgoCollUndeliverable.Find(bson.M{"undeliverable": true}).Select(bson.M{"phonetwilioformat": 1}).All(&docs)
+	if err != nil {
+		// error fetching the undeliverable phone numbers
+		return phones, fmt.Errorf("error fetching the undeliverable phone numbers; see: %v", err)
+	}
+
+	for _, d := range docs {
+		phones[d.Phone] = true
+	}
+
+	return phones, nil
+}
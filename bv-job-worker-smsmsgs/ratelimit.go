@@ -0,0 +1,74 @@
+// ratelimit.go paces the text messages a chunk job sends with a token bucket: tokens accrue at a rate (messages
+// per second) up to a burst and each send takes one, so a pool of concurrent senders never exceeds the rate
+// however quickly the SMS provider responds
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket models a token bucket rate limiter shared by a chunk job's senders
+type tokenBucket struct {
+	mtx    sync.Mutex
+	rate   float64   // tokens accrued per second (0: no limit)
+	burst  float64   // most tokens the bucket holds (texts sent at once after an idle spell)
+	tokens float64   // tokens in the bucket (negative: tokens reserved by waiting senders)
+	last   time.Time // time the tokens were last accrued
+}
+
+// newTokenBucket creates a full token bucket accruing tokens at a rate (per second) up to a burst
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns the wait before it can be used
+func (tb *tokenBucket) reserve() time.Duration {
+	if tb.rate <= 0 {
+		// no limit
+		return 0
+	}
+
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+
+	now := time.Now()
+	tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// wait blocks until a token can be used; returns false if the stop channel is closed first (e.g. the job
+// was halted while the sender waited)
+func (tb *tokenBucket) wait(stop <-chan struct{}) bool {
+	var dur = tb.reserve()
+
+	if dur <= 0 {
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+
+	tmr := time.NewTimer(dur)
+	defer tmr.Stop()
+
+	select {
+	case <-tmr.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
@@ -0,0 +1,385 @@
+// smsprovider.go models the carriers (SMS providers) that send the text messages of a promotion broadcast: Twilio,
+// and an in-memory fake used to run the worker end to end without texting anyone
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// smsRequest models a text message to be sent by an SMS provider
+type smsRequest struct {
+	From           string `json:"from"`           // sending phone number (e.g. +15555551212)
+	To             string `json:"to"`             // recipient phone number (e.g. +15555551212)
+	Body           string `json:"body"`           // text of the message
+	MediaURL       string `json:"mediaurl"`       // url of an image sent with the message (MMS) (empty: none)
+	StatusCallback string `json:"statuscallback"` // url the provider reports delivery statuses to (empty: none)
+}
+
+// smsResponse models an SMS provider's response to a request to send a text message
+type smsResponse struct {
+	StatusCode int    // http status code of the response (201: message accepted)
+	Status     string // http status line of the response (e.g. '201 Created')
+	Body       string // raw response body
+	MessageID  string // provider's id of the message (e.g. Twilio's message sid) (set by parse)
+	ErrCode    string // provider's error code (set by parse)
+	ErrMsg     string // provider's error message (set by parse)
+}
+
+// smsProvider is implemented by carriers that send text messages
+type smsProvider interface {
+	// name returns the name of the provider (e.g. 'twilio')
+	name() string
+
+	// send posts a text message to the provider; an error is returned only if no response was received
+	send(req smsRequest) (smsResponse, error)
+
+	// parse reads the message id or error details out of a response body
+	parse(rsp *smsResponse) error
+
+	// classify classifies the outcome of a send: cntSMSErrNone (accepted), cntSMSErrTransient (not accepted - safe
+	// to retry), cntSMSErrUnknown (may have been accepted), cntSMSErrPermanent, cntSMSErrBadNumber or cntSMSErrOptOut
+	classify(rsp smsResponse, err error) string
+}
+
+// smsPrvdr is the provider sending the worker's text messages (see WorkerSMSProvider)
+var smsPrvdr smsProvider
+
+// newSMSProvider returns the SMS provider named by the configuration
+func newSMSProvider(spec Specification) (smsProvider, error) {
+	switch spec.WorkerSMSProvider {
+
+	// CASE: Twilio (posting to a test server if stubbing Twilio)
+	case cntSMSProviderTwilio:
+		var tw = &twilioProvider{
+			accountSID: spec.WorkerTwilioAccountSID,
+			authToken:  spec.WorkerTwilioAuthToken,
+			msgURL:     fmt.Sprintf(cntTwilioMessagesURL, spec.WorkerTwilioAccountSID),
+			client:     &http.Client{Timeout: cntSMSProviderTimeout},
+		}
+		if spec.WorkerStubTwilio {
+			tw.msgURL = spec.WorkerStubTwilioTestURL
+		}
+		return tw, nil
+
+	// CASE: in-memory fake
+	case cntSMSProviderFake:
+		return &fakeProvider{}, nil
+
+	// DEFAULT: unknown provider
+	default:
+		return nil, fmt.Errorf("unknown sms provider: '%v' (expecting '%v' or '%v')",
+			spec.WorkerSMSProvider,
+			cntSMSProviderTwilio,
+			cntSMSProviderFake)
+	}
+}
+
+// twilioProvider sends text messages via Twilio's Messages API
+type twilioProvider struct {
+	accountSID string       // Twilio account sid
+	authToken  string       // Twilio auth token
+	msgURL     string       // url of the account's Messages resource
+	client     *http.Client // http client posting requests to Twilio
+}
+
+// name returns the name of the provider
+func (tw *twilioProvider) name() string {
+	return cntSMSProviderTwilio
+}
+
+// send posts a text message to Twilio
+func (tw *twilioProvider) send(req smsRequest) (smsResponse, error) {
+	var (
+		err    error
+		hreq   *http.Request
+		hrsp   *http.Response
+		bBytes []byte
+		rsp    smsResponse
+		vals   = url.Values{}
+	)
+
+	vals.Set("From", req.From)
+	vals.Set("To", req.To)
+	vals.Set("Body", req.Body)
+	if len(req.MediaURL) != 0 {
+		// Media URL is included - add to Twilio request
+		vals.Set("MediaUrl", req.MediaURL)
+	}
+	if len(req.StatusCallback) != 0 {
+		// Have Twilio report the message's delivery statuses
+		vals.Set("StatusCallback", req.StatusCallback)
+	}
+
+	hreq, err = http.NewRequest(http.MethodPost, tw.msgURL, strings.NewReader(vals.Encode()))
+	if err != nil {
+		// error creating the request
+		return rsp, fmt.Errorf("error creating a Twilio request; see: %v", err)
+	}
+	hreq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	hreq.SetBasicAuth(tw.accountSID, tw.authToken)
+
+	hrsp, err = tw.client.Do(hreq)
+	if err != nil {
+		// error posting the message
+		return rsp, fmt.Errorf("error posting text message to Twilio; see: %w", err)
+	}
+	defer hrsp.Body.Close()
+
+	bBytes, err = ioutil.ReadAll(hrsp.Body)
+	if err != nil {
+		// error reading the response
+		return rsp, fmt.Errorf("error reading Twilio's response; see: %v", err)
+	}
+
+	rsp.StatusCode = hrsp.StatusCode
+	rsp.Status = hrsp.Status
+	rsp.Body = string(bBytes)
+
+	return rsp, nil
+}
+
+// parse reads the message sid or error details out of a Twilio response body
+func (tw *twilioProvider) parse(rsp *smsResponse) error {
+	var (
+		err  error
+		body struct {
+			SID     string `json:"sid"`
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+	)
+
+	err = json.Unmarshal([]byte(rsp.Body), &body)
+	if err != nil {
+		// response is not Twilio json (e.g. a stub server's response)
+		return fmt.Errorf("error parsing Twilio's response: %v; see: %v", rsp.Body, err)
+	}
+
+	rsp.MessageID = body.SID
+	if body.Code != 0 {
+		rsp.ErrCode = fmt.Sprintf("%v", body.Code)
+	}
+	rsp.ErrMsg = body.Message
+
+	return nil
+}
+
+// classify classifies the outcome of a Twilio send
+func (tw *twilioProvider) classify(rsp smsResponse, err error) string {
+	switch {
+
+	// CASE: no response and the message never reached Twilio (e.g. connection refused, DNS failure)
+	case err != nil && notPosted(err):
+		return cntSMSErrTransient
+
+	// CASE: no response - Twilio may have accepted the message (e.g. timeout, connection reset)
+	case err != nil:
+		return cntSMSErrUnknown
+
+	// CASE: message accepted
+	case rsp.StatusCode == http.StatusCreated:
+		return cntSMSErrNone
+
+	// CASE: a Twilio error code with a known class (e.g. recipient replied STOP, landline number, queue overflow)
+	case len(twilioErrClasses[rsp.ErrCode]) != 0:
+		return twilioErrClasses[rsp.ErrCode]
+
+	// CASE: rate limited or Twilio unavailable - the message wasn't accepted
+	case rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode == http.StatusServiceUnavailable:
+		return cntSMSErrTransient
+
+	// CASE: a Twilio server error - the message may have been accepted
+	case rsp.StatusCode >= http.StatusInternalServerError:
+		return cntSMSErrUnknown
+
+	// DEFAULT: rejected (e.g. a problem with the sending number or account)
+	default:
+		return cntSMSErrPermanent
+	}
+}
+
+// twilioErrClasses maps the Twilio error codes returned when creating a message to their class (see Twilio's
+// error dictionary); codes not listed are classified by the response's status code
+var twilioErrClasses = map[string]string{
+	"20429":                  cntSMSErrTransient, // too many requests
+	"20500":                  cntSMSErrUnknown,   // internal server error (the message may have been accepted)
+	"20503":                  cntSMSErrTransient, // service unavailable
+	"21611":                  cntSMSErrTransient, // 'From' number has exceeded the maximum number of queued messages
+	"30001":                  cntSMSErrTransient, // queue overflow
+	"21211":                  cntSMSErrBadNumber, // invalid 'To' phone number
+	"21217":                  cntSMSErrBadNumber, // phone number does not appear to be valid
+	"21407":                  cntSMSErrBadNumber, // phone number type does not support SMS (e.g. landline)
+	"21612":                  cntSMSErrTransient, // 'To' phone number is not currently reachable via SMS (e.g. roaming or switched off)
+	"21614":                  cntSMSErrBadNumber, // 'To' number is not a valid mobile number
+	cntTwilioErrUnsubscribed: cntSMSErrOptOut,    // recipient replied STOP to the sending number
+}
+
+// notPosted reports whether an error sending a request means the request never reached the provider (the
+// connection couldn't be made), so the message can be sent again without texting the recipient twice
+func notPosted(err error) bool {
+	var oerr *net.OpError
+
+	return errors.As(err, &oerr) && oerr.Op == "dial"
+}
+
+// sendWithRetry sends a text message, retrying a send that fails with a transient error (the provider didn't
+// accept the message) up to WorkerSendMaxRetries times with an exponential backoff; a send with an unknown
+// outcome is not retried, and the wait before a retry is cut short if the stop channel is closed (e.g. the job
+// was halted); returns the provider's last response, its class, and the number of attempts
+func sendWithRetry(prv smsProvider, req smsRequest, stop <-chan struct{}) (smsResponse, string, int, error) {
+	var (
+		err  error
+		rsp  smsResponse
+		cls  string
+		wait = time.Duration(cfg.WorkerSendRetryBaseMs) * time.Millisecond
+	)
+
+	for att := 1; ; att++ {
+		rsp, err = prv.send(req)
+		if err == nil {
+			// Read the message id (or error details) out of the provider's response
+			perr := prv.parse(&rsp)
+			if perr != nil {
+				appLog("WARN: %v - %v\n", utils.FileLine(), perr)
+			}
+		}
+		cls = prv.classify(rsp, err)
+
+		// Done? (sent, failed with a non transient error, or out of retries)
+		if cls != cntSMSErrTransient || att > cfg.WorkerSendMaxRetries {
+			return rsp, cls, att, err
+		}
+
+		appLog("WARN: %v - transient error sending a text message to: %v via %v (attempt %v - status: %v, error %v: %v %v) - retrying in %v\n",
+			utils.FileLine(),
+			req.To,
+			prv.name(),
+			att,
+			rsp.StatusCode,
+			rsp.ErrCode,
+			rsp.ErrMsg,
+			err,
+			wait)
+
+		tmr := time.NewTimer(wait)
+		select {
+		case <-tmr.C:
+		case <-stop:
+			// stopped while waiting - give up on the send
+			tmr.Stop()
+			return rsp, cls, att, err
+		}
+
+		wait = wait * 2
+		if wait > cntSendRetryMaxWait {
+			wait = cntSendRetryMaxWait
+		}
+	}
+}
+
+// fakeProvider accepts text messages without sending them and keeps them in memory; Twilio's magic test
+// numbers fail as they do with Twilio's test credentials (e.g. +15005550001: invalid number)
+type fakeProvider struct {
+	mtx      sync.Mutex
+	msgs     []fakeMessage
+	throttle int // sends rejected as rate limited (429) before messages are accepted again (e.g. to exercise retries)
+}
+
+// fakeMessage models a text message accepted by the fake provider
+type fakeMessage struct {
+	smsRequest
+	MessageID  string    `json:"messageid"`  // fake message id
+	StatusCode int       `json:"statuscode"` // status code returned for the message
+	Time       time.Time `json:"time"`       // time the message was accepted
+}
+
+// fakeFailures maps Twilio's magic test numbers to the error (code and message) they fail with
+var fakeFailures = map[string][2]string{
+	"+15005550001": {"21211", "The 'To' number is not a valid phone number."},
+	"+15005550004": {cntTwilioErrUnsubscribed, "The message From/To pair violates a blacklist rule."},
+	"+15005550009": {"21614", "The 'To' number is not a valid mobile number."},
+}
+
+// name returns the name of the provider
+func (fk *fakeProvider) name() string {
+	return cntSMSProviderFake
+}
+
+// send accepts a text message
+func (fk *fakeProvider) send(req smsRequest) (smsResponse, error) {
+	var (
+		rsp = smsResponse{StatusCode: http.StatusCreated}
+		msg = fakeMessage{smsRequest: req, Time: time.Now().UTC()}
+	)
+
+	fk.mtx.Lock()
+	defer fk.mtx.Unlock()
+
+	if fk.throttle > 0 {
+		// rate limited - the message isn't accepted
+		fk.throttle--
+		rsp.StatusCode = http.StatusTooManyRequests
+		rsp.Status = fmt.Sprintf("%v %v", rsp.StatusCode, http.StatusText(rsp.StatusCode))
+		rsp.Body = `{"code": 20429, "message": "Too Many Requests", "status": 429}`
+		return rsp, nil
+	}
+
+	msg.MessageID = fmt.Sprintf("SMfake%026d", len(fk.msgs)+1)
+	if fl, ok := fakeFailures[req.To]; ok {
+		// magic number - fail the message
+		rsp.StatusCode = http.StatusBadRequest
+		rsp.Body = fmt.Sprintf(`{"code": %v, "message": %q, "status": 400}`, fl[0], fl[1])
+	} else {
+		rsp.Body = fmt.Sprintf(`{"sid": %q, "status": "queued"}`, msg.MessageID)
+	}
+	rsp.Status = fmt.Sprintf("%v %v", rsp.StatusCode, http.StatusText(rsp.StatusCode))
+	msg.StatusCode = rsp.StatusCode
+
+	fk.msgs = append(fk.msgs, msg)
+
+	return rsp, nil
+}
+
+// parse reads the message id or error details out of a fake response body (which mirrors Twilio's)
+func (fk *fakeProvider) parse(rsp *smsResponse) error {
+	return (&twilioProvider{}).parse(rsp)
+}
+
+// classify classifies the outcome of a fake send (as Twilio would)
+func (fk *fakeProvider) classify(rsp smsResponse, err error) string {
+	return (&twilioProvider{}).classify(rsp, err)
+}
+
+// messages returns the text messages accepted by the fake provider
+func (fk *fakeProvider) messages() []fakeMessage {
+	fk.mtx.Lock()
+	defer fk.mtx.Unlock()
+
+	return append([]fakeMessage(nil), fk.msgs...)
+}
+
+// hdlFakeMessages handles a request listing the text messages accepted by the fake provider
+func hdlFakeMessages(c *gin.Context) {
+	fk, ok := smsPrvdr.(*fakeProvider)
+	if !ok {
+		// not using the fake provider
+		c.JSON(http.StatusNotFound, gin.H{"msg": "the fake sms provider is not in use"})
+		return
+	}
+
+	msgs := fk.messages()
+	appLog("INFO: %v - returning %v fake text messages\n", utils.FileLine(), len(msgs))
+	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("%v fake text messages", len(msgs)), "messages": msgs})
+}
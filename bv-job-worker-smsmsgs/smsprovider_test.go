@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFakeProviderClassify(t *testing.T) {
+	var tests = []struct {
+		to      string
+		status  int
+		errCode string
+		class   string
+	}{
+		{"+15555551212", http.StatusCreated, "", cntSMSErrNone},
+		{"+15005550001", http.StatusBadRequest, "21211", cntSMSErrBadNumber},
+		{"+15005550004", http.StatusBadRequest, cntTwilioErrUnsubscribed, cntSMSErrOptOut},
+		{"+15005550009", http.StatusBadRequest, "21614", cntSMSErrBadNumber},
+	}
+
+	fk := &fakeProvider{}
+	for _, tt := range tests {
+		rsp, err := fk.send(smsRequest{From: "+15005550006", To: tt.to, Body: "test"})
+		if err != nil {
+			t.Fatalf("send to %v: unexpected error: %v", tt.to, err)
+		}
+		err = fk.parse(&rsp)
+		if err != nil {
+			t.Fatalf("parse of the response to %v: unexpected error: %v", tt.to, err)
+		}
+		if rsp.StatusCode != tt.status || rsp.ErrCode != tt.errCode {
+			t.Errorf("send to %v: got status %v, error code %q; want %v, %q", tt.to, rsp.StatusCode, rsp.ErrCode, tt.status, tt.errCode)
+		}
+		if cls := fk.classify(rsp, nil); cls != tt.class {
+			t.Errorf("classify send to %v: got %q; want %q", tt.to, cls, tt.class)
+		}
+		if tt.class == cntSMSErrNone && len(rsp.MessageID) == 0 {
+			t.Errorf("send to %v: accepted message has no message id", tt.to)
+		}
+	}
+
+	if n := len(fk.messages()); n != len(tests) {
+		t.Errorf("fake provider kept %v messages; want %v", n, len(tests))
+	}
+}
+
+func TestSendWithRetry(t *testing.T) {
+	var tests = []struct {
+		name       string
+		to         string
+		throttle   int
+		maxRetries int
+		class      string
+		attempts   int
+		accepted   int
+	}{
+		{"accepted", "+15555551212", 0, 2, cntSMSErrNone, 1, 1},
+		{"bad number not retried", "+15005550001", 0, 2, cntSMSErrBadNumber, 1, 1},
+		{"opted out not retried", "+15005550004", 0, 2, cntSMSErrOptOut, 1, 1},
+		{"rate limited then accepted", "+15555551212", 2, 2, cntSMSErrNone, 3, 1},
+		{"rate limited out of retries", "+15555551212", 3, 2, cntSMSErrTransient, 3, 0},
+		{"no retries", "+15555551212", 1, 0, cntSMSErrTransient, 1, 0},
+	}
+
+	defer func(spec Specification) { cfg = spec }(cfg)
+	cfg.WorkerSendRetryBaseMs = 1
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.WorkerSendMaxRetries = tt.maxRetries
+			fk := &fakeProvider{throttle: tt.throttle}
+
+			_, cls, att, err := sendWithRetry(fk, smsRequest{From: "+15005550006", To: tt.to, Body: "test"}, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cls != tt.class || att != tt.attempts {
+				t.Errorf("got class %q after %v attempts; want %q after %v", cls, att, tt.class, tt.attempts)
+			}
+			if n := len(fk.messages()); n != tt.accepted {
+				t.Errorf("fake provider kept %v messages; want %v", n, tt.accepted)
+			}
+		})
+	}
+}
+
+func TestTwilioClassify(t *testing.T) {
+	var tests = []struct {
+		name   string
+		status int
+		body   string
+		class  string
+	}{
+		{"accepted", http.StatusCreated, `{"sid": "SM123", "status": "queued"}`, cntSMSErrNone},
+		{"rate limited", http.StatusTooManyRequests, `{"code": 20429, "message": "Too Many Requests", "status": 429}`, cntSMSErrTransient},
+		{"unavailable", http.StatusServiceUnavailable, `{"code": 20503, "message": "Service Unavailable", "status": 503}`, cntSMSErrTransient},
+		{"server error", http.StatusInternalServerError, `{"code": 20500, "message": "Internal Server Error", "status": 500}`, cntSMSErrUnknown},
+		{"bad gateway", http.StatusBadGateway, `<html>Bad Gateway</html>`, cntSMSErrUnknown},
+		{"not reachable", http.StatusBadRequest, `{"code": 21612, "message": "The 'To' phone number is not currently reachable", "status": 400}`, cntSMSErrTransient},
+		{"landline", http.StatusBadRequest, `{"code": 21407, "message": "This Phone Number type does not support SMS", "status": 400}`, cntSMSErrBadNumber},
+		{"account suspended", http.StatusUnauthorized, `{"code": 20003, "message": "Authenticate", "status": 401}`, cntSMSErrPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			tw := &twilioProvider{msgURL: srv.URL, client: srv.Client()}
+			rsp, err := tw.send(smsRequest{From: "+15005550006", To: "+15555551212", Body: "test"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tw.parse(&rsp)
+			if cls := tw.classify(rsp, nil); cls != tt.class {
+				t.Errorf("got class %q; want %q", cls, tt.class)
+			}
+		})
+	}
+}
+
+func TestTwilioClassifyNoResponse(t *testing.T) {
+	// Connection refused - the message never reached Twilio
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addr := lsn.Addr().String()
+	lsn.Close()
+
+	tw := &twilioProvider{msgURL: "http://" + addr, client: &http.Client{Timeout: time.Second}}
+	rsp, err := tw.send(smsRequest{From: "+15005550006", To: "+15555551212", Body: "test"})
+	if err == nil {
+		t.Fatalf("send to a closed port: expected an error")
+	}
+	if cls := tw.classify(rsp, err); cls != cntSMSErrTransient {
+		t.Errorf("connection refused: got class %q; want %q", cls, cntSMSErrTransient)
+	}
+
+	// Timeout - Twilio may have accepted the message
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	tw = &twilioProvider{msgURL: srv.URL, client: &http.Client{Timeout: 50 * time.Millisecond}}
+	rsp, err = tw.send(smsRequest{From: "+15005550006", To: "+15555551212", Body: "test"})
+	if err == nil {
+		t.Fatalf("send to a hung server: expected an error")
+	}
+	if cls := tw.classify(rsp, err); cls != cntSMSErrUnknown {
+		t.Errorf("timeout: got class %q; want %q", cls, cntSMSErrUnknown)
+	}
+}
+
+func TestSendWithRetryStopped(t *testing.T) {
+	defer func(spec Specification) { cfg = spec }(cfg)
+	cfg.WorkerSendRetryBaseMs = 60000
+	cfg.WorkerSendMaxRetries = 2
+
+	stop := make(chan struct{})
+	close(stop)
+
+	fk := &fakeProvider{throttle: 5}
+	start := time.Now()
+	_, cls, att, err := sendWithRetry(fk, smsRequest{From: "+15005550006", To: "+15555551212", Body: "test"}, stop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cls != cntSMSErrTransient || att != 1 {
+		t.Errorf("got class %q after %v attempts; want %q after 1", cls, att, cntSMSErrTransient)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("stopped send waited %v before giving up", time.Since(start))
+	}
+}
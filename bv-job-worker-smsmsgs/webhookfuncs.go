@@ -0,0 +1,194 @@
+// webhookfuncs.go contains the webhooks Twilio calls back to: message delivery status callbacks and inbound
+// text messages (opt-out, opt-in and help keywords), and the middleware authenticating the service's requests
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danoand/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/whopdan/bv-common/hmacauth"
+)
+
+// mdlTwilioSignature rejects webhook requests not signed by Twilio (X-Twilio-Signature header); the signature
+// is computed over the public url Twilio was given (the service's own view of the url may differ behind a router)
+func mdlTwilioSignature(pubURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sig = c.GetHeader("X-Twilio-Signature")
+
+		err := c.Request.ParseForm()
+		if err != nil {
+			// unreadable request
+			appLog("ERROR: %v - error parsing a Twilio webhook request to: %v. See: %v\n", utils.FileLine(), pubURL, err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		exp := hlprTwilioSignature(cfg.WorkerTwilioAuthToken, pubURL, c.Request.PostForm)
+		if len(sig) == 0 || !hmac.Equal([]byte(sig), []byte(exp)) {
+			// missing or invalid signature
+			appLog("WARN: %v - rejecting a Twilio webhook request to: %v with a missing or invalid signature from: %v\n",
+				utils.FileLine(),
+				pubURL,
+				c.ClientIP())
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// mdlAuth rejects requests not signed with one of the caller keys (key id -> secret), signed outside the replay
+// window or replayed (see github.com/whopdan/bv-common/hmacauth); with no keys configured every request is rejected
+func mdlAuth(keys map[string]string, window time.Duration) gin.HandlerFunc {
+	var vrfr = hmacauth.NewVerifier(keys, window, true)
+
+	return func(c *gin.Context) {
+		_, err := vrfr.Verify(c.Request)
+		if err != nil {
+			appLog("WARN: %v - rejected request: %v %v from: %v (key id: '%v') - %v\n",
+				utils.FileLine(),
+				c.Request.Method,
+				c.Request.URL.Path,
+				c.ClientIP(),
+				c.GetHeader(hmacauth.HdrKeyID),
+				err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "unauthorized", "msg": "request not authenticated"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// hdlTwilioStatus handles a Twilio status callback reporting a change in the delivery status of a text message
+func hdlTwilioStatus(c *gin.Context) {
+	var evt = deliveryEvent{
+		Status:  strings.ToLower(c.PostForm("MessageStatus")),
+		ErrCode: c.PostForm("ErrorCode"),
+		ErrMsg:  c.PostForm("ErrorMessage"),
+		Time:    time.Now().UTC(),
+	}
+	var sid = c.PostForm("MessageSid")
+
+	if len(sid) == 0 || len(evt.Status) == 0 {
+		// missing message sid or status
+		appLog("WARN: %v - Twilio status callback missing a message sid or status (sid: '%v', status: '%v')\n",
+			utils.FileLine(),
+			sid,
+			evt.Status)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	fnd, err := recordDeliveryEvent(sid, evt)
+	if err != nil {
+		// error recording the delivery status
+		appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if !fnd {
+		// not one of our messages (or not stored) - acknowledge so Twilio doesn't flag the callback as failed
+		appLog("WARN: %v - Twilio status callback for unknown message: %v (status: %v)\n", utils.FileLine(), sid, evt.Status)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// twimlResponse models the TwiML response to an inbound text message; Twilio texts the message back to the sender
+type twimlResponse struct {
+	XMLName xml.Name `xml:"Response"`
+	Message string   `xml:"Message,omitempty"` // reply texted back to the sender (empty: no reply)
+}
+
+// replyTwiML responds to an inbound text message with TwiML texting a reply back to the sender (empty: no reply)
+func replyTwiML(c *gin.Context, reply string) {
+	bts, err := xml.Marshal(twimlResponse{Message: reply})
+	if err != nil {
+		// error rendering the reply - respond without one
+		appLog("ERROR: %v - error rendering a TwiML reply. See: %v\n", utils.FileLine(), err)
+		bts, _ = xml.Marshal(twimlResponse{})
+	}
+
+	c.Data(http.StatusOK, "text/xml", append([]byte(xml.Header), bts...))
+}
+
+// hdlTwilioInbound handles a text message sent to one of our numbers; opt-out and opt-in keywords update the
+// stop list, keywords are answered with the configured confirmation reply (returned as TwiML unless Twilio's
+// opt-out handling replies itself, see WorkerCarrierOptOut), and logged against the promotion text the recipient
+// replied to (other messages are ignored)
+func hdlTwilioInbound(c *gin.Context) {
+	var (
+		err    error
+		fnd    bool
+		reply  string
+		from   = c.PostForm("From")
+		to     = c.PostForm("To")
+		body   = c.PostForm("Body")
+		kwd, a = parseKeyword(body)
+	)
+
+	if len(a) == 0 {
+		// not a keyword
+		appLog("INFO: %v - ignoring an inbound text message (not a keyword) from: %v to: %v\n", utils.FileLine(), from, to)
+		replyTwiML(c, "")
+		return
+	}
+	if !rgxPhoneTwilioFormat.MatchString(from) {
+		// sender isn't a US phone number
+		appLog("WARN: %v - ignoring keyword: %v from an unexpected phone number: %v\n", utils.FileLine(), kwd, from)
+		replyTwiML(c, "")
+		return
+	}
+
+	switch a {
+
+	// CASE: opt-out
+	case cntKeywordOptOut:
+		err = setStopPhone(from, kwd, true)
+		reply = cfg.WorkerOptOutReply
+
+	// CASE: opt-in
+	case cntKeywordOptIn:
+		err = setStopPhone(from, kwd, false)
+		reply = cfg.WorkerOptInReply
+
+	// DEFAULT: help
+	default:
+		reply = cfg.WorkerHelpReply
+	}
+	if err != nil {
+		// error updating the stop list
+		appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	appLog("INFO: %v - keyword: %v (%v) received from: %v to: %v\n", utils.FileLine(), kwd, a, from, to)
+
+	// Twilio's opt-out handling confirms the keyword itself (a second reply would duplicate it, or be blocked after STOP)
+	if cfg.WorkerCarrierOptOut {
+		reply = ""
+	}
+
+	// Log the keyword against the promotion text replied to
+	fnd, err = logKeywordEvent(from, kwd, a, map[string]interface{}{
+		"from":       from,
+		"to":         to,
+		"body":       body,
+		"messagesid": c.PostForm("MessageSid"),
+	})
+	if err != nil {
+		appLog("ERROR: %v - %v\n", utils.FileLine(), err)
+	}
+	if err == nil && !fnd {
+		appLog("INFO: %v - no promotion text found for the %v keyword from: %v\n", utils.FileLine(), a, from)
+	}
+
+	replyTwiML(c, reply)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whopdan/bv-common/hmacauth"
+)
+
+func TestReplyTwiML(t *testing.T) {
+	var tests = []struct {
+		reply string
+		want  string
+	}{
+		{"", `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<Response></Response>`},
+		{"Reply STOP to unsubscribe.", `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<Response><Message>Reply STOP to unsubscribe.</Message></Response>`},
+		{"Msg & data rates <may> apply", `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<Response><Message>Msg &amp; data rates &lt;may&gt; apply</Message></Response>`},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		replyTwiML(c, tt.reply)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("replyTwiML(%q) = %q; want %q", tt.reply, got, tt.want)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/xml" {
+			t.Errorf("replyTwiML(%q) content type = %q; want text/xml", tt.reply, ct)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/fakemessages", mdlAuth(map[string]string{"ops": "secret1"}, 5*time.Minute), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/nokeys", mdlAuth(nil, 5*time.Minute), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// signed returns a request signed by a caller
+	signed := func(path, keyID, secret string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		err := hmacauth.Sign(req, nil, keyID, secret)
+		if err != nil {
+			t.Fatalf("error signing a request: %v", err)
+		}
+		return req
+	}
+
+	var tests = []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"signed", signed("/fakemessages", "ops", "secret1"), http.StatusOK},
+		{"unsigned", httptest.NewRequest(http.MethodGet, "/fakemessages", nil), http.StatusUnauthorized},
+		{"wrong secret", signed("/fakemessages", "ops", "secret2"), http.StatusUnauthorized},
+		{"no keys configured", signed("/nokeys", "ops", "secret1"), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, tt.req)
+		if w.Code != tt.status {
+			t.Errorf("%v: got status %v; want %v", tt.name, w.Code, tt.status)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"log"
 
 	"github.com/danoand/utils"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 
 	cmn "github.com/whopdan/wrkrcom"
 )
@@ -46,3 +48,18 @@ func hlprHeartBeat() {
 
 	return
 }
+
+// hlprFlagChunkDone flags the job directive of the bv-job-worker-smsmsgs job (jid) whose messages were processed
+// so the enqueue service's completion tracker knows the snapshot job for those messages has finished
+func hlprFlagChunkDone(jid string) {
+	err := mgoDB.C("workerjobdata").Update(bson.M{"jobid": jid}, bson.M{"$set": bson.M{"chunksnapdone": true}})
+	if err != nil && err != mgo.ErrNotFound {
+		// error flagging the job directive
+		log.Printf("ERROR: %v - error flagging the snapshot job of sms job: %v as done on its job directive. See: %v\n",
+			utils.FileLine(),
+			jid,
+			err)
+	}
+
+	return
+}
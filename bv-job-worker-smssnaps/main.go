@@ -127,6 +127,9 @@ func wkrGenSMSSnapShot(ctx worker.Context, args ...interface{}) error {
 		return fmt.Errorf("document parameter is an unexpected type or is missing")
 	}
 
+	// Flag the sms job's directive when this job ends (successfully or not) for the completion tracker
+	defer hlprFlagChunkDone(jid)
+
 	// Fetch the SMS Messages that have been sent from the given Faktory jobid
 	err = mgoDB.C("smsmessages").Find(bson.M{"jobid": jid}).All(&msgs)
 	if err != nil {